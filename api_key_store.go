@@ -0,0 +1,391 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+)
+
+const (
+	apiKeySourceConfig = "config"
+	apiKeySourceStore  = "store"
+)
+
+// names of the keys created through the api, they end up in logs and headers
+var apiKeyNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+var (
+	errAPIKeyExists   = errors.New("api key already exists")
+	errAPIKeyNotFound = errors.New("api key not found")
+	errAPIKeyInConfig = errors.New("api key set in API_KEYS")
+)
+
+// storedAPIKey
+// A key created through the api, only its hash is kept, written as is in API_KEYS_STORE_PATH
+type storedAPIKey struct {
+	Name      string    `json:"name"`
+	Hash      string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKeyStore
+// The api keys of API_KEYS, replaced on reload, and the ones created and revoked through /api-keys
+// the api is open to anyone while there is none
+type APIKeyStore struct {
+	mu         sync.RWMutex
+	configured []APIKey
+	admins     map[string]bool
+	stored     map[string]storedAPIKey
+	// file the created keys are kept in, memory only if empty
+	path string
+}
+
+// api keys of the callers, set in main
+var apiKeyStore = &APIKeyStore{admins: map[string]bool{}, stored: map[string]storedAPIKey{}}
+
+// newAPIKeyStore
+// The keys created before are read from path if it exists
+func newAPIKeyStore(path string) (*APIKeyStore, error) {
+	store := &APIKeyStore{admins: map[string]bool{}, stored: map[string]storedAPIKey{}, path: path}
+	if path == "" {
+		return store, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read api keys store failed: %w", err)
+	}
+
+	var keys []storedAPIKey
+	err = json.Unmarshal(content, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api keys store `%s`: %w", path, err)
+	}
+	for _, key := range keys {
+		if _, err := hashFromHex(key.Hash); err != nil {
+			return nil, fmt.Errorf("invalid api keys store `%s`: key `%s`: %w", path, key.Name, err)
+		}
+		store.stored[key.Name] = key
+	}
+
+	return store, nil
+}
+
+func hashFromHex(value string) ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+
+	bytes, err := hex.DecodeString(value)
+	if err != nil || len(bytes) != sha256.Size {
+		return hash, fmt.Errorf("expected a hex encoded sha256")
+	}
+	copy(hash[:], bytes)
+
+	return hash, nil
+}
+
+// SetConfigured
+// The keys of API_KEYS and the names of API_ADMIN_KEYS, at startup and on reload
+func (store *APIKeyStore) SetConfigured(keys []APIKey, admins []string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.configured = keys
+	store.admins = map[string]bool{}
+	for _, admin := range admins {
+		store.admins[admin] = true
+	}
+}
+
+// Enabled
+// false while there is no key at all, the api is then open to anyone
+func (store *APIKeyStore) Enabled() bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return len(store.configured) > 0 || len(store.stored) > 0
+}
+
+// IsAdmin
+// true if the client can manage the keys of the store
+func (store *APIKeyStore) IsAdmin(client string) bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.admins[client]
+}
+
+// Authenticate
+// The name of the client the key belongs to, empty if it's unknown
+func (store *APIKeyStore) Authenticate(apiKey string) string {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	keys := make([]APIKey, 0, len(store.configured)+len(store.stored))
+	keys = append(keys, store.configured...)
+	for _, stored := range store.stored {
+		hash, _ := hashFromHex(stored.Hash)
+		keys = append(keys, APIKey{Name: stored.Name, Hash: hash})
+	}
+
+	return authenticateAPIKey(keys, apiKey)
+}
+
+// APIKeyInfo
+// What /api-keys tells about a key, never the key itself
+type APIKeyInfo struct {
+	Name      string     `json:"name"`
+	Source    string     `json:"source"`
+	Admin     bool       `json:"admin"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// List
+// Every key, ordered by name
+func (store *APIKeyStore) List() []APIKeyInfo {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	infos := make([]APIKeyInfo, 0, len(store.configured)+len(store.stored))
+	for _, key := range store.configured {
+		infos = append(infos, APIKeyInfo{Name: key.Name, Source: apiKeySourceConfig, Admin: store.admins[key.Name]})
+	}
+	for _, key := range store.stored {
+		createdAt := key.CreatedAt
+		infos = append(infos, APIKeyInfo{Name: key.Name, Source: apiKeySourceStore, Admin: store.admins[key.Name], CreatedAt: &createdAt})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos
+}
+
+// Create
+// A new random key for the client, it's returned once, only its hash is kept
+func (store *APIKeyStore) Create(name string, now time.Time) (string, error) {
+	random := make([]byte, 24)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", fmt.Errorf("generate api key failed: %w", err)
+	}
+	apiKey := "sk_" + hex.EncodeToString(random)
+	hash := sha256.Sum256([]byte(apiKey))
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.stored[name]; ok || store.configuredLocked(name) {
+		return "", errAPIKeyExists
+	}
+
+	store.stored[name] = storedAPIKey{Name: name, Hash: hex.EncodeToString(hash[:]), CreatedAt: now.UTC()}
+
+	err = store.save()
+	if err != nil {
+		delete(store.stored, name)
+		return "", err
+	}
+
+	return apiKey, nil
+}
+
+// Revoke
+// Remove a key created through the api, the keys of API_KEYS are removed from the config
+func (store *APIKeyStore) Revoke(name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stored, ok := store.stored[name]
+	if !ok {
+		if store.configuredLocked(name) {
+			return errAPIKeyInConfig
+		}
+		return errAPIKeyNotFound
+	}
+
+	delete(store.stored, name)
+
+	err := store.save()
+	if err != nil {
+		store.stored[name] = stored
+		return err
+	}
+
+	return nil
+}
+
+// configuredLocked must be called with the lock held
+func (store *APIKeyStore) configuredLocked(name string) bool {
+	for _, key := range store.configured {
+		if key.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// save must be called with the lock held
+// the file is replaced at once so a reader never sees half of it
+func (store *APIKeyStore) save() error {
+	if store.path == "" {
+		return nil
+	}
+
+	keys := make([]storedAPIKey, 0, len(store.stored))
+	for _, key := range store.stored {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+
+	content, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.Marshal failed: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(store.path), ".api-keys-*")
+	if err != nil {
+		return fmt.Errorf("create api keys store failed: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write api keys store failed: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), store.path)
+	if err != nil {
+		return fmt.Errorf("write api keys store failed: %w", err)
+	}
+
+	return nil
+}
+
+// requireAPIKeyAdmin
+// Responds 403 unless the caller authenticated with a key of API_ADMIN_KEYS
+func requireAPIKeyAdmin(w http.ResponseWriter, r *http.Request) bool {
+	client, _ := r.Context().Value(APIClientKey{}).(string)
+	if client == "" || !apiKeyStore.IsAdmin(client) {
+		writeJSONError(w, r, http.StatusForbidden, "an api key of API_ADMIN_KEYS is required")
+		return false
+	}
+	return true
+}
+
+// apiKeysHandlerGet
+// The keys of the config and of the store, without the keys themselves
+func apiKeysHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+	log := logger.Get(r.Context())
+
+	if !requireAPIKeyAdmin(w, r) {
+		return nil
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(apiKeyStore.List())
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
+
+// apiKeysHandlerPost
+// Create a key for the client `{"name": "dashboard"}`, the key is only in this response
+func apiKeysHandlerPost(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+	log := logger.Get(r.Context())
+
+	if !requireAPIKeyAdmin(w, r) {
+		return nil
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid body, expected {\"name\": \"...\"}")
+		return nil
+	}
+	if !apiKeyNamePattern.MatchString(body.Name) {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid name, expected letters, digits, `.`, `_` or `-`")
+		return nil
+	}
+
+	apiKey, err := apiKeyStore.Create(body.Name, time.Now())
+	if errors.Is(err, errAPIKeyExists) {
+		writeJSONError(w, r, http.StatusConflict, fmt.Sprintf("api key `%s` already exists", body.Name))
+		return nil
+	}
+	if err != nil {
+		log.WithError(err).Error("Fail to create the api key")
+		writeJSONError(w, r, http.StatusInternalServerError, "fail to create the api key")
+		return nil
+	}
+
+	log.Infof("api key `%s` created", body.Name)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(map[string]string{"name": body.Name, "key": apiKey})
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
+
+// apiKeysHandlerDelete
+// Revoke a key of the store, the next calls with it get a 401
+func apiKeysHandlerDelete(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	log := logger.Get(r.Context())
+
+	if !requireAPIKeyAdmin(w, r) {
+		return nil
+	}
+
+	name := vars["name"]
+	err := apiKeyStore.Revoke(name)
+	switch {
+	case errors.Is(err, errAPIKeyNotFound):
+		writeJSONError(w, r, http.StatusNotFound, fmt.Sprintf("api key `%s` not found", name))
+		return nil
+	case errors.Is(err, errAPIKeyInConfig):
+		writeJSONError(w, r, http.StatusConflict, fmt.Sprintf("api key `%s` is set in API_KEYS, remove it from the config and reload", name))
+		return nil
+	case err != nil:
+		log.WithError(err).Error("Fail to revoke the api key")
+		writeJSONError(w, r, http.StatusInternalServerError, "fail to revoke the api key")
+		return nil
+	}
+
+	log.Infof("api key `%s` revoked", name)
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Scalingo/go-handlers"
+	"github.com/Scalingo/go-utils/logger"
+)
+
+func newAPIKeysRouter(t *testing.T, store *APIKeyStore) http.Handler {
+	t.Helper()
+
+	previous := apiKeyStore
+	apiKeyStore = store
+	t.Cleanup(func() { apiKeyStore = previous })
+
+	router := handlers.NewRouter(logger.Default())
+	router.Use(apiKeyMiddleware(store, newAPILimiter(0, 0)))
+	router.HandleFunc("/ping", pongHandler)
+	router.HandleFunc("/api-keys", apiKeysHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/api-keys", apiKeysHandlerPost).Methods(http.MethodPost)
+	router.HandleFunc("/api-keys/{name}", apiKeysHandlerDelete).Methods(http.MethodDelete)
+	router.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}).Methods(http.MethodGet)
+
+	return router
+}
+
+func callAPI(router http.Handler, method, path, apiKey, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func configuredAPIKey(name, key string) APIKey {
+	return APIKey{Name: name, Hash: sha256.Sum256([]byte(key))}
+}
+
+func TestAPIKeyStoreCreateAndRevoke(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+
+	store, err := newAPIKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetConfigured([]APIKey{configuredAPIKey("ops", "ops-key"), configuredAPIKey("reader", "reader-key")}, []string{"ops"})
+	router := newAPIKeysRouter(t, store)
+
+	if res := callAPI(router, http.MethodPost, "/api-keys", "reader-key", `{"name": "dashboard"}`); res.Code != http.StatusForbidden {
+		t.Fatalf("a key which is not admin created a key: %d", res.Code)
+	}
+
+	res := callAPI(router, http.MethodPost, "/api-keys", "ops-key", `{"name": "dashboard"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", res.Code, res.Body)
+	}
+	var created map[string]string
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	dashboardKey := created["key"]
+
+	if res := callAPI(router, http.MethodGet, "/stats", dashboardKey, ""); res.Code != http.StatusOK {
+		t.Errorf("the created key is refused: %d", res.Code)
+	}
+	if res := callAPI(router, http.MethodPost, "/api-keys", "ops-key", `{"name": "reader"}`); res.Code != http.StatusConflict {
+		t.Errorf("a key named like a configured one was created: %d", res.Code)
+	}
+
+	// only the hash is written, the key survives a restart
+	restarted, err := newAPIKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client := restarted.Authenticate(dashboardKey); client != "dashboard" {
+		t.Errorf("the key was not kept in the store, authenticated as %q", client)
+	}
+	hash := sha256.Sum256([]byte(dashboardKey))
+	if stored := restarted.stored["dashboard"]; stored.Hash != hex.EncodeToString(hash[:]) {
+		t.Errorf("unexpected hash %q", stored.Hash)
+	}
+
+	if res := callAPI(router, http.MethodDelete, "/api-keys/reader", "ops-key", ""); res.Code != http.StatusConflict {
+		t.Errorf("a key of API_KEYS was revoked through the api: %d", res.Code)
+	}
+	if res := callAPI(router, http.MethodDelete, "/api-keys/dashboard", "ops-key", ""); res.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d %s", res.Code, res.Body)
+	}
+	if res := callAPI(router, http.MethodGet, "/stats", dashboardKey, ""); res.Code != http.StatusUnauthorized {
+		t.Errorf("the revoked key is still accepted: %d", res.Code)
+	}
+	if res := callAPI(router, http.MethodDelete, "/api-keys/dashboard", "ops-key", ""); res.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a revoked key, got %d", res.Code)
+	}
+}
+
+func TestAPIKeyStoreReload(t *testing.T) {
+	store, err := newAPIKeyStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newAPIKeysRouter(t, store)
+
+	if res := callAPI(router, http.MethodGet, "/stats", "", ""); res.Code != http.StatusOK {
+		t.Errorf("the api is open without any key, got %d", res.Code)
+	}
+
+	store.SetConfigured([]APIKey{configuredAPIKey("ops", "ops-key")}, nil)
+	if res := callAPI(router, http.MethodGet, "/stats", "", ""); res.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 once a key is configured, got %d", res.Code)
+	}
+	if res := callAPI(router, http.MethodGet, "/ping", "", ""); res.Code != http.StatusOK {
+		t.Errorf("/ping needs no key, got %d", res.Code)
+	}
+
+	store.SetConfigured([]APIKey{configuredAPIKey("ops", "new-ops-key")}, nil)
+	if res := callAPI(router, http.MethodGet, "/stats", "ops-key", ""); res.Code != http.StatusUnauthorized {
+		t.Errorf("the key removed from the config is still accepted: %d", res.Code)
+	}
+	if res := callAPI(router, http.MethodGet, "/stats", "new-ops-key", ""); res.Code != http.StatusOK {
+		t.Errorf("the key added to the config is refused: %d", res.Code)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/go-handlers"
+	"github.com/Scalingo/go-utils/logger"
+)
+
+// routes anyone can call with no api key, the probes must work without one
+var apiKeyExemptPaths = map[string]bool{
+	"/ping":    true,
+	"/healthz": true,
+	"/readyz":  true,
+	// github authenticates with the signature of the payload
+	"/webhooks/github": true,
+}
+
+// APIKey
+// A client of our API, only the sha256 of its key is known
+type APIKey struct {
+	Name string
+	Hash [sha256.Size]byte
+}
+
+// parseAPIKeys
+// Keys are configured as `name:sha256hex`
+// the hash of a key is given by `echo -n <key> | sha256sum`
+func parseAPIKeys(entries []string) ([]APIKey, error) {
+	keys := make([]APIKey, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry == "" {
+			continue
+		}
+
+		name, hash, ok := strings.Cut(entry, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid api key entry, expected name:sha256")
+		}
+
+		bytes, err := hex.DecodeString(hash)
+		if err != nil || len(bytes) != sha256.Size {
+			return nil, fmt.Errorf("invalid hash for api key `%s`, expected a hex encoded sha256", name)
+		}
+
+		key := APIKey{Name: name}
+		copy(key.Hash[:], bytes)
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// APIClientKey
+// context key of the name of the api key used by the caller
+type APIClientKey struct{}
+
+// apiWindow
+// Fixed window counter, used both for the per minute rate limit and the daily quota
+type apiWindow struct {
+	used  int
+	reset time.Time
+}
+
+func (window *apiWindow) take(now time.Time, period time.Duration) {
+	if !now.Before(window.reset) {
+		window.used = 0
+		window.reset = now.Truncate(period).Add(period)
+	}
+	window.used++
+}
+
+// APILimiter
+// Per key rate limit and daily quota
+type APILimiter struct {
+	mu         sync.Mutex
+	ratePerMin int
+	dailyQuota int
+	minute     map[string]*apiWindow
+	day        map[string]*apiWindow
+}
+
+func newAPILimiter(ratePerMin, dailyQuota int) *APILimiter {
+	return &APILimiter{
+		ratePerMin: ratePerMin,
+		dailyQuota: dailyQuota,
+		minute:     map[string]*apiWindow{},
+		day:        map[string]*apiWindow{},
+	}
+}
+
+// SetLimits
+// Change the limits, the requests already counted in the current windows still count
+func (limiter *APILimiter) SetLimits(ratePerMin, dailyQuota int) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.ratePerMin = ratePerMin
+	limiter.dailyQuota = dailyQuota
+}
+
+// APIRateLimit
+// State of the limit reported to the caller in the X-RateLimit-* headers
+type APIRateLimit struct {
+	Resource  string
+	Limit     int
+	Used      int
+	Reset     time.Time
+	Exhausted bool
+}
+
+func (rateLimit APIRateLimit) Remaining() int {
+	if rateLimit.Used >= rateLimit.Limit {
+		return 0
+	}
+	return rateLimit.Limit - rateLimit.Used
+}
+
+// Take
+// Count one request for the key
+// Returns the tightest of the two limits, the one the caller should care about
+func (limiter *APILimiter) Take(key string, now time.Time) APIRateLimit {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	minute, ok := limiter.minute[key]
+	if !ok {
+		minute = &apiWindow{}
+		limiter.minute[key] = minute
+	}
+
+	day, ok := limiter.day[key]
+	if !ok {
+		day = &apiWindow{}
+		limiter.day[key] = day
+	}
+
+	minute.take(now, time.Minute)
+	day.take(now.UTC(), 24*time.Hour)
+
+	perMinute := APIRateLimit{Resource: "rate", Limit: limiter.ratePerMin, Used: minute.used, Reset: minute.reset}
+	perDay := APIRateLimit{Resource: "daily_quota", Limit: limiter.dailyQuota, Used: day.used, Reset: day.reset}
+
+	perMinute.Exhausted = perMinute.Limit > 0 && perMinute.Used > perMinute.Limit
+	perDay.Exhausted = perDay.Limit > 0 && perDay.Used > perDay.Limit
+
+	switch {
+	case perDay.Exhausted:
+		return perDay
+	case perMinute.Exhausted:
+		return perMinute
+	case perDay.Limit > 0 && (perMinute.Limit <= 0 || perDay.Remaining() < perMinute.Remaining()):
+		return perDay
+	default:
+		return perMinute
+	}
+}
+
+// authenticateAPIKey
+// The name of the client the key belongs to, empty if it's unknown
+func authenticateAPIKey(keys []APIKey, apiKey string) string {
+	hash := sha256.Sum256([]byte(apiKey))
+
+	var client string
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare(hash[:], key.Hash[:]) == 1 {
+			client = key.Name
+		}
+	}
+
+	return client
+}
+
+// apiKeyMiddleware
+// Authenticate the caller with the `X-API-Key` header then apply its rate limit and quota
+// Responds 401 without a valid key and 429 once a limit is reached
+// the api is open while the store has no key
+func apiKeyMiddleware(keys *APIKeyStore, limiter *APILimiter) handlers.MiddlewareFunc {
+	return func(next handlers.HandlerFunc) handlers.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+			if apiKeyExemptPaths[r.URL.Path] || !keys.Enabled() {
+				return next(w, r, vars)
+			}
+
+			log := logger.Get(r.Context())
+
+			client := keys.Authenticate(r.Header.Get("X-API-Key"))
+			if client == "" {
+				writeJSONError(w, r, http.StatusUnauthorized, "missing or invalid api key")
+				return nil
+			}
+
+			log = log.WithField("api_client", client)
+
+			rateLimit := limiter.Take(client, time.Now())
+			if rateLimit.Limit > 0 {
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(rateLimit.Limit))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(rateLimit.Remaining()))
+				w.Header().Set("X-RateLimit-Used", strconv.Itoa(rateLimit.Used))
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(rateLimit.Reset.Unix(), 10))
+				w.Header().Set("X-RateLimit-Resource", rateLimit.Resource)
+			}
+
+			if rateLimit.Exhausted {
+				log.Infof("api client over its %s", rateLimit.Resource)
+
+				w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(rateLimit.Reset).Seconds())+1))
+				writeJSONError(w, r, http.StatusTooManyRequests, fmt.Sprintf("%s exceeded for api key `%s`", rateLimit.Resource, client))
+				return nil
+			}
+
+			ctx := logger.ToCtx(r.Context(), log)
+			ctx = context.WithValue(ctx, APIClientKey{}, client)
+
+			return next(w, r.WithContext(ctx), vars)
+		}
+	}
+}
+
+// writeJSONError
+// Respond with the given status and a JSON error body
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	log := logger.Get(r.Context())
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(map[string]string{"error": message})
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+}
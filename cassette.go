@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+)
+
+const (
+	cassetteModePassthrough = "passthrough"
+	cassetteModeRecord      = "record"
+	cassetteModeReplay      = "replay"
+)
+
+// what a recorded request can be matched on in replay
+const (
+	cassetteMatchMethod = "method"
+	cassetteMatchURL    = "url"
+	cassetteMatchQuery  = "query"
+	cassetteMatchBody   = "body"
+)
+
+var cassetteMatchers = map[string]bool{
+	cassetteMatchMethod: true,
+	cassetteMatchURL:    true,
+	cassetteMatchQuery:  true,
+	cassetteMatchBody:   true,
+}
+
+// headers never written in a cassette, with the request and response headers holding a token
+var cassetteRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// how often the interactions recorded are written in the cassette
+const cassetteFlushInterval = 5 * time.Second
+
+const cassetteRedacted = "REDACTED"
+
+// Cassette
+// The github calls recorded in a file, in the order they were made
+type Cassette struct {
+	Interactions []CassetteInteraction `json:"interactions"`
+}
+
+type CassetteInteraction struct {
+	Request    CassetteRequest  `json:"request"`
+	Response   CassetteResponse `json:"response"`
+	RecordedAt time.Time        `json:"recorded_at"`
+}
+
+type CassetteRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body,omitempty"`
+}
+
+type CassetteResponse struct {
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers"`
+	Body       string      `json:"body"`
+}
+
+// CassetteTransport
+// Record the calls made through HttpRequest.Do in a cassette, or serve them from it
+// passthrough calls github without recording anything
+type CassetteTransport struct {
+	mode  string
+	path  string
+	match []string
+	next  http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+	// replayed interactions, an interaction is replayed once before the ones already used are reused
+	used []bool
+	// interactions recorded since the last flush
+	dirty bool
+	// one flush writes the file at a time
+	flushing sync.Mutex
+}
+
+// calls of the github client recorded or replayed, set in initApp
+var githubCassette *CassetteTransport
+
+// newCassetteTransport
+// In replay the cassette must exist, in record it's created or appended to
+func newCassetteTransport(mode, path string, match []string, next http.RoundTripper) (*CassetteTransport, error) {
+	transport := &CassetteTransport{mode: mode, path: path, match: match, next: next}
+
+	if mode == cassetteModePassthrough {
+		return transport, nil
+	}
+
+	content, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && mode == cassetteModeRecord:
+	case err != nil:
+		return nil, fmt.Errorf("read cassette failed: %w", err)
+	default:
+		err = json.Unmarshal(content, &transport.cassette)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cassette `%s`: %w", path, err)
+		}
+	}
+
+	transport.used = make([]bool, len(transport.cassette.Interactions))
+
+	return transport, nil
+}
+
+func (transport *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch transport.mode {
+	case cassetteModeRecord:
+		return transport.record(req)
+	case cassetteModeReplay:
+		return transport.replay(req)
+	default:
+		return transport.next.RoundTrip(req)
+	}
+}
+
+// record
+// Call github then keep the call in the cassette, the file is written by Flush
+func (transport *CassetteTransport) record(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := transport.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	responseBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read response body failed: %w", err)
+	}
+	res.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	interaction := CassetteInteraction{
+		Request: CassetteRequest{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: redactHeaders(req.Header),
+			Body:    string(requestBody),
+		},
+		Response: CassetteResponse{
+			StatusCode: res.StatusCode,
+			Headers:    redactHeaders(res.Header),
+			Body:       string(responseBody),
+		},
+		RecordedAt: time.Now().UTC(),
+	}
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	transport.cassette.Interactions = append(transport.cassette.Interactions, interaction)
+	transport.used = append(transport.used, true)
+	transport.dirty = true
+
+	return res, nil
+}
+
+// Flush
+// Write the interactions recorded in the cassette, nothing is done if none was recorded since the last flush
+// the cassette is replaced at once so a reader never sees half of it
+func (transport *CassetteTransport) Flush() error {
+	if transport == nil || transport.mode != cassetteModeRecord {
+		return nil
+	}
+
+	transport.flushing.Lock()
+	defer transport.flushing.Unlock()
+
+	transport.mu.Lock()
+	if !transport.dirty {
+		transport.mu.Unlock()
+		return nil
+	}
+	content, err := json.MarshalIndent(transport.cassette, "", "  ")
+	transport.dirty = false
+	transport.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("json.Marshal failed: %w", err)
+	}
+
+	err = transport.write(content)
+	if err != nil {
+		// written again with the next flush
+		transport.mu.Lock()
+		transport.dirty = true
+		transport.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// startCassetteFlusher
+// Flush the cassette every interval until the context is done, the last flush is made when the command exits
+func startCassetteFlusher(ctx context.Context, transport *CassetteTransport, interval time.Duration) {
+	log := logger.Get(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := transport.Flush()
+			if err != nil {
+				log.WithError(err).Error("Fail to write the github cassette")
+			}
+		}
+	}
+}
+
+// write
+// Replace the file of the cassette with a temporary file, must be called by Flush only
+func (transport *CassetteTransport) write(content []byte) error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(transport.path), ".cassette-*")
+	if err != nil {
+		return fmt.Errorf("create cassette failed: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write cassette failed: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), transport.path)
+	if err != nil {
+		return fmt.Errorf("write cassette failed: %w", err)
+	}
+
+	return nil
+}
+
+// replay
+// Respond with the first interaction matching the request not replayed yet,
+// or with the last one matching if they were all replayed already
+func (transport *CassetteTransport) replay(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	found := -1
+	for i, interaction := range transport.cassette.Interactions {
+		if !transport.matches(interaction.Request, req, requestBody) {
+			continue
+		}
+
+		found = i
+		if !transport.used[i] {
+			break
+		}
+	}
+
+	if found == -1 {
+		return nil, fmt.Errorf("no interaction of cassette `%s` matches %s %s", transport.path, req.Method, req.URL)
+	}
+	transport.used[found] = true
+
+	recorded := transport.cassette.Interactions[found].Response
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Headers.Clone(),
+		Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+// matches
+// Compare the request to a recorded one on the configured parts
+// the url is the scheme, host and path, the query parameters are compared in any order
+func (transport *CassetteTransport) matches(recorded CassetteRequest, req *http.Request, body []byte) bool {
+	recordedURL, err := url.Parse(recorded.URL)
+	if err != nil {
+		return false
+	}
+
+	for _, match := range transport.match {
+		switch match {
+		case cassetteMatchMethod:
+			if recorded.Method != req.Method {
+				return false
+			}
+		case cassetteMatchURL:
+			if recordedURL.Scheme != req.URL.Scheme || recordedURL.Host != req.URL.Host || recordedURL.Path != req.URL.Path {
+				return false
+			}
+		case cassetteMatchQuery:
+			if !reflect.DeepEqual(recordedURL.Query(), req.URL.Query()) {
+				return false
+			}
+		case cassetteMatchBody:
+			if recorded.Body != string(body) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// readRequestBody
+// Read the body of the request and put it back for the actual call
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read request body failed: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// redactHeaders
+// The headers of cassetteRedactedHeaders and the ones named like a token (X-GitHub-Token...) are redacted
+func redactHeaders(headers http.Header) http.Header {
+	redacted := headers.Clone()
+	for name := range redacted {
+		if strings.Contains(strings.ToLower(name), "token") {
+			redacted.Set(name, cassetteRedacted)
+		}
+	}
+	for _, header := range cassetteRedactedHeaders {
+		if redacted.Get(header) != "" {
+			redacted.Set(header, cassetteRedacted)
+		}
+	}
+	return redacted
+}
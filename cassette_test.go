@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+func cassetteCall(t *testing.T, client *http.Client, method, url, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "token ghp_secret")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-GitHub-Token", "ghs_secret")
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	content, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(content)
+}
+
+func TestCassetteRecordReplay(t *testing.T) {
+	server := githubfake.NewServer(githubfake.Options{Seed: 10, Count: 5})
+	repositories := server.Repositories()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder, err := newCassetteTransport(cassetteModeRecord, path, []string{cassetteMatchMethod, cassetteMatchURL, cassetteMatchQuery, cassetteMatchBody}, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: recorder}
+
+	calls := []struct{ method, url, body string }{
+		{http.MethodGet, server.URL + "/repositories?since=0", ""},
+		{http.MethodGet, server.URL + "/repos/" + repositories[1].FullName() + "/languages", ""},
+		{http.MethodGet, server.URL + "/repos/nobody/nothing", ""},
+		{http.MethodPost, server.URL + "/graphql", `{"query": "{ r0: repository(owner: \"` + repositories[2].Owner + `\", name: \"` + repositories[2].Name + `\") { stargazerCount } }"}`},
+	}
+	type recorded struct {
+		status int
+		body   string
+	}
+	var responses []recorded
+	for _, call := range calls {
+		status, body := cassetteCall(t, client, call.method, call.url, call.body)
+		responses = append(responses, recorded{status, body})
+	}
+
+	// the calls are kept in memory until the flush
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("the cassette was written before the flush: %v", err)
+	}
+	if err := recorder.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "secret") {
+		t.Errorf("a credential was written in the cassette:\n%s", content)
+	}
+
+	// github is gone, the cassette answers the same calls the same way
+	player, err := newCassetteTransport(cassetteModeReplay, path, []string{cassetteMatchMethod, cassetteMatchURL, cassetteMatchQuery, cassetteMatchBody}, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: player}
+
+	for i, call := range calls {
+		status, body := cassetteCall(t, client, call.method, call.url, call.body)
+		if status != responses[i].status || body != responses[i].body {
+			t.Errorf("%s %s: replayed %d %q, recorded %d %q", call.method, call.url, status, body, responses[i].status, responses[i].body)
+		}
+	}
+
+	if _, err := client.Get(server.URL + "/repos/nobody/else"); err == nil {
+		t.Error("a call not recorded was answered")
+	}
+}
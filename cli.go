@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+)
+
+// exit codes of the commands
+const (
+	exitOK = 0
+	// github or the server could not be reached, or returned an error
+	exitFailure = 1
+	// invalid command, flag or argument
+	exitUsage = 2
+	// invalid configuration
+	exitConfig = 3
+)
+
+const outputTable = "table"
+const outputJSON = "json"
+
+const usage = `Usage: sclng-backend-test-v1 <command> [flags]
+
+Commands:
+  serve         run the http and grpc servers (default)
+  repos         list the last repositories created
+  stats         stats of the last repositories created
+  export        export the stats as csv, tsv or parquet
+  cache purge   purge the repositories stored by a running server
+  config check  check the configuration taken from the file, the environment and -set
+
+The commands using the configuration take -config file.yaml|file.toml (CONFIG_FILE by default)
+and -set KEY=VALUE, repeated.
+Run "sclng-backend-test-v1 <command> -h" for the flags of a command.
+`
+
+// runCommand
+// Run the command given in the arguments, serve by default
+// Returns the exit code
+func runCommand(args []string) int {
+	log := logger.Default()
+	ctx := logger.ToCtx(context.Background(), log)
+
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	// commands made of two words
+	if (command == "cache" || command == "config") && len(args) > 0 {
+		command, args = command+" "+args[0], args[1:]
+	}
+
+	// the calls recorded are written once the command is done
+	defer func() {
+		err := githubCassette.Flush()
+		if err != nil {
+			log.WithError(err).Error("Fail to write the github cassette")
+		}
+	}()
+
+	switch command {
+	case "serve":
+		return serveCommand(ctx, args)
+	case "repos":
+		return reposCommand(ctx, args)
+	case "stats":
+		return statsCommand(ctx, args)
+	case "export":
+		return exportCommand(ctx, args)
+	case "cache purge":
+		return cachePurgeCommand(ctx, args)
+	case "config check":
+		return configCheckCommand(ctx, args)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command `%s`\n\n%s", command, usage)
+		return exitUsage
+	}
+}
+
+// loadConfig
+// The configuration from its sources, exitConfig if it's invalid
+func loadConfig(ctx context.Context, options ConfigOptions) (*Config, int) {
+	log := logger.Get(ctx)
+
+	cfg, err := newConfig(options)
+	if err != nil {
+		log.WithError(err).Error("Fail to initialize configuration")
+		return nil, exitConfig
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		log.WithError(err).Error("Invalid configuration")
+		return nil, exitConfig
+	}
+
+	return cfg, exitOK
+}
+
+// loadApp
+// Configuration and initialization of what the commands fetching from github need
+func loadApp(ctx context.Context, options ConfigOptions) int {
+	cfg, code := loadConfig(ctx, options)
+	if code != exitOK {
+		return code
+	}
+
+	err := initApp(ctx, cfg)
+	if err != nil {
+		logger.Get(ctx).WithError(err).Error("Fail to initialize app")
+		return exitConfig
+	}
+
+	return exitOK
+}
+
+// parseFlags
+// Parse the flags of a command, flag.ErrHelp is not an error
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitUsage, false
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(flags.Output(), "unexpected argument `%s`\n", flags.Arg(0))
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// settingsFlag
+// -set KEY=VALUE, repeated, overrides a setting of the file and the environment
+type settingsFlag map[string]string
+
+func (settings settingsFlag) String() string {
+	values := make([]string, 0, len(settings))
+	for key, value := range settings {
+		values = append(values, key+"="+value)
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
+func (settings settingsFlag) Set(value string) error {
+	key, setting, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("invalid setting `%s`, expected KEY=VALUE", value)
+	}
+	settings[strings.ToUpper(key)] = setting
+	return nil
+}
+
+// configFlags
+// -config and -set, shared by the commands using the configuration
+// Returns the options to load the configuration with once the flags are parsed
+func configFlags(flags *flag.FlagSet) func() ConfigOptions {
+	file := flags.String("config", os.Getenv("CONFIG_FILE"), "yaml or toml configuration file, the environment overrides it")
+	settings := settingsFlag{}
+	flags.Var(settings, "set", "KEY=VALUE overriding the file and the environment, repeated")
+
+	return func() ConfigOptions {
+		return ConfigOptions{File: *file, Overrides: settings}
+	}
+}
+
+// serveCommand
+// `serve [-config file] [-set KEY=VALUE]`
+// the configuration is loaded again on SIGHUP, see reloadConfig
+func serveCommand(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configOptions := configFlags(flags)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	options := configOptions()
+	cfg, code := loadConfig(ctx, options)
+	if code != exitOK {
+		return code
+	}
+	return serve(ctx, cfg, options)
+}
+
+// topicsFlag
+// -topic, repeated or comma separated like the `topic` query parameter
+type topicsFlag []string
+
+func (topics *topicsFlag) String() string {
+	return strings.Join(*topics, ",")
+}
+
+func (topics *topicsFlag) Set(value string) error {
+	*topics = append(*topics, value)
+	return nil
+}
+
+// statsFilterFlags
+// The filters of /stats as flags
+// Returns a function building the query parameters once the flags are parsed
+func statsFilterFlags(flags *flag.FlagSet) func() url.Values {
+	since := flags.String("since", "", "id of the repository to list from, instead of the last ones created")
+	language := flags.String("language", "", "keep the repositories using this language")
+	license := flags.String("license", "", "keep the repositories with this license key")
+	topicMode := topicModeAny
+	flags.Func("topic-mode", "any (default): one of the topics, all: all of them", func(value string) error {
+		if err := validateTopicMode(url.Values{"topic_mode": {value}}); err != nil {
+			return err
+		}
+		topicMode = value
+		return nil
+	})
+	var topics topicsFlag
+	flags.Var(&topics, "topic", "keep the repositories with this topic, repeated or comma separated")
+
+	return func() url.Values {
+		params := url.Values{}
+		for name, value := range map[string]string{"since": *since, "language": *language, "license": *license} {
+			if value != "" {
+				params.Set(name, value)
+			}
+		}
+		for _, topic := range topics {
+			params.Add("topic", topic)
+		}
+		if len(topics) > 0 {
+			params.Set("topic_mode", topicMode)
+		}
+		return params
+	}
+}
+
+func outputFlag(flags *flag.FlagSet) *string {
+	return flags.String("output", outputTable, "table or json")
+}
+
+func validOutput(output string) bool {
+	if output != outputTable && output != outputJSON {
+		fmt.Fprintf(os.Stderr, "invalid output `%s`, expected table or json\n", output)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w io.Writer, value any) int {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	err := encoder.Encode(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to encode JSON: %v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// truncate
+// Cut a table cell to max runes
+func truncate(value string, max int) string {
+	value = strings.Join(strings.Fields(value), " ")
+	runes := []rune(value)
+	if len(runes) <= max {
+		return value
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// reposCommand
+// `repos [-since id] [-output table|json]`
+func reposCommand(ctx context.Context, args []string) int {
+	log := logger.Get(ctx)
+
+	flags := flag.NewFlagSet("repos", flag.ContinueOnError)
+	configOptions := configFlags(flags)
+	since := flags.String("since", "", "id of the repository to list from, instead of the last ones created")
+	output := outputFlag(flags)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if !validOutput(*output) {
+		return exitUsage
+	}
+
+	if code := loadApp(ctx, configOptions()); code != exitOK {
+		return code
+	}
+
+	params := url.Values{}
+	if *since != "" {
+		params.Set("since", *since)
+	}
+
+	repos, err := fetchRepositories(ctx, params)
+	if err != nil {
+		log.WithError(err).Error("Fail to fetch the repositories")
+		return exitFailure
+	}
+
+	if *output == outputJSON {
+		return writeJSON(os.Stdout, repos)
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "REPOSITORY\tDESCRIPTION")
+	for _, repo := range repos {
+		fmt.Fprintf(table, "%s/%s\t%s\n", repo.Owner, repo.Name, truncate(repo.Description, 60))
+	}
+	table.Flush()
+
+	return exitOK
+}
+
+// statsCommand
+// `stats [-language Go] [-license mit] [-topic cli] [-topic-mode any|all] [-since id] [-output table|json]`
+func statsCommand(ctx context.Context, args []string) int {
+	log := logger.Get(ctx)
+
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	configOptions := configFlags(flags)
+	filter := statsFilterFlags(flags)
+	output := outputFlag(flags)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if !validOutput(*output) {
+		return exitUsage
+	}
+
+	if code := loadApp(ctx, configOptions()); code != exitOK {
+		return code
+	}
+
+	stats, err := fetchStats(ctx, filter())
+	if err != nil {
+		log.WithError(err).Error("Fail to fetch the stats")
+		return exitFailure
+	}
+
+	if *output == outputJSON {
+		return writeJSON(os.Stdout, stats)
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "REPOSITORY\tSTARS\tLICENSE\tLANGUAGES\tTOPICS")
+	for _, stat := range stats {
+		// the 3 languages with the most code
+		languages := make([]string, 0, len(stat.Languages))
+		for language := range stat.Languages {
+			languages = append(languages, language)
+		}
+		sort.Slice(languages, func(i, j int) bool {
+			return stat.Languages[languages[i]] > stat.Languages[languages[j]]
+		})
+		if len(languages) > 3 {
+			languages = languages[:3]
+		}
+
+		fmt.Fprintf(table, "%s/%s\t%d\t%s\t%s\t%s\n",
+			stat.Owner, stat.Name, stat.StarCount, stat.License,
+			strings.Join(languages, ","), truncate(strings.Join(stat.Topics, ","), 40))
+	}
+	table.Flush()
+
+	return exitOK
+}
+
+// exportCommand
+// `export [-format csv|tsv|parquet] [-layout wide|long] [-excel] [-o file] [filters...]`
+// writes the stats /stats returns with the same filters, to stdout by default
+func exportCommand(ctx context.Context, args []string) int {
+	log := logger.Get(ctx)
+
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	configOptions := configFlags(flags)
+	filter := statsFilterFlags(flags)
+	format := flags.String("format", exportFormatCSV, "csv, tsv or parquet")
+	layout := flags.String("layout", exportLayoutWide, "wide or long, for csv and tsv")
+	excel := flags.Bool("excel", false, "csv for spreadsheets")
+	output := flags.String("o", "", "file to write, stdout if not set")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	options, err := newExportOptions(url.Values{
+		"format": {*format},
+		"layout": {*layout},
+		"excel":  {strconv.FormatBool(*excel)},
+	}, "")
+	if err == nil && options.Format == exportFormatJSON {
+		err = fmt.Errorf("use the stats command for a JSON output")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	if code := loadApp(ctx, configOptions()); code != exitOK {
+		return code
+	}
+
+	stats, err := fetchStats(ctx, filter())
+	if err != nil {
+		log.WithError(err).Error("Fail to fetch the stats")
+		return exitFailure
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.WithError(err).Error("Fail to create the output file")
+			return exitFailure
+		}
+		defer file.Close()
+		w = file
+	}
+
+	err = exportStats(w, stats, options)
+	if err != nil {
+		log.WithError(err).Errorf("Fail to export %s", options.Format)
+		return exitFailure
+	}
+
+	log.Infof("Exported the stats of %d repositories", len(stats))
+
+	return exitOK
+}
+
+// cachePurgeCommand
+// `cache purge [-url http://localhost:5000] [-api-key key]`
+// the store lives in the memory of the server, it's purged through its api
+func cachePurgeCommand(ctx context.Context, args []string) int {
+	log := logger.Get(ctx)
+
+	flags := flag.NewFlagSet("cache purge", flag.ContinueOnError)
+	serverURL := flags.String("url", "http://localhost:5000", "url of the running server")
+	apiKey := flags.String("api-key", "", "api key of a client of API_ADMIN_KEYS")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, strings.TrimSuffix(*serverURL, "/")+"/cache", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid url `%s`: %v\n", *serverURL, err)
+		return exitUsage
+	}
+	if *apiKey != "" {
+		req.Header.Set("X-API-Key", *apiKey)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.WithError(err).Error("Fail to reach the server")
+		return exitFailure
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		Purged int    `json:"purged"`
+		Error  string `json:"error"`
+	}
+	_ = json.NewDecoder(res.Body).Decode(&body)
+
+	if res.StatusCode != http.StatusOK {
+		log.Errorf("Fail to purge the cache: %d %s", res.StatusCode, body.Error)
+		return exitFailure
+	}
+
+	fmt.Fprintf(os.Stdout, "purged %d repositories\n", body.Purged)
+
+	return exitOK
+}
+
+// configCheckCommand
+// `config check [-config file] [-set KEY=VALUE] [-output table|json]`
+// Print the configuration taken from its sources, secrets redacted, and what's wrong with it
+func configCheckCommand(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("config check", flag.ContinueOnError)
+	configOptions := configFlags(flags)
+	output := outputFlag(flags)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if !validOutput(*output) {
+		return exitUsage
+	}
+
+	cfg, err := newConfig(configOptions())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+
+	validationErr := cfg.Validate()
+
+	if *output == outputJSON {
+		result := map[string]any{"valid": validationErr == nil, "config": redactConfig(cfg)}
+		if validationErr != nil {
+			result["errors"] = strings.Split(validationErr.Error(), "\n")
+		}
+		if code := writeJSON(os.Stdout, result); code != exitOK {
+			return code
+		}
+	} else {
+		values := redactConfig(cfg)
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "SETTING\tVALUE")
+		for _, name := range names {
+			fmt.Fprintf(table, "%s\t%v\n", name, values[name])
+		}
+		table.Flush()
+
+		if validationErr != nil {
+			fmt.Fprintf(os.Stderr, "\ninvalid configuration:\n%s\n", validationErr)
+		}
+	}
+
+	if validationErr != nil {
+		return exitConfig
+	}
+
+	return exitOK
+}
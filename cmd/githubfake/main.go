@@ -0,0 +1,55 @@
+// githubfake
+// Serve the fake github of the githubfake package, to run the service without github
+//
+//	go run ./cmd/githubfake -port 8080 -count 50000
+//	GITHUB_API_URL=http://localhost:8080 ./sclng-backend-test-v1
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+func main() {
+	options := githubfake.DefaultOptions()
+
+	flags := flag.NewFlagSet("githubfake", flag.ExitOnError)
+	port := flags.Int("port", 8080, "port to listen on")
+	flags.Int64Var(&options.Seed, "seed", options.Seed, "same seed, same repositories")
+	flags.IntVar(&options.Count, "count", options.Count, "number of repositories generated")
+	flags.IntVar(&options.FirstID, "first-id", options.FirstID, "id of the first repository")
+	flags.IntVar(&options.MaxGap, "max-gap", options.MaxGap, "largest gap between two repository ids")
+	flags.DurationVar(&options.CreationInterval, "creation-interval", options.CreationInterval, "average time between two repositories")
+	flags.IntVar(&options.RateLimit, "rate-limit", options.RateLimit, "calls allowed per window, 0 for no limit")
+	flags.DurationVar(&options.RateLimitWindow, "rate-limit-window", options.RateLimitWindow, "window of the rate limit")
+	flags.DurationVar(&options.Latency, "latency", options.Latency, "added to every response")
+	flags.Float64Var(&options.ErrorRate, "error-rate", options.ErrorRate, "ratio of the calls answered with a 502")
+	createEvery := flags.Duration("create-every", 0, "create a repository at this interval, 0 to keep the repositories as generated")
+	_ = flags.Parse(os.Args[1:])
+
+	// the repositories end now, the latest ones are the ones just created
+	options.Start = time.Now().UTC().Add(-time.Duration(options.Count) * options.CreationInterval).Truncate(time.Second)
+
+	fake := githubfake.New(options)
+
+	if *createEvery > 0 {
+		go func() {
+			for range time.Tick(*createEvery) {
+				fake.Create(1)
+			}
+		}()
+	}
+
+	fmt.Fprintf(os.Stderr, "fake github with %d repositories listening on :%d\n", options.Count, *port)
+
+	err := http.ListenAndServe(fmt.Sprintf(":%d", *port), fake.Handler())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to listen: %v\n", err)
+		os.Exit(1)
+	}
+}
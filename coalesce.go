@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryCall
+// A /repos or /stats computation in flight and the callers waiting for it
+type queryCall struct {
+	done    chan struct{}
+	value   any
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// in flight /repos and /stats computations by query
+var (
+	queryCallsMu sync.Mutex
+	queryCalls   = map[string]*queryCall{}
+)
+
+// coalesceQuery
+// Run fn once for all the identical queries made at the same time
+// every caller gets the result of the same computation
+// Queries are identical if they are made to the same endpoint, with the same parameters,
+// in any order, and authenticated as the same github user
+// a caller whose context is done stops waiting, the computation is cancelled once no caller is left
+func coalesceQuery[T any](ctx context.Context, endpoint string, params url.Values, fn func(ctx context.Context) (T, error)) (T, error) {
+	key := fmt.Sprintf("%s?%s %s", endpoint, normalizeQuery(params), githubCredentials.Identity(ctx))
+
+	queryCallsMu.Lock()
+	call, shared := queryCalls[key]
+	if !shared {
+		// the computation must keep going for the other callers if the first one goes away
+		callCtx, cancel := context.WithCancel(detachedContext{ctx})
+		call = &queryCall{done: make(chan struct{}), cancel: cancel}
+		queryCalls[key] = call
+
+		go runQueryCall(callCtx, key, call, func(ctx context.Context) (any, error) {
+			return fn(ctx)
+		})
+	}
+	call.waiters++
+	queryCallsMu.Unlock()
+
+	if shared {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("query.coalesced", true))
+	}
+
+	var zero T
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return zero, call.err
+		}
+		return call.value.(T), nil
+	case <-ctx.Done():
+		queryCallsMu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// nobody wants the result anymore, the next identical query starts over
+			call.cancel()
+			if queryCalls[key] == call {
+				delete(queryCalls, key)
+			}
+		}
+		queryCallsMu.Unlock()
+
+		return zero, ctx.Err()
+	}
+}
+
+// runQueryCall
+// Run the computation and hand its result to the callers waiting for it
+func runQueryCall(ctx context.Context, key string, call *queryCall, fn func(ctx context.Context) (any, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.err = fmt.Errorf("query %s panicked: %v", key, r)
+		}
+
+		queryCallsMu.Lock()
+		if queryCalls[key] == call {
+			delete(queryCalls, key)
+		}
+		queryCallsMu.Unlock()
+
+		call.cancel()
+		close(call.done)
+	}()
+
+	call.value, call.err = fn(ctx)
+}
+
+// normalizeQuery
+// Encode the query parameters with the keys and values sorted and the empty values dropped
+func normalizeQuery(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), params[key]...)
+		sort.Strings(values)
+
+		for _, value := range values {
+			if value == "" {
+				continue
+			}
+			parts = append(parts, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
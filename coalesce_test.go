@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesceQueryShares(t *testing.T) {
+	var runs atomic.Int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		runs.Add(1)
+		<-release
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]int, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// the same parameters in another order
+			params := url.Values{"b": {"2"}, "a": {"1"}}
+			if i%2 == 0 {
+				params = url.Values{"a": {"1"}, "b": {"2"}}
+			}
+			results[i], _ = coalesceQuery(context.Background(), "/test/shares", params, fn)
+		}(i)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if runs.Load() != 1 {
+		t.Errorf("expected a single computation, got %d", runs.Load())
+	}
+	for i, result := range results {
+		if result != 42 {
+			t.Errorf("caller %d got %d", i, result)
+		}
+	}
+}
+
+func TestCoalesceQueryCallerLeaves(t *testing.T) {
+	cancelled := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		select {
+		case <-ctx.Done():
+			close(cancelled)
+			return 0, ctx.Err()
+		case <-release:
+			return 42, nil
+		}
+	}
+
+	leaving, leave := context.WithCancel(context.Background())
+	leftErr := make(chan error, 1)
+	go func() {
+		_, err := coalesceQuery(leaving, "/test/leaves", nil, fn)
+		leftErr <- err
+	}()
+
+	stayed := make(chan int, 1)
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		result, _ := coalesceQuery(context.Background(), "/test/leaves", nil, fn)
+		stayed <- result
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// the first caller goes away, the computation goes on for the other one
+	leave()
+	select {
+	case err := <-leftErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the caller to be cancelled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the cancelled caller kept waiting")
+	}
+
+	select {
+	case <-cancelled:
+		t.Fatal("the computation was cancelled while a caller was waiting for it")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if result := <-stayed; result != 42 {
+		t.Errorf("the remaining caller got %d", result)
+	}
+}
+
+func TestCoalesceQueryLastCallerLeaves(t *testing.T) {
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return 0, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := coalesceQuery(ctx, "/test/last", nil, fn)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline of the caller, got %v", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the computation kept running with no caller left")
+	}
+
+	// the next identical query starts a new computation
+	result, err := coalesceQuery(context.Background(), "/test/last", nil, func(ctx context.Context) (int, error) {
+		return 7, nil
+	})
+	if err != nil || result != 7 {
+		t.Errorf("expected a new computation, got %d, %v", result, err)
+	}
+}
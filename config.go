@@ -1,19 +1,426 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
 	"github.com/kelseyhightower/envconfig"
-	"github.com/pkg/errors"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 )
 
+// Config
+// The settings tagged `reload:"true"` are applied again on SIGHUP, the others need a restart
 type Config struct {
 	Port int `envconfig:"PORT" default:"5000"`
+	// port of the gRPC server, 0 to disable it
+	GRPCPort int `envconfig:"GRPC_PORT" default:"5001"`
+
+	// clients allowed to call the api, comma separated `name:sha256hex` of their key
+	// more can be created through /api-keys, the api is open to anyone while there is none
+	APIKeys []string `envconfig:"API_KEYS" secret:"true" reload:"true"`
+	// names of the keys allowed to create and revoke keys through /api-keys
+	APIAdminKeys []string `envconfig:"API_ADMIN_KEYS" reload:"true"`
+	// file the keys created through /api-keys are kept in, in memory only if empty
+	APIKeysStorePath string `envconfig:"API_KEYS_STORE_PATH"`
+	// requests per minute and per day allowed for each key, 0 for no limit
+	APIRateLimit  int `envconfig:"API_RATE_LIMIT" default:"60" reload:"true"`
+	APIDailyQuota int `envconfig:"API_DAILY_QUOTA" default:"1000" reload:"true"`
+
+	// panic, fatal, error, warn, info or debug, LOGGER_LEVEL is used if empty
+	LogLevel string `envconfig:"LOG_LEVEL" reload:"true"`
+
+	WorkerCount int `envconfig:"WORKER_COUNT" default:"16" reload:"true"`
+	// stats tasks waiting for a worker
+	StatsQueueSize int `envconfig:"STATS_QUEUE_SIZE" default:"1000"`
+
+	// pages of /repositories fetched at once to find the last repositories, 1 for a binary search
+	SearchParallelism int `envconfig:"SEARCH_PARALLELISM" default:"8" reload:"true"`
+	// share of the github rate limit left a search may use, the parallelism is lowered to fit in it
+	SearchRateLimitShare float64 `envconfig:"SEARCH_RATE_LIMIT_SHARE" default:"0.05" reload:"true"`
+
+	// repositories refreshed periodically to build their history, comma separated owner/name
+	TrackedRepositories []string `envconfig:"TRACKED_REPOSITORIES"`
+	// most repositories tracked at once, the api refuses to track more
+	SnapshotMaxTracked int           `envconfig:"SNAPSHOT_MAX_TRACKED" default:"100" reload:"true"`
+	SnapshotInterval   time.Duration `envconfig:"SNAPSHOT_INTERVAL" default:"1h"`
+	// how long the snapshots of a repository are kept
+	SnapshotRetention time.Duration `envconfig:"SNAPSHOT_RETENTION" default:"720h"`
+	// how many repositories are kept in memory, the least recently updated are evicted past it, 0 for no limit
+	StoreMaxRepositories int `envconfig:"STORE_MAX_REPOSITORIES" default:"100000"`
+
+	// secret of the github webhook, POST /webhooks/github is only served if set
+	GithubWebhookSecret string `envconfig:"GITHUB_WEBHOOK_SECRET" secret:"true"`
+
+	// poll the github public events, served by /events
+	EventsPoller bool `envconfig:"EVENTS_POLLER" default:"false"`
+	// github may ask to wait longer with the X-Poll-Interval header
+	EventsPollInterval time.Duration `envconfig:"EVENTS_POLL_INTERVAL" default:"60s"`
+	// how many events are kept in memory
+	EventsBufferSize int `envconfig:"EVENTS_BUFFER_SIZE" default:"10000"`
+
+	// github REST api, http://localhost:8080 to use the fake github of cmd/githubfake
+	GithubAPIURL string `envconfig:"GITHUB_API_URL" default:"https://api.github.com"`
+	// base url of a GitHub Enterprise Server, https://github.example.com
+	// sets GITHUB_API_URL, GITHUB_UPLOAD_URL and GITHUB_GRAPHQL_URL if they are left to their github.com default
+	GithubEnterpriseURL string `envconfig:"GITHUB_ENTERPRISE_URL"`
+	// github uploads api
+	GithubUploadURL string `envconfig:"GITHUB_UPLOAD_URL" default:"https://uploads.github.com"`
+	// X-GitHub-Api-Version sent with every call, empty for none, for a GitHub Enterprise Server before 3.9
+	GithubAPIVersion string `envconfig:"GITHUB_API_VERSION" default:"2022-11-28"`
+	// PEM file of the CAs trusted to call github besides the system ones
+	GithubCABundle string `envconfig:"GITHUB_CA_BUNDLE"`
+	// proxy of the github calls, HTTPS_PROXY and NO_PROXY are used if empty, it can hold credentials
+	GithubProxyURL string `envconfig:"GITHUB_PROXY_URL" secret:"true"`
+	// upper bound of a github call
+	GithubRequestTimeout time.Duration `envconfig:"GITHUB_REQUEST_TIMEOUT" default:"30s" reload:"true"`
+	// github GET responses kept to revalidate them with their ETag, 0 to disable
+	GithubETagCacheSize int `envconfig:"GITHUB_ETAG_CACHE_SIZE" default:"10000"`
+
+	// passthrough, record the github calls in GITHUB_CASSETTE_PATH or replay them from it
+	GithubCassetteMode string `envconfig:"GITHUB_CASSETTE_MODE" default:"passthrough"`
+	GithubCassettePath string `envconfig:"GITHUB_CASSETTE_PATH" default:"github_cassette.json"`
+	// what a call must have in common with a recorded one to be replayed, among method, url, query and body
+	GithubCassetteMatch []string `envconfig:"GITHUB_CASSETTE_MATCH" default:"method,url,query"`
+
+	// how /stats gets the stars, license and languages: rest (2 calls per repository) or graphql (1 call per 100 repositories)
+	StatsBackend string `envconfig:"STATS_BACKEND" default:"rest"`
+	// graphql api used by the graphql backend
+	GithubGraphQLURL string `envconfig:"GITHUB_GRAPHQL_URL" default:"https://api.github.com/graphql"`
+
+	// most github calls a /graphql query may cause, 0 for no limit
+	GraphQLMaxComplexity int `envconfig:"GRAPHQL_MAX_COMPLEXITY" default:"1000"`
+
+	// server side tokens used to call github, comma separated
+	GithubTokens []string `envconfig:"GITHUB_TOKENS" secret:"true"`
+	// authenticate as a github App, the installation token is added to the pool
+	GithubAppID             int64  `envconfig:"GITHUB_APP_ID"`
+	GithubAppInstallationID int64  `envconfig:"GITHUB_APP_INSTALLATION_ID"`
+	GithubAppPrivateKey     string `envconfig:"GITHUB_APP_PRIVATE_KEY" secret:"true"`
+	GithubAppPrivateKeyPath string `envconfig:"GITHUB_APP_PRIVATE_KEY_PATH"`
+	// forward the caller's Authorization header to github instead of using the server side tokens
+	GithubAllowCallerToken bool `envconfig:"GITHUB_ALLOW_CALLER_TOKEN" default:"false"`
+
+	// /readyz fails when the stats queue usage is above this ratio
+	HealthQueueSaturation float64 `envconfig:"HEALTH_QUEUE_SATURATION" default:"0.9"`
+	// /readyz fails when less github calls than this are left in the rate limit window
+	HealthMinRateLimit int `envconfig:"HEALTH_MIN_RATE_LIMIT" default:"200"`
+
+	// none, stdout or otlp
+	TracingExporter    string `envconfig:"TRACING_EXPORTER" default:"none"`
+	TracingServiceName string `envconfig:"TRACING_SERVICE_NAME" default:"sclng-backend-test-v1"`
+}
+
+// ConfigOptions
+// Where the configuration comes from besides the environment:
+// a YAML or TOML file, and settings given as flags which override everything else
+type ConfigOptions struct {
+	File      string
+	Overrides map[string]string
 }
 
-func newConfig() (*Config, error) {
+// newConfig
+// The settings are taken from, by increasing priority: the defaults, the file, the environment, the overrides
+// the settings of the file are named like the environment variables, in any case, and can be grouped in sections:
+// `github: {api_url: ...}` is GITHUB_API_URL
+func newConfig(options ConfigOptions) (*Config, error) {
 	var cfg Config
 	err := envconfig.Process("", &cfg)
 	if err != nil {
-		return nil, errors.Wrapf(err, "fail to build config from env")
+		return nil, pkgerrors.Wrapf(err, "fail to build config from env")
 	}
+
+	fields := configFields(&cfg)
+
+	if options.File != "" {
+		settings, err := readConfigFile(options.File)
+		if err != nil {
+			return nil, err
+		}
+
+		var errs []error
+		for name, value := range settings {
+			field, ok := fields[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("unknown setting `%s`", strings.ToLower(name)))
+				continue
+			}
+			// the environment comes after the file
+			if _, ok := os.LookupEnv(name); ok {
+				continue
+			}
+			if err := setConfigField(field, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", strings.ToLower(name), err))
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			return nil, fmt.Errorf("invalid config file %s:\n%w", options.File, err)
+		}
+	}
+
+	var errs []error
+	for name, value := range options.Overrides {
+		field, ok := fields[strings.ToUpper(name)]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown setting `%s`", name))
+			continue
+		}
+		if err := setConfigField(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid setting:\n%w", err)
+	}
+
+	cfg.applyGithubEnterpriseURL()
+
 	return &cfg, nil
 }
+
+// configFields
+// The fields of the config by the name of their environment variable
+func configFields(cfg *Config) map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+
+	value := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if name := value.Type().Field(i).Tag.Get("envconfig"); name != "" {
+			fields[name] = value.Field(i)
+		}
+	}
+
+	return fields
+}
+
+// setConfigField
+// Parse the value like envconfig does for the types of the config
+func setConfigField(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == reflect.TypeOf(time.Duration(0)):
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration `%s`", value)
+		}
+		field.SetInt(int64(duration))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean `%s`", value)
+		}
+		field.SetBool(parsed)
+	case field.Kind() == reflect.Int || field.Kind() == reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer `%s`", value)
+		}
+		field.SetInt(parsed)
+	case field.Kind() == reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number `%s`", value)
+		}
+		field.SetFloat(parsed)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		values := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// readConfigFile
+// The settings of a .yaml, .yml or .toml file, flattened to the names of the environment variables
+// lists are joined with commas like in the environment
+func readConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file failed: %w", err)
+	}
+
+	document := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &document)
+	case ".toml":
+		err = toml.Unmarshal(content, &document)
+	default:
+		return nil, fmt.Errorf("unknown config file format `%s`, expected .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	settings := map[string]string{}
+	err = flattenConfig("", document, settings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return settings, nil
+}
+
+func flattenConfig(prefix string, document map[string]any, settings map[string]string) error {
+	for key, value := range document {
+		name := strings.ToUpper(key)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+
+		switch value := value.(type) {
+		case map[string]any:
+			err := flattenConfig(name, value, settings)
+			if err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				if _, ok := item.(map[string]any); ok {
+					return fmt.Errorf("%s: expected a list of values", strings.ToLower(name))
+				}
+				items = append(items, fmt.Sprint(item))
+			}
+			settings[name] = strings.Join(items, ",")
+		case nil:
+			settings[name] = ""
+		default:
+			settings[name] = fmt.Sprint(value)
+		}
+	}
+	return nil
+}
+
+// Validate
+// Check the settings the service can't run with, every problem found is returned
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	for name, port := range map[string]int{"PORT": cfg.Port, "GRPC_PORT": cfg.GRPCPort} {
+		if port < 0 || port > 65535 {
+			errs = append(errs, fmt.Errorf("%s must be between 0 and 65535", name))
+		}
+	}
+	if cfg.Port == cfg.GRPCPort {
+		errs = append(errs, fmt.Errorf("PORT and GRPC_PORT must be different"))
+	}
+
+	switch cfg.TracingExporter {
+	case "", "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("unknown tracing exporter `%s`, expected none, stdout or otlp", cfg.TracingExporter))
+	}
+
+	githubURLs := map[string]string{
+		"GITHUB_API_URL":     cfg.GithubAPIURL,
+		"GITHUB_UPLOAD_URL":  cfg.GithubUploadURL,
+		"GITHUB_GRAPHQL_URL": cfg.GithubGraphQLURL,
+	}
+	if cfg.GithubEnterpriseURL != "" {
+		githubURLs["GITHUB_ENTERPRISE_URL"] = cfg.GithubEnterpriseURL
+	}
+	for name, value := range githubURLs {
+		if parsed, err := url.Parse(value); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("%s must be an http or https url", name))
+		}
+	}
+	if cfg.GithubProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.GithubProxyURL)
+		if err != nil || proxyURL.Host == "" || (proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5") {
+			// the url is not in the message, it can hold credentials
+			errs = append(errs, fmt.Errorf("GITHUB_PROXY_URL must be an http, https or socks5 url"))
+		}
+	}
+	if cfg.GithubCABundle != "" {
+		if _, err := loadCABundle(cfg.GithubCABundle); err != nil {
+			errs = append(errs, fmt.Errorf("GITHUB_CA_BUNDLE: %w", err))
+		}
+	}
+
+	switch cfg.GithubCassetteMode {
+	case cassetteModePassthrough, cassetteModeRecord, cassetteModeReplay:
+	default:
+		errs = append(errs, fmt.Errorf("unknown cassette mode `%s`, expected passthrough, record or replay", cfg.GithubCassetteMode))
+	}
+	for _, match := range cfg.GithubCassetteMatch {
+		if !cassetteMatchers[match] {
+			errs = append(errs, fmt.Errorf("unknown cassette match `%s`, expected method, url, query or body", match))
+		}
+	}
+
+	switch cfg.StatsBackend {
+	case statsBackendREST, statsBackendGraphQL:
+	default:
+		errs = append(errs, fmt.Errorf("unknown stats backend `%s`, expected rest or graphql", cfg.StatsBackend))
+	}
+
+	if cfg.WorkerCount <= 0 {
+		errs = append(errs, fmt.Errorf("WORKER_COUNT must be positive"))
+	}
+	if cfg.StatsQueueSize <= 0 {
+		errs = append(errs, fmt.Errorf("STATS_QUEUE_SIZE must be positive"))
+	}
+	if cfg.GithubETagCacheSize < 0 {
+		errs = append(errs, fmt.Errorf("GITHUB_ETAG_CACHE_SIZE must be positive, or 0 to disable it"))
+	}
+	if cfg.GithubRequestTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("GITHUB_REQUEST_TIMEOUT must be positive"))
+	}
+	if cfg.APIRateLimit < 0 || cfg.APIDailyQuota < 0 {
+		errs = append(errs, fmt.Errorf("API_RATE_LIMIT and API_DAILY_QUOTA can't be negative"))
+	}
+	if cfg.LogLevel != "" {
+		if _, err := logrus.ParseLevel(cfg.LogLevel); err != nil {
+			errs = append(errs, fmt.Errorf("unknown LOG_LEVEL `%s`, expected panic, fatal, error, warn, info or debug", cfg.LogLevel))
+		}
+	}
+	if cfg.SearchParallelism < 1 || cfg.SearchParallelism > 32 {
+		errs = append(errs, fmt.Errorf("SEARCH_PARALLELISM must be between 1 and 32"))
+	}
+	if cfg.SearchRateLimitShare <= 0 || cfg.SearchRateLimitShare > 1 {
+		errs = append(errs, fmt.Errorf("SEARCH_RATE_LIMIT_SHARE must be above 0 and at most 1"))
+	}
+	if cfg.StoreMaxRepositories < 0 {
+		errs = append(errs, fmt.Errorf("STORE_MAX_REPOSITORIES must be positive, or 0 for no limit"))
+	}
+	if cfg.SnapshotMaxTracked <= 0 {
+		errs = append(errs, fmt.Errorf("SNAPSHOT_MAX_TRACKED must be positive"))
+	} else if len(cfg.TrackedRepositories) > cfg.SnapshotMaxTracked {
+		errs = append(errs, fmt.Errorf("TRACKED_REPOSITORIES holds %d repositories, more than SNAPSHOT_MAX_TRACKED", len(cfg.TrackedRepositories)))
+	}
+	if cfg.EventsBufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("EVENTS_BUFFER_SIZE must be positive"))
+	}
+	if cfg.EventsPollInterval <= 0 || cfg.SnapshotInterval <= 0 {
+		errs = append(errs, fmt.Errorf("EVENTS_POLL_INTERVAL and SNAPSHOT_INTERVAL must be positive"))
+	}
+
+	if _, err := parseAPIKeys(cfg.APIKeys); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.GithubAppID != 0 {
+		if _, err := newGithubAppTokenSource(cfg); err != nil {
+			errs = append(errs, fmt.Errorf("github App configuration: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
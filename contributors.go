@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// at most 5 pages of 100 contributors are fetched for a repository
+const contributorsMaxPages = 5
+
+// Contributor
+// A contributor of a repository and its number of commits
+type Contributor struct {
+	Login         string `json:"login"`
+	Type          string `json:"type"`
+	Contributions int    `json:"contributions"`
+}
+
+// fetchContributors
+// Page through the contributors of a repository, the ones with the most commits first like github returns them
+// Returns whether some were left out because of contributorsMaxPages
+func fetchContributors(ctx context.Context, owner, name string) ([]Contributor, bool, error) {
+	ctx, span := tracer.Start(ctx, "fetchContributors")
+	defer span.End()
+
+	pages := newGithubPaginator[github.Contributor](
+		fmt.Sprintf("%s/repos/%s/%s/contributors", githubAPIURL, url.PathEscape(owner), url.PathEscape(name)),
+		map[string]string{"per_page": "100"},
+		contributorsMaxPages,
+	)
+
+	contributors, err := github.CollectPages(ctx, pages)
+	if err != nil {
+		return nil, false, fmt.Errorf("list %s/%s contributors failed: %w", owner, name, err)
+	}
+
+	span.SetAttributes(
+		attribute.Int("github.contributors", len(contributors)),
+		attribute.Bool("github.contributors.truncated", pages.Truncated()),
+	)
+
+	results := make([]Contributor, 0, len(contributors))
+	for _, contributor := range contributors {
+		results = append(results, Contributor{
+			Login:         contributor.Login,
+			Type:          contributor.Type,
+			Contributions: contributor.Contributions,
+		})
+	}
+
+	return results, pages.Truncated(), nil
+}
+
+// repositoryContributorsHandlerGet
+// The contributors of a repository, `truncated` is true when it has more than we fetch
+func repositoryContributorsHandlerGet(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	log := logger.Get(r.Context())
+
+	contributors, truncated, err := fetchContributors(withCallerAuthorization(r), vars["owner"], vars["name"])
+	if isNotFound(err) {
+		writeJSONError(w, r, http.StatusNotFound, fmt.Sprintf("repository `%s/%s` not found", vars["owner"], vars["name"]))
+		return nil
+	}
+	if err != nil {
+		writeJSONError(w, r, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(map[string]any{
+		"contributors": contributors,
+		"truncated":    truncated,
+	})
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+)
+
+// ETagCache
+// The last response of the github GET calls answered with an ETag, by url, credentials and Accept header
+// the calls are sent again with If-None-Match, github answers an unchanged resource with a 304
+// which doesn't count against the rate limit
+// past size entries the least recently used are dropped
+type ETagCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	// keys from the most to the least recently used
+	recent *list.List
+}
+
+type etagCacheEntry struct {
+	key  string
+	etag string
+	body []byte
+}
+
+func newETagCache(size int) *ETagCache {
+	return &ETagCache{
+		size:    size,
+		entries: map[string]*list.Element{},
+		recent:  list.New(),
+	}
+}
+
+// responses revalidated with github, set in initApp
+var githubETagCache = newETagCache(10000)
+
+// Get
+// The ETag and body of the last response cached for the key
+func (cache *ETagCache) Get(key string) (string, []byte, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.entries[key]
+	if !ok {
+		return "", nil, false
+	}
+	cache.recent.MoveToFront(element)
+
+	entry := element.Value.(*etagCacheEntry)
+	return entry.etag, entry.body, true
+}
+
+// Put
+// Keep the response, a size of 0 keeps nothing
+func (cache *ETagCache) Put(key, etag string, body []byte) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.size <= 0 {
+		return
+	}
+
+	if element, ok := cache.entries[key]; ok {
+		element.Value = &etagCacheEntry{key: key, etag: etag, body: body}
+		cache.recent.MoveToFront(element)
+		return
+	}
+
+	cache.entries[key] = cache.recent.PushFront(&etagCacheEntry{key: key, etag: etag, body: body})
+
+	for cache.recent.Len() > cache.size {
+		oldest := cache.recent.Back()
+		cache.recent.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*etagCacheEntry).key)
+	}
+}
+
+// conditionalRoundTrip
+// Send the GET call with the ETag of the response cached for it, a 304 returns the cached body as a 200
+// a caller sending its own If-None-Match gets the 304
+func conditionalRoundTrip(req *http.Request, key string) (*httpResponse, error) {
+	if req.Header.Get("If-None-Match") != "" {
+		return roundTrip(req)
+	}
+
+	etag, body, cached := githubETagCache.Get(key)
+	if cached {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	response, err := roundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cached && response.res.StatusCode == http.StatusNotModified:
+		res := *response.res
+		res.StatusCode = http.StatusOK
+		res.Status = "200 OK"
+		return &httpResponse{res: &res, body: body}, nil
+	case response.res.StatusCode == http.StatusOK && response.res.Header.Get("ETag") != "":
+		githubETagCache.Put(key, response.res.Header.Get("ETag"), response.body)
+	}
+
+	return response, nil
+}
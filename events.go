@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// EventStore
+// The last public events polled from github, oldest first
+// Events are deduplicated on their id, github returns the same events on consecutive polls
+type EventStore struct {
+	mu     sync.RWMutex
+	size   int
+	events []github.Event
+	seen   map[string]bool
+}
+
+// how many events are kept when no size is given, as EVENTS_BUFFER_SIZE
+const defaultEventsBufferSize = 10000
+
+func newEventStore(size int) *EventStore {
+	if size <= 0 {
+		size = defaultEventsBufferSize
+	}
+
+	return &EventStore{
+		size: size,
+		seen: map[string]bool{},
+	}
+}
+
+// events polled by the poller and served by /events, set in initApp
+var eventStore = newEventStore(defaultEventsBufferSize)
+
+// Add
+// Keep the events not seen yet, the oldest ones are dropped once the store is full
+// Returns how many events were added
+func (store *EventStore) Add(events []github.Event) int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// github returns the most recent event first
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt.Before(events[j].CreatedAt)
+	})
+
+	added := 0
+	for _, event := range events {
+		if store.seen[event.Id] {
+			continue
+		}
+
+		store.seen[event.Id] = true
+		store.events = append(store.events, event)
+		added++
+	}
+
+	if overflow := len(store.events) - store.size; overflow > 0 {
+		for _, event := range store.events[:overflow] {
+			delete(store.seen, event.Id)
+		}
+		store.events = append([]github.Event(nil), store.events[overflow:]...)
+	}
+
+	return added
+}
+
+// EventFilter
+// Filters of /events, empty fields match everything
+type EventFilter struct {
+	Type    string
+	Actor   string
+	Repo    string
+	RefType string
+	Since   time.Time
+}
+
+func newEventFilter(params url.Values) (EventFilter, error) {
+	filter := EventFilter{
+		Type:    params.Get("type"),
+		Actor:   params.Get("actor"),
+		Repo:    params.Get("repo"),
+		RefType: params.Get("ref_type"),
+	}
+
+	if window := params.Get("window"); window != "" {
+		duration, err := time.ParseDuration(window)
+		if err != nil {
+			return filter, fmt.Errorf("invalid window `%s`: %w", window, err)
+		}
+		filter.Since = time.Now().Add(-duration)
+	}
+
+	return filter, nil
+}
+
+func (filter EventFilter) Match(event github.Event) bool {
+	return (filter.Type == "" || event.Type == filter.Type) &&
+		(filter.Actor == "" || event.Actor.Login == filter.Actor) &&
+		(filter.Repo == "" || event.Repo.Name == filter.Repo) &&
+		(filter.RefType == "" || event.Payload.RefType == filter.RefType) &&
+		(filter.Since.IsZero() || !event.CreatedAt.Before(filter.Since))
+}
+
+// List
+// The events matching the filter, most recent first
+func (store *EventStore) List(filter EventFilter, limit int) []github.Event {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	results := []github.Event{}
+	for i := len(store.events) - 1; i >= 0 && (limit <= 0 || len(results) < limit); i-- {
+		if filter.Match(store.events[i]) {
+			results = append(results, store.events[i])
+		}
+	}
+
+	return results
+}
+
+// EventAggregates
+// Counts computed over the events matching a filter
+type EventAggregates struct {
+	Total                        int            `json:"total"`
+	ByType                       map[string]int `json:"by_type"`
+	RepositoriesCreatedPerMinute []MinuteCount  `json:"repositories_created_per_minute"`
+}
+
+type MinuteCount struct {
+	Minute time.Time `json:"minute"`
+	Count  int       `json:"count"`
+}
+
+// Aggregates
+// Count the events by type and the repositories created (CreateEvent with ref_type=repository) per minute
+func (store *EventStore) Aggregates(filter EventFilter) EventAggregates {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	aggregates := EventAggregates{
+		ByType:                       map[string]int{},
+		RepositoriesCreatedPerMinute: []MinuteCount{},
+	}
+
+	perMinute := map[time.Time]int{}
+
+	for _, event := range store.events {
+		if !filter.Match(event) {
+			continue
+		}
+
+		aggregates.Total++
+		aggregates.ByType[event.Type]++
+
+		if event.Type == "CreateEvent" && event.Payload.RefType == "repository" {
+			perMinute[event.CreatedAt.Truncate(time.Minute)]++
+		}
+	}
+
+	for minute, count := range perMinute {
+		aggregates.RepositoriesCreatedPerMinute = append(aggregates.RepositoriesCreatedPerMinute, MinuteCount{Minute: minute, Count: count})
+	}
+	sort.Slice(aggregates.RepositoriesCreatedPerMinute, func(i, j int) bool {
+		return aggregates.RepositoriesCreatedPerMinute[i].Minute.Before(aggregates.RepositoriesCreatedPerMinute[j].Minute)
+	})
+
+	return aggregates
+}
+
+// startEventsPoller
+// Poll the github public events until the context is done
+// Waits at least the X-Poll-Interval github asks for between two polls
+// and sends the ETag of the last response so unchanged feeds don't cost anything
+func startEventsPoller(ctx context.Context, store *EventStore, interval time.Duration) {
+	log := logger.Get(ctx)
+
+	var etag string
+
+	for {
+		wait := interval
+
+		pollInterval, newEtag, err := pollEvents(ctx, store, etag)
+		if err != nil {
+			log.WithError(err).Warn("poll github events failed")
+		} else {
+			etag = newEtag
+			if pollInterval > wait {
+				wait = pollInterval
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// pollEvents
+// Fetch the last page of public events
+// Returns the poll interval asked by github and the ETag of the response
+func pollEvents(ctx context.Context, store *EventStore, etag string) (time.Duration, string, error) {
+	ctx, span := tracer.Start(ctx, "pollEvents")
+	defer span.End()
+
+	log := logger.Get(ctx)
+
+	httpRequest := HttpRequest{
+		Method: http.MethodGet,
+		Url:    githubAPIURL + "/events",
+		Headers: map[string]string{
+			"Accept": "application/vnd.github.v3+json",
+		},
+		Query: map[string]string{"per_page": "100"},
+	}
+
+	if etag != "" {
+		httpRequest.Headers["If-None-Match"] = etag
+	}
+
+	var events []github.Event
+
+	res, err := httpRequest.Do(ctx, &events)
+	if err != nil {
+		return 0, "", fmt.Errorf("fetch github events failed: %w", err)
+	}
+
+	pollInterval := time.Duration(0)
+	if seconds, err := strconv.Atoi(res.Header.Get("X-Poll-Interval")); err == nil {
+		pollInterval = time.Duration(seconds) * time.Second
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		span.SetAttributes(attribute.Bool("github.events.not_modified", true))
+		return pollInterval, etag, nil
+	}
+
+	added := store.Add(events)
+	created := createdRepositories(events)
+	repositoryStore.Add(created)
+	markIngestion()
+
+	span.SetAttributes(
+		attribute.Int("github.events.added", added),
+		attribute.Int("github.events.repositories_created", len(created)),
+	)
+	log.Debugf("polled %d github events, %d new, %d repositories created", len(events), added, len(created))
+
+	return pollInterval, res.Header.Get("ETag"), nil
+}
+
+// createdRepositories
+// The partial records of the repositories created by the events (CreateEvent with ref_type=repository)
+// like the ones of /repositories, the stats fetch the rest
+func createdRepositories(events []github.Event) []github.Repository {
+	var repositories []github.Repository
+	for _, event := range events {
+		if event.Type != "CreateEvent" || event.Payload.RefType != "repository" {
+			continue
+		}
+
+		owner, name, ok := strings.Cut(event.Repo.Name, "/")
+		if !ok {
+			continue
+		}
+
+		var repository github.Repository
+		repository.Id = event.Repo.Id
+		repository.Name = name
+		repository.FullName = event.Repo.Name
+		repository.Owner.Login = owner
+		repository.Url = event.Repo.Url
+		repository.LanguagesUrl = event.Repo.Url + "/languages"
+		repository.Description = event.Payload.Description
+		repository.CreatedAt = event.CreatedAt
+		repositories = append(repositories, repository)
+	}
+
+	return repositories
+}
+
+// eventsHandlerGet
+// List the last public events, filtered with type, actor, repo, ref_type and window
+func eventsHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+	log := logger.Get(r.Context())
+
+	filter, err := newEventFilter(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	limit := 100
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid limit `%s`", limitParam))
+			return nil
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(eventStore.List(filter, limit))
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
+
+// eventsAggregatesHandlerGet
+// Aggregates over the events matching the same filters as /events
+func eventsAggregatesHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+	log := logger.Get(r.Context())
+
+	filter, err := newEventFilter(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(eventStore.Aggregates(filter))
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+// eventIDs
+// The ids of the events as github numbers them
+func eventIDs(t *testing.T, events []github.Event) []int {
+	t.Helper()
+
+	ids := make([]int, 0, len(events))
+	for _, event := range events {
+		id, err := strconv.Atoi(event.Id)
+		if err != nil {
+			t.Fatalf("event id %q is not a number", event.Id)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func newTestEvents(from, to int) []github.Event {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var events []github.Event
+	for i := from; i < to; i++ {
+		var event github.Event
+		event.Id = fmt.Sprint(i)
+		event.CreatedAt = start.Add(time.Duration(i) * time.Second)
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestEventStoreDefaultSize(t *testing.T) {
+	// the store used before the configuration is read keeps the events published to it
+	store := newEventStore(0)
+	if added := store.Add(newTestEvents(0, 10)); added != 10 {
+		t.Fatalf("expected 10 events added, got %d", added)
+	}
+	if events := store.List(EventFilter{}, 100); len(events) != 10 {
+		t.Errorf("expected 10 events kept, got %d", len(events))
+	}
+}
+
+func TestEventStoreOverflow(t *testing.T) {
+	store := newEventStore(5)
+	store.Add(newTestEvents(0, 4))
+	if added := store.Add(newTestEvents(2, 8)); added != 4 {
+		t.Errorf("expected the 4 events not seen yet added, got %d", added)
+	}
+
+	// the 5 most recent, most recent first
+	if ids := eventIDs(t, store.List(EventFilter{}, 100)); !reflect.DeepEqual(ids, []int{7, 6, 5, 4, 3}) {
+		t.Errorf("expected the events 7 to 3 kept, got %v", ids)
+	}
+}
+
+func TestCreatedRepositories(t *testing.T) {
+	events := newTestEvents(0, 3)
+	events[0].Type, events[0].Payload.RefType = "CreateEvent", "repository"
+	events[0].Repo.Id, events[0].Repo.Name, events[0].Repo.Url = 42, "octo/cat", "https://api.github.com/repos/octo/cat"
+	// a branch created, a push
+	events[1].Type, events[1].Payload.RefType = "CreateEvent", "branch"
+	events[1].Repo.Id, events[1].Repo.Name = 43, "octo/dog"
+	events[2].Type = "PushEvent"
+	events[2].Repo.Id, events[2].Repo.Name = 44, "octo/cow"
+
+	repositories := createdRepositories(events)
+	if len(repositories) != 1 {
+		t.Fatalf("expected only the repository created, got %+v", repositories)
+	}
+	repository := repositories[0]
+	if repository.Id != 42 || repository.FullName != "octo/cat" || repository.Name != "cat" || repository.Owner.Login != "octo" ||
+		repository.LanguagesUrl != "https://api.github.com/repos/octo/cat/languages" || !repository.CreatedAt.Equal(events[0].CreatedAt) {
+		t.Errorf("unexpected repository %+v", repository)
+	}
+}
+
+func TestPollEventsFeedsRepositoryStore(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 1, Count: 150, FirstID: 1})
+	store := newEventStore(0)
+
+	interval, etag, err := pollEvents(testContext(), store, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interval != time.Minute || etag == "" {
+		t.Errorf("expected the poll interval and the ETag of github, got %s and %q", interval, etag)
+	}
+
+	latest := server.Latest(100)
+	expected := make([]uint, 0, len(latest))
+	for _, repository := range latest {
+		expected = append(expected, uint(repository.ID))
+	}
+
+	var polled []uint
+	for _, event := range store.List(EventFilter{}, 0) {
+		polled = append(polled, event.Repo.Id)
+	}
+	// events created the same second are in no particular order
+	sort.Slice(polled, func(i, j int) bool { return polled[i] > polled[j] })
+	if !reflect.DeepEqual(polled, expected) {
+		t.Errorf("expected the creation of the last 100 repositories, got %v", polled)
+	}
+
+	if repositoryStore.Len() != len(latest) {
+		t.Errorf("expected the %d repositories created in the store, got %d", len(latest), repositoryStore.Len())
+	}
+	for _, repository := range latest {
+		stored, ok := repositoryStore.Get(repository.FullName())
+		if !ok || stored.Repository.Id != uint(repository.ID) || stored.Repository.Owner.Login != repository.Owner {
+			t.Errorf("%s: expected it stored with id %d, got %+v", repository.FullName(), repository.ID, stored.Repository)
+		}
+	}
+
+	// the feed didn't change, github answers a 304
+	_, _, err = pollEvents(testContext(), store, etag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := server.Create(5)
+	_, _, err = pollEvents(testContext(), store, etag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, repository := range created {
+		if _, ok := repositoryStore.Get(repository.FullName()); !ok {
+			t.Errorf("expected the new repository %s stored", repository.FullName())
+		}
+	}
+	if repositoryStore.Len() != len(latest)+len(created) {
+		t.Errorf("expected %d repositories stored, got %d", len(latest)+len(created), repositoryStore.Len())
+	}
+}
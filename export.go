@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	exportFormatJSON    = "json"
+	exportFormatCSV     = "csv"
+	exportFormatTSV     = "tsv"
+	exportFormatParquet = "parquet"
+
+	// wide: one line per repository, a column per language
+	exportLayoutWide = "wide"
+	// long: one line per repository and language
+	exportLayoutLong = "long"
+)
+
+// content type of each export format, the first one is the one we answer with
+var exportContentTypes = map[string][]string{
+	exportFormatJSON:    {"application/json"},
+	exportFormatCSV:     {"text/csv"},
+	exportFormatTSV:     {"text/tab-separated-values"},
+	exportFormatParquet: {"application/vnd.apache.parquet", "application/x-parquet"},
+}
+
+// ExportOptions
+// How the stats are exported
+type ExportOptions struct {
+	Format string
+	Layout string
+	// a BOM, CRLF line endings and no formula in the cells, for spreadsheets
+	Excel bool
+}
+
+// acceptedFormat
+// The format of the Accept header with the highest q-value, the first one listed on a tie
+// ranges with q=0 are refused, */* and an Accept matching none of the formats give the fallback
+func acceptedFormat(accept string, contentTypes map[string][]string, fallback string) string {
+	format, quality := fallback, 0.0
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		if q <= quality {
+			continue
+		}
+
+		if mediaType == "*/*" {
+			format, quality = fallback, q
+			continue
+		}
+
+		for name, types := range contentTypes {
+			for _, contentType := range types {
+				if mediaType == contentType {
+					format, quality = name, q
+				}
+			}
+		}
+	}
+
+	return format
+}
+
+// newExportOptions
+// format: json, csv, tsv or parquet, from the Accept header only if not given
+// layout: wide (default) or long, for csv and tsv
+// excel: true to open the csv in a spreadsheet
+func newExportOptions(params url.Values, accept string) (ExportOptions, error) {
+	options := ExportOptions{
+		Format: params.Get("format"),
+		Layout: params.Get("layout"),
+	}
+
+	switch options.Format {
+	case "":
+		options.Format = acceptedFormat(accept, exportContentTypes, exportFormatJSON)
+	case exportFormatJSON, exportFormatCSV, exportFormatTSV, exportFormatParquet:
+	default:
+		return options, fmt.Errorf("invalid format `%s`, expected json, csv, tsv or parquet", options.Format)
+	}
+
+	switch options.Layout {
+	case "":
+		options.Layout = exportLayoutWide
+	case exportLayoutWide, exportLayoutLong:
+	default:
+		return options, fmt.Errorf("invalid layout `%s`, expected wide or long", options.Layout)
+	}
+
+	if excel := params.Get("excel"); excel != "" {
+		var err error
+		options.Excel, err = strconv.ParseBool(excel)
+		if err != nil {
+			return options, fmt.Errorf("invalid excel `%s`, expected true or false", excel)
+		}
+	}
+
+	return options, nil
+}
+
+// exportStats
+// Write the stats in the format of the options, rows are written as they come
+func exportStats(w io.Writer, stats []Stats, options ExportOptions) error {
+	switch options.Format {
+	case exportFormatCSV:
+		return exportStatsDelimited(w, stats, ',', options)
+	case exportFormatTSV:
+		return exportStatsDelimited(w, stats, '\t', options)
+	case exportFormatParquet:
+		return exportStatsParquet(w, stats)
+	default:
+		return fmt.Errorf("format `%s` can't be exported", options.Format)
+	}
+}
+
+// columns common to both layouts
+var exportColumns = []string{"full_name", "owner", "name", "url", "description", "license", "stars_count", "topics"}
+
+func exportRow(stat Stats) []string {
+	return []string{
+		stat.Owner + "/" + stat.Name,
+		stat.Owner,
+		stat.Name,
+		stat.Url,
+		stat.Description,
+		stat.License,
+		strconv.Itoa(stat.StarCount),
+		strings.Join(stat.Topics, ";"),
+	}
+}
+
+// exportLanguages
+// Every language of the stats, sorted, they are the columns of the wide layout
+func exportLanguages(stats []Stats) []string {
+	seen := map[string]bool{}
+	for _, stat := range stats {
+		for language := range stat.Languages {
+			seen[language] = true
+		}
+	}
+
+	languages := make([]string, 0, len(seen))
+	for language := range seen {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+
+	return languages
+}
+
+// excelCell
+// Spreadsheets evaluate the cells starting with one of these as formulas
+// descriptions come from github, they are escaped with a quote
+func excelCell(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// exportStatsDelimited
+// CSV or TSV, wide: a `languages.<name>` column per language with its bytes of code
+// long: a language and a bytes column, one line per language of the repository
+// a repository without languages has a single line with empty ones
+func exportStatsDelimited(w io.Writer, stats []Stats, comma rune, options ExportOptions) error {
+	if options.Excel {
+		_, err := io.WriteString(w, "\ufeff")
+		if err != nil {
+			return err
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+	writer.UseCRLF = options.Excel
+
+	write := func(row []string) error {
+		if options.Excel {
+			for i := range row {
+				row[i] = excelCell(row[i])
+			}
+		}
+		return writer.Write(row)
+	}
+
+	header := append([]string{}, exportColumns...)
+
+	if options.Layout == exportLayoutLong {
+		err := write(append(header, "language", "bytes"))
+		if err != nil {
+			return err
+		}
+
+		for _, stat := range stats {
+			languages := make([]string, 0, len(stat.Languages))
+			for language := range stat.Languages {
+				languages = append(languages, language)
+			}
+			sort.Strings(languages)
+
+			if len(languages) == 0 {
+				err := write(append(exportRow(stat), "", ""))
+				if err != nil {
+					return err
+				}
+			}
+
+			for _, language := range languages {
+				err := write(append(exportRow(stat), language, strconv.Itoa(stat.Languages[language])))
+				if err != nil {
+					return err
+				}
+			}
+
+			writer.Flush()
+		}
+	} else {
+		languages := exportLanguages(stats)
+		for _, language := range languages {
+			header = append(header, "languages."+language)
+		}
+
+		err := write(header)
+		if err != nil {
+			return err
+		}
+
+		for _, stat := range stats {
+			row := exportRow(stat)
+			for _, language := range languages {
+				size, ok := stat.Languages[language]
+				if !ok {
+					row = append(row, "")
+					continue
+				}
+				row = append(row, strconv.Itoa(size))
+			}
+
+			err := write(row)
+			if err != nil {
+				return err
+			}
+
+			writer.Flush()
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// StatsParquetRow
+// The parquet schema of the stats, it doesn't depend on the languages exported
+type StatsParquetRow struct {
+	FullName    string           `parquet:"name=full_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Owner       string           `parquet:"name=owner, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Name        string           `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Url         string           `parquet:"name=url, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Description string           `parquet:"name=description, type=BYTE_ARRAY, convertedtype=UTF8"`
+	License     string           `parquet:"name=license, type=BYTE_ARRAY, convertedtype=UTF8"`
+	StarsCount  int64            `parquet:"name=stars_count, type=INT64"`
+	Topics      []string         `parquet:"name=topics, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	Languages   map[string]int64 `parquet:"name=languages, type=MAP, keytype=BYTE_ARRAY, keyconvertedtype=UTF8, valuetype=INT64"`
+}
+
+// rows buffered before being flushed as a row group
+const exportParquetBatchSize = 1000
+
+func exportStatsParquet(w io.Writer, stats []Stats) error {
+	parquetWriter, err := writer.NewParquetWriterFromWriter(w, new(StatsParquetRow), 1)
+	if err != nil {
+		return fmt.Errorf("create parquet writer failed: %w", err)
+	}
+	parquetWriter.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	for i, stat := range stats {
+		row := StatsParquetRow{
+			FullName:    stat.Owner + "/" + stat.Name,
+			Owner:       stat.Owner,
+			Name:        stat.Name,
+			Url:         stat.Url,
+			Description: stat.Description,
+			License:     stat.License,
+			StarsCount:  int64(stat.StarCount),
+			Topics:      stat.Topics,
+			Languages:   make(map[string]int64, len(stat.Languages)),
+		}
+		for language, size := range stat.Languages {
+			row.Languages[language] = int64(size)
+		}
+
+		err := parquetWriter.Write(row)
+		if err != nil {
+			return fmt.Errorf("write parquet row failed: %w", err)
+		}
+
+		if (i+1)%exportParquetBatchSize == 0 {
+			err := parquetWriter.Flush(true)
+			if err != nil {
+				return fmt.Errorf("flush parquet rows failed: %w", err)
+			}
+		}
+	}
+
+	err = parquetWriter.WriteStop()
+	if err != nil {
+		return fmt.Errorf("close parquet writer failed: %w", err)
+	}
+
+	return nil
+}
+
+// writeStatsExport
+// Stream the stats as a download, named after the time of the export
+func writeStatsExport(w http.ResponseWriter, r *http.Request, stats []Stats, options ExportOptions) {
+	log := logger.Get(r.Context())
+
+	fileName := fmt.Sprintf("stats-%s.%s", time.Now().UTC().Format("20060102T150405Z"), options.Format)
+
+	contentType := exportContentTypes[options.Format][0]
+	if options.Format != exportFormatParquet {
+		contentType += "; charset=utf-8"
+	}
+
+	w.Header().Add("Content-Type", contentType)
+	w.Header().Add("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+
+	err := exportStats(w, stats, options)
+	if err != nil {
+		log.WithError(err).Errorf("Fail to export %s", options.Format)
+	}
+}
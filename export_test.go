@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+var exportTestStats = []Stats{
+	{
+		Repo:      Repo{Owner: "octo", Name: "cat", Url: "https://api.github.com/repos/octo/cat", Description: "=HYPERLINK(\"http://evil.test\")"},
+		StarCount: 12,
+		Languages: map[string]int{"Go": 100, "C": 20},
+		License:   "mit",
+		Topics:    []string{"cli", "go"},
+	},
+	{
+		Repo:      Repo{Owner: "octo", Name: "dog", Description: "a\ttab, a \"quote\"\nand a new line"},
+		StarCount: 3,
+		Languages: map[string]int{"Rust": 7},
+	},
+	{
+		Repo: Repo{Owner: "octo", Name: "empty", Description: "@SUM(A1:A2)"},
+	},
+}
+
+func TestAcceptedFormat(t *testing.T) {
+	cases := map[string]string{
+		"":                          exportFormatJSON,
+		"text/csv":                  exportFormatCSV,
+		" TEXT/CSV ; charset=utf-8": exportFormatCSV,
+		"text/csv;q=0":              exportFormatJSON,
+		"text/csv;q=0, text/tab-separated-values": exportFormatTSV,
+		"application/json;q=0.9, text/csv":        exportFormatCSV,
+		"text/csv;q=0.5, application/json":        exportFormatJSON,
+		"text/csv, text/tab-separated-values":     exportFormatCSV,
+		"text/csv;q=0.8, application/x-parquet":   exportFormatParquet,
+		"text/csv;q=0.1, */*":                     exportFormatJSON,
+		"text/csv;q=high":                         exportFormatJSON,
+		"text/csv;q=2":                            exportFormatJSON,
+		"image/png, text/html;q=0.9":              exportFormatJSON,
+	}
+	for accept, expected := range cases {
+		if format := acceptedFormat(accept, exportContentTypes, exportFormatJSON); format != expected {
+			t.Errorf("%q: expected %s, got %s", accept, expected, format)
+		}
+	}
+}
+
+func TestNewExportOptions(t *testing.T) {
+	cases := []struct {
+		query    string
+		accept   string
+		expected ExportOptions
+		err      bool
+	}{
+		{query: "", expected: ExportOptions{Format: exportFormatJSON, Layout: exportLayoutWide}},
+		{query: "", accept: "text/csv", expected: ExportOptions{Format: exportFormatCSV, Layout: exportLayoutWide}},
+		{query: "format=json", accept: "text/csv", expected: ExportOptions{Format: exportFormatJSON, Layout: exportLayoutWide}},
+		{query: "format=tsv&layout=long&excel=true", accept: "text/csv", expected: ExportOptions{Format: exportFormatTSV, Layout: exportLayoutLong, Excel: true}},
+		{query: "format=xml", err: true},
+		{query: "layout=tall", err: true},
+		{query: "excel=maybe", err: true},
+	}
+	for _, c := range cases {
+		params, _ := url.ParseQuery(c.query)
+		options, err := newExportOptions(params, c.accept)
+		if (err != nil) != c.err {
+			t.Errorf("%q %q: expected an error %v, got %v", c.query, c.accept, c.err, err)
+			continue
+		}
+		if !c.err && options != c.expected {
+			t.Errorf("%q %q: got %+v, expected %+v", c.query, c.accept, options, c.expected)
+		}
+	}
+}
+
+func TestExportStatsCSVExcel(t *testing.T) {
+	var content bytes.Buffer
+	if err := exportStats(&content, exportTestStats, ExportOptions{Format: exportFormatCSV, Layout: exportLayoutWide, Excel: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(content.String(), "\ufefffull_name,") || !strings.Contains(content.String(), "\r\n") {
+		t.Fatalf("expected a BOM and CRLF line endings, got %q", content.String())
+	}
+
+	lines, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(content.String(), "\ufeff"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptions := []string{lines[1][4], lines[2][4], lines[3][4]}
+	expected := []string{"'=HYPERLINK(\"http://evil.test\")", "a\ttab, a \"quote\"\nand a new line", "'@SUM(A1:A2)"}
+	if !reflect.DeepEqual(descriptions, expected) {
+		t.Errorf("expected the formulas escaped, got %q", descriptions)
+	}
+
+	// without excel the cells are left as they are
+	content.Reset()
+	if err := exportStats(&content, exportTestStats, ExportOptions{Format: exportFormatCSV, Layout: exportLayoutWide}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(content.String(), "\ufeff") || strings.Contains(content.String(), "'=") || strings.Contains(content.String(), "\r\n") {
+		t.Errorf("expected a plain CSV, got %q", content.String())
+	}
+}
+
+func TestExcelCell(t *testing.T) {
+	cases := map[string]string{
+		"":        "",
+		"=1+1":    "'=1+1",
+		"+33 1":   "'+33 1",
+		"-1":      "'-1",
+		"@cmd":    "'@cmd",
+		"\tcell":  "'\tcell",
+		"\rcell":  "'\rcell",
+		"a=b":     "a=b",
+		"plain":   "plain",
+		"'quoted": "'quoted",
+	}
+	for cell, expected := range cases {
+		if escaped := excelCell(cell); escaped != expected {
+			t.Errorf("%q: expected %q, got %q", cell, expected, escaped)
+		}
+	}
+}
+
+func TestExportStatsTSVRoundTrip(t *testing.T) {
+	cases := []struct {
+		layout string
+		lines  [][]string
+	}{
+		{
+			layout: exportLayoutWide,
+			lines: [][]string{
+				{"full_name", "owner", "name", "url", "description", "license", "stars_count", "topics", "languages.C", "languages.Go", "languages.Rust"},
+				{"octo/cat", "octo", "cat", "https://api.github.com/repos/octo/cat", "=HYPERLINK(\"http://evil.test\")", "mit", "12", "cli;go", "20", "100", ""},
+				{"octo/dog", "octo", "dog", "", "a\ttab, a \"quote\"\nand a new line", "", "3", "", "", "", "7"},
+				{"octo/empty", "octo", "empty", "", "@SUM(A1:A2)", "", "0", "", "", "", ""},
+			},
+		},
+		{
+			layout: exportLayoutLong,
+			lines: [][]string{
+				{"full_name", "owner", "name", "url", "description", "license", "stars_count", "topics", "language", "bytes"},
+				{"octo/cat", "octo", "cat", "https://api.github.com/repos/octo/cat", "=HYPERLINK(\"http://evil.test\")", "mit", "12", "cli;go", "C", "20"},
+				{"octo/cat", "octo", "cat", "https://api.github.com/repos/octo/cat", "=HYPERLINK(\"http://evil.test\")", "mit", "12", "cli;go", "Go", "100"},
+				{"octo/dog", "octo", "dog", "", "a\ttab, a \"quote\"\nand a new line", "", "3", "", "Rust", "7"},
+				{"octo/empty", "octo", "empty", "", "@SUM(A1:A2)", "", "0", "", "", ""},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.layout, func(t *testing.T) {
+			var content bytes.Buffer
+			if err := exportStats(&content, exportTestStats, ExportOptions{Format: exportFormatTSV, Layout: c.layout}); err != nil {
+				t.Fatal(err)
+			}
+
+			tsv := csv.NewReader(&content)
+			tsv.Comma = '\t'
+			lines, err := tsv.ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(lines, c.lines) {
+				t.Errorf("got %q, expected %q", lines, c.lines)
+			}
+		})
+	}
+}
+
+func TestExportStatsParquetRoundTrip(t *testing.T) {
+	var content bytes.Buffer
+	if err := exportStats(&content, exportTestStats, ExportOptions{Format: exportFormatParquet}); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := buffer.NewBufferFile(content.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parquetReader, err := reader.NewParquetReader(file, new(StatsParquetRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parquetReader.ReadStop()
+
+	rows := make([]StatsParquetRow, parquetReader.GetNumRows())
+	if err := parquetReader.Read(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(exportTestStats) {
+		t.Fatalf("expected %d rows, got %d", len(exportTestStats), len(rows))
+	}
+
+	for i, row := range rows {
+		stat := exportTestStats[i]
+		if row.FullName != stat.Owner+"/"+stat.Name || row.Url != stat.Url || row.Description != stat.Description || row.License != stat.License || row.StarsCount != int64(stat.StarCount) {
+			t.Errorf("row %d: got %+v, expected %+v", i, row, stat)
+		}
+
+		if len(row.Topics) != len(stat.Topics) || (len(row.Topics) > 0 && !reflect.DeepEqual(row.Topics, stat.Topics)) {
+			t.Errorf("row %d: expected the topics %v, got %v", i, stat.Topics, row.Topics)
+		}
+
+		if len(row.Languages) != len(stat.Languages) {
+			t.Errorf("row %d: expected the languages %v, got %v", i, stat.Languages, row.Languages)
+		}
+		for language, size := range stat.Languages {
+			if row.Languages[language] != int64(size) {
+				t.Errorf("row %d: expected %d bytes of %s, got %d", i, size, language, row.Languages[language])
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// refresh the installation token this long before github expires it
+const appTokenRefreshMargin = 5 * time.Minute
+
+// upper bound of an exchange, it doesn't depend on the callers waiting for it
+const appTokenExchangeTimeout = 30 * time.Second
+
+// AppTokenSource
+// Authenticate as a github App
+// A JWT signed with the App private key is exchanged for an installation access token
+// the installation token is cached and refreshed before it expires
+type AppTokenSource struct {
+	AppID          int64
+	InstallationID int64
+	PrivateKey     *rsa.PrivateKey
+
+	// api the tokens are requested to, https://api.github.com for github.com
+	BaseURL string
+	Client  *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	// the exchange of the JWT for a new token in flight
+	exchanges singleflight.Group
+}
+
+// NewAppTokenSource
+// privateKey is the PEM encoded key downloaded from the App settings
+func NewAppTokenSource(appID, installationID int64, privateKey []byte, baseURL string) (*AppTokenSource, error) {
+	key, err := ParseRSAPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppTokenSource{
+		AppID:          appID,
+		InstallationID: installationID,
+		PrivateKey:     key,
+		BaseURL:        strings.TrimSuffix(baseURL, "/"),
+		Client:         http.DefaultClient,
+	}, nil
+}
+
+// ParseRSAPrivateKey
+// Github gives PKCS#1 keys but PKCS#8 ones are accepted too
+func ParseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found in the private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParsePKCS8PrivateKey failed: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is a %T, not an RSA key", key)
+	}
+
+	return rsaKey, nil
+}
+
+// JWT
+// Build the RS256 JWT identifying the App
+// github refuses JWTs valid for more than 10 minutes
+func (source *AppTokenSource) JWT(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal failed: %w", err)
+	}
+
+	claims, err := json.Marshal(map[string]any{
+		// a bit in the past in case our clock drifts from github's
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": fmt.Sprint(source.AppID),
+	})
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal failed: %w", err)
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, source.PrivateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("rsa.SignPKCS1v15 failed: %w", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Token
+// Returns a valid installation access token
+// a new one is requested only when the cached one is about to expire
+// the callers needing a new one at the same time share a single exchange, made without the lock held,
+// a caller whose context is done stops waiting for it without cancelling it for the others
+func (source *AppTokenSource) Token(ctx context.Context) (string, error) {
+	if token, ok := source.cachedToken(time.Now()); ok {
+		return token, nil
+	}
+
+	result := source.exchanges.DoChan("token", func() (any, error) {
+		// an exchange which ended right before may have cached a new token already
+		if token, ok := source.cachedToken(time.Now()); ok {
+			return token, nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), appTokenExchangeTimeout)
+		defer cancel()
+
+		token, expiresAt, err := source.fetchInstallationToken(ctx, time.Now())
+		if err != nil {
+			return "", err
+		}
+
+		source.mu.Lock()
+		source.token = token
+		source.expiresAt = expiresAt
+		source.mu.Unlock()
+
+		return token, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	}
+}
+
+// cachedToken
+// The cached installation token if it's not about to expire
+func (source *AppTokenSource) cachedToken(now time.Time) (string, bool) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	if source.token != "" && now.Add(appTokenRefreshMargin).Before(source.expiresAt) {
+		return source.token, true
+	}
+	return "", false
+}
+
+// ExpiresAt
+// Expiration of the cached installation token, zero if none was fetched yet
+func (source *AppTokenSource) ExpiresAt() time.Time {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	return source.expiresAt
+}
+
+func (source *AppTokenSource) fetchInstallationToken(ctx context.Context, now time.Time) (string, time.Time, error) {
+	jwt, err := source.JWT(now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", source.BaseURL, source.InstallationID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("http.NewRequest failed: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	res, err := source.Client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("request installation token failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(res.Body)
+		return "", time.Time{}, fmt.Errorf("request installation token failed: %s: %s", res.Status, string(body))
+	}
+
+	var installationToken InstallationToken
+	if err := json.NewDecoder(res.Body).Decode(&installationToken); err != nil {
+		return "", time.Time{}, fmt.Errorf("json.NewDecoder failed: %w", err)
+	}
+
+	if installationToken.Token == "" {
+		return "", time.Time{}, errors.New("github returned an empty installation token")
+	}
+
+	return installationToken.Token, installationToken.ExpiresAt, nil
+}
@@ -0,0 +1,206 @@
+package github_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+func newAppServer(t *testing.T, options githubfake.Options, ttl time.Duration) (*githubfake.Server, *github.AppTokenSource) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	options.Count = 0
+	server := githubfake.NewServer(options)
+	t.Cleanup(server.Close)
+	server.SetApp(githubfake.AppOptions{AppID: 42, InstallationID: 7, PublicKey: &key.PublicKey, TokenTTL: ttl})
+
+	source, err := github.NewAppTokenSource(42, 7, privateKey, server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server, source
+}
+
+func TestAppTokenSourceJWT(t *testing.T) {
+	_, source := newAppServer(t, githubfake.DefaultOptions(), time.Hour)
+
+	now := time.Now()
+	jwt, err := source.JWT(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 parts, got %d", len(parts))
+	}
+
+	var header map[string]string
+	var claims githubfake.AppClaims
+	for i, value := range []any{&header, &claims} {
+		decoded, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(decoded, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if header["alg"] != "RS256" || header["typ"] != "JWT" {
+		t.Errorf("unexpected header %v", header)
+	}
+	if claims.Issuer != "42" {
+		t.Errorf("iss is %q, expected the App id", claims.Issuer)
+	}
+	if claims.IssuedAt >= now.Unix() {
+		t.Errorf("iat %d is not in the past", claims.IssuedAt)
+	}
+	if claims.ExpiresAt <= now.Unix() || claims.ExpiresAt-claims.IssuedAt > 600 {
+		t.Errorf("exp %d must be in the future and at most 10 minutes after iat %d", claims.ExpiresAt, claims.IssuedAt)
+	}
+}
+
+func TestAppTokenSourceCachesToken(t *testing.T) {
+	server, source := newAppServer(t, githubfake.DefaultOptions(), time.Hour)
+
+	first, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Errorf("expected the cached token %q, got %q", first, second)
+	}
+	if issued := len(server.InstallationTokens()); issued != 1 {
+		t.Errorf("expected 1 token exchange, got %d", issued)
+	}
+	if until := time.Until(source.ExpiresAt()); until < 55*time.Minute {
+		t.Errorf("token expires in %s, expected about an hour", until)
+	}
+	if claims := server.InstallationTokens()[0]; claims.Issuer != "42" {
+		t.Errorf("token issued to %q", claims.Issuer)
+	}
+}
+
+func TestAppTokenSourceRefreshesBeforeExpiry(t *testing.T) {
+	// valid for less than the refresh margin, the token must be refreshed each time
+	server, source := newAppServer(t, githubfake.DefaultOptions(), 4*time.Minute)
+
+	first, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == second {
+		t.Errorf("expected a new token, got %q again", first)
+	}
+	if issued := len(server.InstallationTokens()); issued != 2 {
+		t.Errorf("expected 2 token exchanges, got %d", issued)
+	}
+}
+
+func TestAppTokenSourceSharesExchange(t *testing.T) {
+	options := githubfake.DefaultOptions()
+	options.Latency = 100 * time.Millisecond
+	server, source := newAppServer(t, options, time.Hour)
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	errs := make([]error, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = source.Token(context.Background())
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range tokens {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if tokens[i] != tokens[0] {
+			t.Errorf("caller %d got %q, expected %q", i, tokens[i], tokens[0])
+		}
+	}
+	if issued := len(server.InstallationTokens()); issued != 1 {
+		t.Errorf("expected 1 token exchange, got %d", issued)
+	}
+}
+
+func TestAppTokenSourceCancelledCaller(t *testing.T) {
+	options := githubfake.DefaultOptions()
+	options.Latency = 500 * time.Millisecond
+	_, source := newAppServer(t, options, time.Hour)
+
+	// a slow exchange in flight
+	go func() { _, _ = source.Token(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := source.Token(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline of the caller, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("the cancelled caller waited %s for the exchange", elapsed)
+	}
+
+	// the exchange wasn't cancelled with the caller
+	token, err := source.Token(context.Background())
+	if err != nil || token == "" {
+		t.Errorf("expected the token of the exchange, got %q, %v", token, err)
+	}
+}
+
+func TestAppTokenSourceWrongKey(t *testing.T) {
+	server, _ := newAppServer(t, githubfake.DefaultOptions(), time.Hour)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	source, err := github.NewAppTokenSource(42, 7, privateKey, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = source.Token(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected a 401, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// PageFetcher
+// Fetch the items of one page, the response gives the `Link` header to the next one
+type PageFetcher[T any] func(ctx context.Context, url string) ([]T, *http.Response, error)
+
+// Paginator
+// Iterate over the pages of a github list following the `next` links
+//
+//	pages := github.NewPaginator(url, 10, fetch)
+//	for pages.Next(ctx) {
+//		items = append(items, pages.Page()...)
+//	}
+//	if err := pages.Err(); err != nil {
+//
+// it stops after MaxPages pages, when the context is cancelled or when a page fails
+type Paginator[T any] struct {
+	fetch    PageFetcher[T]
+	maxPages int
+
+	next      string
+	seen      map[string]bool
+	pages     int
+	page      []T
+	err       error
+	truncated bool
+}
+
+// NewPaginator
+// Start from url, maxPages <= 0 for no limit
+func NewPaginator[T any](url string, maxPages int, fetch PageFetcher[T]) *Paginator[T] {
+	return &Paginator[T]{
+		fetch:    fetch,
+		maxPages: maxPages,
+		next:     url,
+		seen:     map[string]bool{},
+	}
+}
+
+// Next
+// Fetch the next page, false once there is none or it failed, see Err
+func (paginator *Paginator[T]) Next(ctx context.Context) bool {
+	paginator.page = nil
+	if paginator.err != nil || paginator.next == "" {
+		return false
+	}
+
+	if paginator.maxPages > 0 && paginator.pages >= paginator.maxPages {
+		paginator.truncated = true
+		return false
+	}
+
+	if err := ctx.Err(); err != nil {
+		paginator.err = err
+		return false
+	}
+
+	// a next link pointing to a page already fetched would never end
+	if paginator.seen[paginator.next] {
+		paginator.err = fmt.Errorf("pagination loops back to %s", paginator.next)
+		return false
+	}
+	paginator.seen[paginator.next] = true
+
+	page, res, err := paginator.fetch(ctx, paginator.next)
+	if err != nil {
+		paginator.err = err
+		return false
+	}
+
+	paginator.pages++
+	paginator.page = page
+	paginator.next = FetchResponseLinks(res)["next"]
+
+	return true
+}
+
+// Page
+// The items of the page fetched by the last call to Next
+func (paginator *Paginator[T]) Page() []T {
+	return paginator.page
+}
+
+// Err
+// Why the pagination stopped before the last page, nil if it didn't
+func (paginator *Paginator[T]) Err() error {
+	return paginator.err
+}
+
+// Pages
+// Number of pages fetched so far
+func (paginator *Paginator[T]) Pages() int {
+	return paginator.pages
+}
+
+// Truncated
+// true if the pagination stopped at MaxPages while there were more pages
+func (paginator *Paginator[T]) Truncated() bool {
+	return paginator.truncated
+}
+
+// CollectPages
+// Every item of every page, up to maxPages
+func CollectPages[T any](ctx context.Context, paginator *Paginator[T]) ([]T, error) {
+	var items []T
+	for paginator.Next(ctx) {
+		items = append(items, paginator.Page()...)
+	}
+	return items, paginator.Err()
+}
@@ -0,0 +1,123 @@
+package github_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+// fetchRepositories
+// A page of repositories of the fake, the number of pages fetched is counted in calls
+func fetchRepositories(calls *int) github.PageFetcher[github.Repository] {
+	return func(ctx context.Context, url string) ([]github.Repository, *http.Response, error) {
+		*calls++
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, res, fmt.Errorf("%s answered %d", url, res.StatusCode)
+		}
+
+		var repositories []github.Repository
+		err = json.NewDecoder(res.Body).Decode(&repositories)
+		if err != nil {
+			return nil, res, err
+		}
+		return repositories, res, nil
+	}
+}
+
+// mostRepositories
+// The owner with the most repositories in the fake
+func mostRepositories(server *githubfake.Server) string {
+	counts := map[string]int{}
+	most := ""
+	for _, repository := range server.Repositories() {
+		counts[repository.Owner]++
+		if counts[repository.Owner] > counts[most] {
+			most = repository.Owner
+		}
+	}
+	return most
+}
+
+func TestPaginatorFollowsNextLinks(t *testing.T) {
+	server := githubfake.NewServer(githubfake.Options{Seed: 1, Count: 5000})
+	t.Cleanup(server.Close)
+
+	owner := mostRepositories(server)
+	owned := server.Owned(owner)
+	if len(owned) < 5 {
+		t.Fatalf("expected an owner with at least 5 repositories, %s has %d", owner, len(owned))
+	}
+
+	calls := 0
+	pages := github.NewPaginator(server.URL+"/users/"+owner+"/repos?per_page=2", 0, fetchRepositories(&calls))
+	repositories, err := github.CollectPages(context.Background(), pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedPages := (len(owned) + 1) / 2
+	if pages.Pages() != expectedPages || calls != expectedPages || pages.Truncated() {
+		t.Errorf("expected %d pages, got %d pages and %d calls, truncated: %v", expectedPages, pages.Pages(), calls, pages.Truncated())
+	}
+	if len(repositories) != len(owned) {
+		t.Fatalf("expected %d repositories, got %d", len(owned), len(repositories))
+	}
+	for i, repository := range repositories {
+		if repository.FullName != owned[i].FullName() {
+			t.Errorf("repository %d is %s, expected %s", i, repository.FullName, owned[i].FullName())
+		}
+	}
+}
+
+func TestPaginatorMaxPages(t *testing.T) {
+	server := githubfake.NewServer(githubfake.Options{Seed: 1, Count: 5000})
+	t.Cleanup(server.Close)
+
+	owner := mostRepositories(server)
+
+	calls := 0
+	pages := github.NewPaginator(server.URL+"/users/"+owner+"/repos?per_page=1", 3, fetchRepositories(&calls))
+	repositories, err := github.CollectPages(context.Background(), pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repositories) != 3 || calls != 3 || !pages.Truncated() {
+		t.Errorf("expected 3 repositories in 3 calls and the pagination truncated, got %d in %d calls, truncated: %v", len(repositories), calls, pages.Truncated())
+	}
+}
+
+func TestPaginatorDetectsLoops(t *testing.T) {
+	server := githubfake.NewServer(githubfake.Options{Seed: 1, Count: 10, FirstID: 1})
+	t.Cleanup(server.Close)
+
+	// the fake gives a next link even on the last page of /repositories: to the page it was asked
+	latest := server.Latest(2)
+	start := fmt.Sprintf("%s/repositories?since=%d", server.URL, latest[1].ID)
+
+	calls := 0
+	pages := github.NewPaginator(start, 0, fetchRepositories(&calls))
+	repositories, err := github.CollectPages(context.Background(), pages)
+	if err == nil || !strings.Contains(err.Error(), "loops back") {
+		t.Fatalf("expected the loop to be detected, got %v", err)
+	}
+	if calls != 2 || len(repositories) != 1 || int(repositories[0].Id) != latest[0].ID {
+		t.Errorf("expected the last repository in 2 calls before the loop, got %v in %d calls", repositories, calls)
+	}
+}
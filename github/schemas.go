@@ -10,7 +10,7 @@ type Event struct {
 		Login        string `json:"login"`
 		DisplayLogin string `json:"display_login"`
 		Url          string `json:"url"`
-	}
+	} `json:"actor"`
 	Repo struct {
 		Id   uint   `json:"id"`
 		Name string `json:"name"`
@@ -21,6 +21,8 @@ type Event struct {
 		RefType     string `json:"ref_type"`
 		Description string `json:"description"`
 	} `json:"payload"`
+	Public    bool      `json:"public"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type Repository struct {
@@ -30,6 +32,7 @@ type Repository struct {
 	Owner    struct {
 		Id              uint   `json:"id"`
 		Login           string `json:"login"`
+		Type            string `json:"type"`
 		Url             string `json:"url"`
 		FollowersUrl    string `json:"followers_url"`
 		FollowingUrl    string `json:"following_url"`
@@ -77,3 +80,80 @@ type Repository struct {
 	SubscribersCount uint `json:"subscribers_count"`
 	StargazersCount  int  `json:"stargazers_count"`
 }
+
+type Contributor struct {
+	Id            uint   `json:"id"`
+	Login         string `json:"login"`
+	Type          string `json:"type"`
+	Url           string `json:"url"`
+	HtmlUrl       string `json:"html_url"`
+	Contributions int    `json:"contributions"`
+}
+
+type InstallationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GraphQLResponse
+// Envelope of the responses of the graphql api
+// github returns partial data along with errors, a repository not found is null in Data
+type GraphQLResponse[T any] struct {
+	Data   T              `json:"data"`
+	Errors []GraphQLError `json:"errors"`
+}
+
+type GraphQLError struct {
+	Type    string `json:"type"`
+	Path    []any  `json:"path"`
+	Message string `json:"message"`
+}
+
+// GraphQLRepository
+// What the stats need of a repository, fetched with the graphql api
+type GraphQLRepository struct {
+	StargazerCount int       `json:"stargazerCount"`
+	CreatedAt      time.Time `json:"createdAt"`
+	LicenseInfo    *struct {
+		Key string `json:"key"`
+	} `json:"licenseInfo"`
+	Languages struct {
+		Edges []struct {
+			Size int `json:"size"`
+			Node struct {
+				Name string `json:"name"`
+			} `json:"node"`
+		} `json:"edges"`
+	} `json:"languages"`
+	RepositoryTopics struct {
+		Nodes []struct {
+			Topic struct {
+				Name string `json:"name"`
+			} `json:"topic"`
+		} `json:"nodes"`
+	} `json:"repositoryTopics"`
+}
+
+// WebhookEvent
+// Payload of the repository, star and public webhook events
+// the repository is the same record as the REST api returns
+type WebhookEvent struct {
+	Action     string     `json:"action"`
+	Repository Repository `json:"repository"`
+	Sender     struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+}
+
+// PushWebhookEvent
+// Payload of the push webhook event
+// its repository has timestamps as unix seconds, not dates, so it's decoded apart
+type PushWebhookEvent struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		FullName        string `json:"full_name"`
+		Size            uint   `json:"size"`
+		StargazersCount int    `json:"stargazers_count"`
+		PushedAt        int64  `json:"pushed_at"`
+	} `json:"repository"`
+}
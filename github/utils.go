@@ -1,28 +1,198 @@
 package github
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 )
 
-// Github Pagination
-func fetchResponseLinks(res *http.Response) map[string]string {
-	header := res.Header.Get("link")
-	if header == "" {
-		return map[string]string{}
+// Link
+// One link of a `Link` header (RFC 8288): its target and its parameters
+// the parameter names are lowercased, a parameter without a value has an empty one
+type Link struct {
+	URL    string
+	Params map[string]string
+}
+
+// Rels
+// The relations of the link, `rel` holds several of them separated by spaces
+// relation types are case insensitive, they are lowercased
+func (link Link) Rels() []string {
+	return strings.Fields(strings.ToLower(link.Params["rel"]))
+}
+
+// ParseLinkHeader
+// Parse the value of a `Link` header following RFC 8288:
+// `<uri>; param=value; param="quoted, value"; param, <uri2>; rel="next last"`
+// commas inside the uri or a quoted value don't separate links
+// the first malformed link stops the parsing, the links before it are returned with the error
+func ParseLinkHeader(header string) ([]Link, error) {
+	var links []Link
+
+	parser := linkParser{input: header}
+	for {
+		parser.skipSpaces()
+		if parser.done() {
+			return links, nil
+		}
+		// empty elements are allowed by the list syntax: `<a>, , <b>`
+		if parser.peek() == ',' {
+			parser.pos++
+			continue
+		}
+
+		link, err := parser.link()
+		if err != nil {
+			return links, err
+		}
+		links = append(links, link)
+
+		parser.skipSpaces()
+		if parser.done() {
+			return links, nil
+		}
+		if parser.peek() != ',' {
+			return links, fmt.Errorf("unexpected `%c` after link at %d", parser.peek(), parser.pos)
+		}
+		parser.pos++
 	}
+}
 
-	links := map[string]string{}
+type linkParser struct {
+	input string
+	pos   int
+}
 
-	for _, link := range strings.Split(header, ",") {
-		link = strings.TrimSpace(link)
+func (parser *linkParser) done() bool {
+	return parser.pos >= len(parser.input)
+}
+
+func (parser *linkParser) peek() byte {
+	return parser.input[parser.pos]
+}
+
+func (parser *linkParser) skipSpaces() {
+	for !parser.done() && (parser.peek() == ' ' || parser.peek() == '\t') {
+		parser.pos++
+	}
+}
 
-		parts := strings.Split(link, ";")
+// link
+// `<uri>` followed by any number of `; name[=value]`
+func (parser *linkParser) link() (Link, error) {
+	if parser.peek() != '<' {
+		return Link{}, fmt.Errorf("expected `<` at %d", parser.pos)
+	}
+
+	end := strings.IndexByte(parser.input[parser.pos:], '>')
+	if end == -1 {
+		return Link{}, fmt.Errorf("unterminated uri at %d", parser.pos)
+	}
+
+	link := Link{
+		URL:    strings.TrimSpace(parser.input[parser.pos+1 : parser.pos+end]),
+		Params: map[string]string{},
+	}
+	parser.pos += end + 1
 
-		key := parts[1][6 : len(parts[1])-1]
-		val := parts[0][1 : len(parts[0])-1]
+	for {
+		parser.skipSpaces()
+		if parser.done() || parser.peek() != ';' {
+			return link, nil
+		}
+		parser.pos++
+		parser.skipSpaces()
+
+		name := parser.token()
+		if name == "" {
+			// a trailing `;` with no parameter
+			if parser.done() || parser.peek() == ',' || parser.peek() == ';' {
+				continue
+			}
+			return Link{}, fmt.Errorf("expected a parameter name at %d", parser.pos)
+		}
+		name = strings.ToLower(name)
+
+		parser.skipSpaces()
+		value := ""
+		if !parser.done() && parser.peek() == '=' {
+			parser.pos++
+			parser.skipSpaces()
+
+			var err error
+			value, err = parser.value()
+			if err != nil {
+				return Link{}, err
+			}
+		}
+
+		// only the first occurrence of a parameter counts, like the spec says for rel
+		if _, ok := link.Params[name]; !ok {
+			link.Params[name] = value
+		}
+	}
+}
+
+// token
+// A parameter name or an unquoted value, up to a separator
+func (parser *linkParser) token() string {
+	start := parser.pos
+	for !parser.done() && !strings.ContainsRune(" \t;,=\"<>", rune(parser.peek())) {
+		parser.pos++
+	}
+	return parser.input[start:parser.pos]
+}
+
+// value
+// A token or a quoted string where `\` escapes the next character
+func (parser *linkParser) value() (string, error) {
+	if parser.done() || parser.peek() != '"' {
+		return parser.token(), nil
+	}
+
+	start := parser.pos
+	parser.pos++
+
+	var value strings.Builder
+	for !parser.done() {
+		c := parser.peek()
+		parser.pos++
+
+		switch c {
+		case '\\':
+			if parser.done() {
+				return "", fmt.Errorf("unterminated quoted string at %d", start)
+			}
+			value.WriteByte(parser.peek())
+			parser.pos++
+		case '"':
+			return value.String(), nil
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	return "", fmt.Errorf("unterminated quoted string at %d", start)
+}
+
+// FetchResponseLinks
+// Github Pagination
+// Returns the links of the `link` header by relation: next, last, first, prev
+// a link with several relations is returned for each of them, the first link of a relation wins
+// the links after a malformed one are ignored
+func FetchResponseLinks(res *http.Response) map[string]string {
+	links := map[string]string{}
+	if res == nil {
+		return links
+	}
 
-		links[key] = val
+	parsed, _ := ParseLinkHeader(strings.Join(res.Header.Values("Link"), ", "))
+	for _, link := range parsed {
+		for _, rel := range link.Rels() {
+			if _, ok := links[rel]; !ok {
+				links[rel] = link.URL
+			}
+		}
 	}
 
 	return links
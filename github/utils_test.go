@@ -0,0 +1,201 @@
+package github_test
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+)
+
+var linkHeaderCases = []struct {
+	name   string
+	header string
+	links  []github.Link
+	err    bool
+}{
+	{
+		name: "empty",
+	},
+	{
+		name:   "github pages",
+		header: `<https://api.github.com/user/1/repos?page=2>; rel="next", <https://api.github.com/user/1/repos?page=5>; rel="last"`,
+		links: []github.Link{
+			{URL: "https://api.github.com/user/1/repos?page=2", Params: map[string]string{"rel": "next"}},
+			{URL: "https://api.github.com/user/1/repos?page=5", Params: map[string]string{"rel": "last"}},
+		},
+	},
+	{
+		name:   "rfc 8288 title",
+		header: `<http://example.com/TheBook/chapter2>; rel="previous"; title="previous chapter"`,
+		links: []github.Link{
+			{URL: "http://example.com/TheBook/chapter2", Params: map[string]string{"rel": "previous", "title": "previous chapter"}},
+		},
+	},
+	{
+		name:   "rfc 8288 extended titles",
+		header: `</TheBook/chapter2>; rel="previous"; title*=UTF-8'de'letztes%20Kapitel, </TheBook/chapter4>; rel="next"; title*=UTF-8'de'n%c3%a4chstes%20Kapitel`,
+		links: []github.Link{
+			{URL: "/TheBook/chapter2", Params: map[string]string{"rel": "previous", "title*": "UTF-8'de'letztes%20Kapitel"}},
+			{URL: "/TheBook/chapter4", Params: map[string]string{"rel": "next", "title*": "UTF-8'de'n%c3%a4chstes%20Kapitel"}},
+		},
+	},
+	{
+		name:   "rfc 8288 several rels",
+		header: `<http://example.org/>; rel="start http://example.net/relation/other"`,
+		links: []github.Link{
+			{URL: "http://example.org/", Params: map[string]string{"rel": "start http://example.net/relation/other"}},
+		},
+	},
+	{
+		name:   "comma in a quoted value",
+		header: `<https://a.test/1>; rel="next"; title="one, two", <https://a.test/2>; rel="last"`,
+		links: []github.Link{
+			{URL: "https://a.test/1", Params: map[string]string{"rel": "next", "title": "one, two"}},
+			{URL: "https://a.test/2", Params: map[string]string{"rel": "last"}},
+		},
+	},
+	{
+		name:   "comma and semicolon in the uri",
+		header: `<https://a.test/?ids=1,2;3>; rel=next`,
+		links: []github.Link{
+			{URL: "https://a.test/?ids=1,2;3", Params: map[string]string{"rel": "next"}},
+		},
+	},
+	{
+		name:   "escaped quote",
+		header: `<https://a.test/>; rel=next; title="a \"quoted\" \\ title"`,
+		links: []github.Link{
+			{URL: "https://a.test/", Params: map[string]string{"rel": "next", "title": `a "quoted" \ title`}},
+		},
+	},
+	{
+		name:   "parameters lowercased, without value, repeated and spaced",
+		header: `<https://a.test/> ;REL = next ; crossorigin; rel=last; Type="text/html" ; hreflang=en;`,
+		links: []github.Link{
+			{URL: "https://a.test/", Params: map[string]string{"rel": "next", "crossorigin": "", "type": "text/html", "hreflang": "en"}},
+		},
+	},
+	{
+		name:   "empty elements",
+		header: `, <https://a.test/1>; rel=next, ,, <https://a.test/2>; rel=last ,`,
+		links: []github.Link{
+			{URL: "https://a.test/1", Params: map[string]string{"rel": "next"}},
+			{URL: "https://a.test/2", Params: map[string]string{"rel": "last"}},
+		},
+	},
+	{
+		name:   "no uri",
+		header: `https://a.test/; rel=next`,
+		err:    true,
+	},
+	{
+		name:   "unterminated uri",
+		header: `<https://a.test/; rel=next`,
+		err:    true,
+	},
+	{
+		name:   "unterminated quoted value",
+		header: `<https://a.test/>; title="open`,
+		err:    true,
+	},
+	{
+		name:   "links before a malformed one",
+		header: `<https://a.test/1>; rel=next <https://a.test/2>; rel=last`,
+		links: []github.Link{
+			{URL: "https://a.test/1", Params: map[string]string{"rel": "next"}},
+		},
+		err: true,
+	},
+}
+
+func TestParseLinkHeader(t *testing.T) {
+	for _, c := range linkHeaderCases {
+		t.Run(c.name, func(t *testing.T) {
+			links, err := github.ParseLinkHeader(c.header)
+			if (err != nil) != c.err {
+				t.Fatalf("expected an error: %v, got %v", c.err, err)
+			}
+			if len(links) != len(c.links) || (len(links) > 0 && !reflect.DeepEqual(links, c.links)) {
+				t.Errorf("got %+v, expected %+v", links, c.links)
+			}
+		})
+	}
+}
+
+func TestLinkRels(t *testing.T) {
+	link := github.Link{Params: map[string]string{"rel": " Next  LAST\tprev "}}
+	if rels := link.Rels(); !reflect.DeepEqual(rels, []string{"next", "last", "prev"}) {
+		t.Errorf("got %v", rels)
+	}
+}
+
+func TestFetchResponseLinks(t *testing.T) {
+	res := &http.Response{Header: http.Header{}}
+	res.Header.Add("Link", `<https://a.test/2>; rel="next last", <https://a.test/3>; rel=last`)
+	res.Header.Add("Link", `<https://a.test/1>; rel=first; title="first, page"`)
+
+	links := github.FetchResponseLinks(res)
+	expected := map[string]string{"next": "https://a.test/2", "last": "https://a.test/2", "first": "https://a.test/1"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("got %v, expected %v", links, expected)
+	}
+
+	if links := github.FetchResponseLinks(nil); len(links) != 0 {
+		t.Errorf("expected no link without response, got %v", links)
+	}
+}
+
+// formatLinkHeader
+// The links as a header, every value quoted
+func formatLinkHeader(links []github.Link) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	elements := make([]string, 0, len(links))
+	for _, link := range links {
+		element := "<" + link.URL + ">"
+		for name, value := range link.Params {
+			element += "; " + name + `="` + escape.Replace(value) + `"`
+		}
+		elements = append(elements, element)
+	}
+	return strings.Join(elements, ", ")
+}
+
+func FuzzParseLinkHeader(f *testing.F) {
+	for _, c := range linkHeaderCases {
+		f.Add(c.header)
+	}
+
+	f.Fuzz(func(t *testing.T, header string) {
+		links, err := github.ParseLinkHeader(header)
+
+		if len(links) > strings.Count(header, "<") {
+			t.Fatalf("%d links parsed from %d `<`", len(links), strings.Count(header, "<"))
+		}
+		for _, link := range links {
+			if strings.Contains(link.URL, ">") {
+				t.Fatalf("uri %q holds the `>` ending it", link.URL)
+			}
+			for name := range link.Params {
+				if name == "" || name != strings.ToLower(name) {
+					t.Fatalf("parameter name %q is empty or not lowercased", name)
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+
+		// what was parsed is parsed the same once formatted again
+		formatted := formatLinkHeader(links)
+		again, err := github.ParseLinkHeader(formatted)
+		if err != nil {
+			t.Fatalf("%q parsed from %q fails to parse: %v", formatted, header, err)
+		}
+		if len(again) != len(links) || (len(links) > 0 && !reflect.DeepEqual(again, links)) {
+			t.Fatalf("%q parsed from %q gives %+v, expected %+v", formatted, header, again, links)
+		}
+	})
+}
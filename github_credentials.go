@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+)
+
+// default budget of a token we haven't used yet
+// it's the rate limit github gives to personal access tokens
+const githubDefaultRateLimit = 5000
+
+// GithubToken
+// A server side token with the budget github reported for it
+// either a static token or the installation token of a github App
+type GithubToken struct {
+	value     string
+	app       *github.AppTokenSource
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// budget left for this token, a token whose window has been reset has its full budget back
+func (token *GithubToken) budget(now time.Time) int {
+	if token.reset.IsZero() || now.After(token.reset) {
+		return token.limit
+	}
+	return token.remaining
+}
+
+// GithubTokenStatus
+// What /status shows of a token, the token itself is never shown
+type GithubTokenStatus struct {
+	Token     string    `json:"token"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// GithubCredentials
+// Pool of the server side tokens used for the outbound calls to github
+// Calls are spread across the tokens based on the budget each one has left
+// The caller's own token is used instead only if the deployment allows it
+type GithubCredentials struct {
+	mu          sync.Mutex
+	tokens      []*GithubToken
+	next        int
+	allowCaller bool
+}
+
+func newGithubCredentials(cfg *Config) (*GithubCredentials, error) {
+	credentials := &GithubCredentials{allowCaller: cfg.GithubAllowCallerToken}
+
+	if cfg.GithubAppID != 0 {
+		app, err := newGithubAppTokenSource(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("github App configuration: %w", err)
+		}
+
+		credentials.tokens = append(credentials.tokens, &GithubToken{
+			app:   app,
+			limit: githubDefaultRateLimit,
+		})
+	}
+
+	for _, token := range cfg.GithubTokens {
+		if token == "" {
+			continue
+		}
+		credentials.tokens = append(credentials.tokens, &GithubToken{
+			value: token,
+			limit: githubDefaultRateLimit,
+		})
+	}
+
+	return credentials, nil
+}
+
+func newGithubAppTokenSource(cfg *Config) (*github.AppTokenSource, error) {
+	if cfg.GithubAppInstallationID == 0 {
+		return nil, fmt.Errorf("GITHUB_APP_INSTALLATION_ID is required")
+	}
+
+	privateKey := []byte(cfg.GithubAppPrivateKey)
+	if cfg.GithubAppPrivateKeyPath != "" {
+		var err error
+		privateKey, err = os.ReadFile(cfg.GithubAppPrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("read private key failed: %w", err)
+		}
+	}
+
+	source, err := github.NewAppTokenSource(cfg.GithubAppID, cfg.GithubAppInstallationID, privateKey, githubAPIURL)
+	if err != nil {
+		return nil, err
+	}
+	// with the CA bundle and the proxy, but not through the cassettes, the tokens must not be recorded
+	source.Client = &http.Client{Transport: githubBaseTransport, CheckRedirect: checkGithubRedirect}
+
+	return source, nil
+}
+
+// credentials used by HttpRequest.Do, set in main
+// without any configuration the calls are made unauthenticated
+var githubCredentials = &GithubCredentials{}
+
+// pick
+// Returns the token with the biggest budget left
+// tokens with the same budget are used one after the other
+func (credentials *GithubCredentials) pick() *GithubToken {
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	if len(credentials.tokens) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+
+	var best *GithubToken
+	bestIndex := 0
+	for i := range credentials.tokens {
+		index := (credentials.next + i) % len(credentials.tokens)
+		token := credentials.tokens[index]
+
+		if best == nil || token.budget(now) > best.budget(now) {
+			best = token
+			bestIndex = index
+		}
+	}
+
+	credentials.next = (bestIndex + 1) % len(credentials.tokens)
+
+	// book the call so concurrent requests don't all pick the same token
+	if best.budget(now) > 0 && !best.reset.IsZero() && now.Before(best.reset) {
+		best.remaining--
+	}
+
+	return best
+}
+
+// Authorize
+// Set the Authorization header of a request to github
+// Returns the server side token used, nil if none was
+func (credentials *GithubCredentials) Authorize(ctx context.Context, req *http.Request) (*GithubToken, error) {
+	if credentials.allowCaller {
+		if auth, ok := ctx.Value(Authorization{}).(Authorization); ok && auth.Token != "" {
+			req.Header.Set("Authorization", auth.Token)
+			return nil, nil
+		}
+	}
+
+	token := credentials.pick()
+	if token == nil {
+		return nil, nil
+	}
+
+	value := token.value
+	if token.app != nil {
+		var err error
+		value, err = token.app.Token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("github App installation token: %w", err)
+		}
+	}
+
+	req.Header.Set("Authorization", "Bearer "+value)
+
+	return token, nil
+}
+
+// Identity
+// Who a request made with this context is authenticated as
+// empty for the server side tokens, a hash of the caller's token otherwise
+func (credentials *GithubCredentials) Identity(ctx context.Context) string {
+	if !credentials.allowCaller {
+		return ""
+	}
+
+	auth, ok := ctx.Value(Authorization{}).(Authorization)
+	if !ok || auth.Token == "" {
+		return ""
+	}
+
+	hash := sha256.Sum256([]byte(auth.Token))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// Record
+// Update the budget of the token with the rate limit headers of the response
+func (credentials *GithubCredentials) Record(token *GithubToken, res *http.Response) {
+	if token == nil || res == nil {
+		return
+	}
+
+	remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	token.remaining = remaining
+	if limit, err := strconv.Atoi(res.Header.Get("X-RateLimit-Limit")); err == nil {
+		token.limit = limit
+	}
+	if reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		token.reset = time.Unix(reset, 0)
+	}
+}
+
+// Budget
+// Total of the calls left across the pool, false if the pool is empty
+func (credentials *GithubCredentials) Budget() (int, bool) {
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	if len(credentials.tokens) == 0 {
+		return 0, false
+	}
+
+	now := time.Now()
+
+	total := 0
+	for _, token := range credentials.tokens {
+		total += token.budget(now)
+	}
+
+	return total, true
+}
+
+func (credentials *GithubCredentials) Status() []GithubTokenStatus {
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	status := make([]GithubTokenStatus, 0, len(credentials.tokens))
+	for _, token := range credentials.tokens {
+		name := redactToken(token.value)
+		if token.app != nil {
+			name = fmt.Sprintf("app %d installation %d", token.app.AppID, token.app.InstallationID)
+		}
+
+		status = append(status, GithubTokenStatus{
+			Token:     name,
+			Limit:     token.limit,
+			Remaining: token.remaining,
+			Reset:     token.reset,
+		})
+	}
+
+	return status
+}
+
+// keep the last 4 characters so a token can be recognized
+func redactToken(token string) string {
+	if len(token) <= 8 {
+		return "****"
+	}
+	return "****" + token[len(token)-4:]
+}
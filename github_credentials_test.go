@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+// fakeRateLimitResponse
+// The response of the last of calls made to the fake, with its rate limit headers
+func fakeRateLimitResponse(t *testing.T, server *githubfake.Server, calls int) *http.Response {
+	t.Helper()
+
+	var res *http.Response
+	for i := 0; i < calls; i++ {
+		var err error
+		res, err = http.Get(server.URL + "/repositories?since=0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	return res
+}
+
+// newRateLimitedFake
+// A fake github allowing limit calls per window
+func newRateLimitedFake(t *testing.T, limit int, window time.Duration) *githubfake.Server {
+	t.Helper()
+
+	server := githubfake.NewServer(githubfake.Options{Count: 1, RateLimit: limit, RateLimitWindow: window})
+	t.Cleanup(server.Close)
+	return server
+}
+
+// pickTokens
+// The values of the next count tokens picked
+func pickTokens(credentials *GithubCredentials, count int) []string {
+	picked := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		picked = append(picked, credentials.pick().value)
+	}
+	return picked
+}
+
+func TestGithubCredentialsPickBiggestBudget(t *testing.T) {
+	server := newRateLimitedFake(t, 100, time.Hour)
+	credentials := useGithubCredentials(t, &Config{GithubTokens: []string{"a", "b"}})
+	a, b := credentials.tokens[0], credentials.tokens[1]
+
+	credentials.Record(a, fakeRateLimitResponse(t, server, 10))
+	credentials.Record(b, fakeRateLimitResponse(t, server, 30))
+	if a.remaining != 90 || b.remaining != 60 || a.limit != 100 {
+		t.Fatalf("expected the budgets of the headers, got a %d/%d and b %d/%d", a.remaining, a.limit, b.remaining, b.limit)
+	}
+	if budget, ok := credentials.Budget(); !ok || budget != 150 {
+		t.Errorf("expected a budget of 150, got %d", budget)
+	}
+
+	// a is picked, each pick booking a call, until both have the same budget
+	for i, value := range pickTokens(credentials, 30) {
+		if value != "a" {
+			t.Fatalf("pick %d: expected a with the biggest budget, got %s", i, value)
+		}
+	}
+	if budget, _ := credentials.Budget(); budget != 120 {
+		t.Errorf("expected 30 calls booked, budget %d", budget)
+	}
+
+	// then they are used one after the other
+	if picked := strings.Join(pickTokens(credentials, 4), ""); picked != "baba" {
+		t.Errorf("expected the tokens with the same budget to alternate, got %s", picked)
+	}
+}
+
+func TestGithubCredentialsRoundRobin(t *testing.T) {
+	credentials := useGithubCredentials(t, &Config{GithubTokens: []string{"a", "b", "c"}})
+
+	// tokens not used yet all have the default budget, which is not booked without a known reset
+	if picked := strings.Join(pickTokens(credentials, 7), ""); picked != "abcabca" {
+		t.Errorf("expected the tokens in turn, got %s", picked)
+	}
+	if budget, _ := credentials.Budget(); budget != 3*githubDefaultRateLimit {
+		t.Errorf("expected the default budget for each token, got %d", budget)
+	}
+}
+
+func TestGithubCredentialsResetRollover(t *testing.T) {
+	short := newRateLimitedFake(t, 100, time.Second)
+	long := newRateLimitedFake(t, 100, time.Hour)
+	credentials := useGithubCredentials(t, &Config{GithubTokens: []string{"a", "b"}})
+	a, b := credentials.tokens[0], credentials.tokens[1]
+
+	credentials.Record(a, fakeRateLimitResponse(t, short, 95))
+	credentials.Record(b, fakeRateLimitResponse(t, long, 50))
+	if picked := credentials.pick().value; picked != "b" {
+		t.Fatalf("expected b with 50 calls left rather than a with 5, got %s", picked)
+	}
+
+	// once its window is over a has its whole limit back
+	if budget := a.budget(a.reset.Add(time.Second)); budget != 100 {
+		t.Errorf("expected the limit back after the reset, got %d", budget)
+	}
+	for time.Now().Before(a.reset.Add(time.Second)) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if budget, _ := credentials.Budget(); budget != 100+49 {
+		t.Errorf("expected the limit of a and the 49 calls of b, got %d", budget)
+	}
+	if picked := credentials.pick().value; picked != "a" {
+		t.Errorf("expected a with its budget back, got %s", picked)
+	}
+}
+
+func TestGithubCredentialsRecord(t *testing.T) {
+	credentials := useGithubCredentials(t, &Config{GithubTokens: []string{"a"}})
+	token := credentials.tokens[0]
+
+	// nothing to record without the headers or a token
+	credentials.Record(token, &http.Response{Header: http.Header{}})
+	credentials.Record(nil, fakeRateLimitResponse(t, newRateLimitedFake(t, 100, time.Hour), 1))
+	credentials.Record(token, nil)
+	if token.limit != githubDefaultRateLimit || !token.reset.IsZero() {
+		t.Errorf("expected the token untouched, got %d until %s", token.limit, token.reset)
+	}
+}
+
+func TestGithubCredentialsAuthorize(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Count: 1, RateLimit: 100})
+	credentials := useGithubCredentials(t, &Config{GithubTokens: []string{"ghp_server"}, GithubAllowCallerToken: true})
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/repositories", nil)
+	token, err := credentials.Authorize(context.Background(), req)
+	if err != nil || token == nil || req.Header.Get("Authorization") != "Bearer ghp_server" {
+		t.Errorf("expected the server side token, got %q: %v", req.Header.Get("Authorization"), err)
+	}
+
+	ctx := context.WithValue(context.Background(), Authorization{}, Authorization{Token: "token ghp_caller"})
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/repositories", nil)
+	token, err = credentials.Authorize(ctx, req)
+	if err != nil || token != nil || req.Header.Get("Authorization") != "token ghp_caller" {
+		t.Errorf("expected the caller's token, got %q: %v", req.Header.Get("Authorization"), err)
+	}
+
+	// the calls made with the pool record the headers of the fake
+	var repositories []any
+	_, err = (&HttpRequest{Method: http.MethodGet, Url: server.URL + "/repositories"}).Do(testContext(), &repositories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := credentials.Status(); len(status) != 1 || status[0].Limit != 100 || status[0].Remaining != 99 {
+		t.Errorf("expected the budget of the fake recorded, got %+v", status)
+	}
+}
+
+func TestGithubCredentialsStatusRedactsTokens(t *testing.T) {
+	credentials := useGithubCredentials(t, &Config{GithubTokens: []string{"ghp_0123456789abcd", "short"}})
+
+	status := credentials.Status()
+	if len(status) != 2 || status[0].Token != "****abcd" || status[1].Token != "****" {
+		t.Errorf("expected the tokens redacted, got %+v", status)
+	}
+
+	content, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "ghp_0123456789") || strings.Contains(string(content), "short") {
+		t.Errorf("a token is shown: %s", content)
+	}
+}
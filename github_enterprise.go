@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// the endpoints of github.com, the defaults of the config
+const (
+	githubDotComAPIURL     = "https://api.github.com"
+	githubDotComUploadURL  = "https://uploads.github.com"
+	githubDotComGraphQLURL = "https://api.github.com/graphql"
+)
+
+// githubUploadURL
+// The github uploads api, set in main
+var githubUploadURL = githubDotComUploadURL
+
+// githubBaseTransport
+// Transport of every github call with the CA bundle, the proxy and the api version header of the config
+// githubClient adds the cassettes on top of it, the App token exchange uses it directly
+var githubBaseTransport http.RoundTripper = http.DefaultTransport
+
+// errForeignGithubURL
+// Returned for a url outside of the configured github endpoints
+var errForeignGithubURL = errors.New("url outside of the configured github")
+
+// applyGithubEnterpriseURL
+// With GITHUB_ENTERPRISE_URL, the endpoints left to their github.com default
+// are the ones of a GitHub Enterprise Server: /api/v3, /api/uploads and /api/graphql
+func (cfg *Config) applyGithubEnterpriseURL() {
+	if cfg.GithubEnterpriseURL == "" {
+		return
+	}
+	base := strings.TrimSuffix(cfg.GithubEnterpriseURL, "/")
+
+	if cfg.GithubAPIURL == githubDotComAPIURL {
+		cfg.GithubAPIURL = base + "/api/v3"
+	}
+	if cfg.GithubUploadURL == githubDotComUploadURL {
+		cfg.GithubUploadURL = base + "/api/uploads"
+	}
+	if cfg.GithubGraphQLURL == githubDotComGraphQLURL {
+		cfg.GithubGraphQLURL = base + "/api/graphql"
+	}
+}
+
+// newGithubTransport
+// The default transport trusting the CA bundle and going through the proxy of the config
+// without GITHUB_PROXY_URL the HTTPS_PROXY and NO_PROXY variables are used
+func newGithubTransport(cfg *Config) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.GithubCABundle != "" {
+		pool, err := loadCABundle(cfg.GithubCABundle)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	if cfg.GithubProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.GithubProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if cfg.GithubAPIVersion == "" {
+		return transport, nil
+	}
+	return githubVersionTransport{version: cfg.GithubAPIVersion, next: transport}, nil
+}
+
+// loadCABundle
+// The system certificates and the ones of the PEM file, for a GitHub Enterprise Server signed by an internal CA
+func loadCABundle(path string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle failed: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificate found in the CA bundle %s", path)
+	}
+
+	return pool, nil
+}
+
+// githubVersionTransport
+// Set the X-GitHub-Api-Version header of the requests which don't have one
+type githubVersionTransport struct {
+	version string
+	next    http.RoundTripper
+}
+
+func (transport githubVersionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("X-GitHub-Api-Version") != "" {
+		return transport.next.RoundTrip(req)
+	}
+
+	// a RoundTripper must not modify the request it's given
+	req = req.Clone(req.Context())
+	req.Header.Set("X-GitHub-Api-Version", transport.version)
+
+	return transport.next.RoundTrip(req)
+}
+
+// checkGithubURL
+// The calls only go to the configured github: the scheme and host of one of its endpoints, under its path
+// the urls followed from the responses (repository urls, pagination links, redirects) can't send the calls
+// and the tokens with them anywhere else
+func checkGithubURL(rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid github url `%s`: %w", rawURL, err)
+	}
+
+	for _, endpoint := range []string{githubAPIURL, githubUploadURL, githubGraphQLURL} {
+		base, err := url.Parse(endpoint)
+		if err != nil {
+			continue
+		}
+
+		if !strings.EqualFold(target.Scheme, base.Scheme) || urlHostPort(target) != urlHostPort(base) || target.User != nil {
+			continue
+		}
+
+		basePath := strings.TrimSuffix(base.Path, "/")
+		if target.Path == basePath || strings.HasPrefix(target.Path, basePath+"/") {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", errForeignGithubURL, rawURL)
+}
+
+// urlHostPort
+// The lowercased host of the url with its port, the default one of the scheme if none is given
+func urlHostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if strings.EqualFold(u.Scheme, "https") {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// checkGithubRedirect
+// CheckRedirect of githubClient, github redirects within itself, for a renamed repository for instance
+func checkGithubRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return checkGithubURL(req.URL.String())
+}
@@ -0,0 +1,125 @@
+package githubfake
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+)
+
+// AppOptions
+// The github App the fake issues installation tokens to
+type AppOptions struct {
+	AppID          int64
+	InstallationID int64
+	// the JWTs must be signed with its private key
+	PublicKey *rsa.PublicKey
+	// how long an installation token is valid, an hour by default like on github
+	TokenTTL time.Duration
+}
+
+// AppClaims
+// The claims of a JWT the fake accepted
+type AppClaims struct {
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Issuer    string `json:"iss"`
+}
+
+// SetApp
+// Issue installation tokens to this App, the fake has no App until it's called
+func (fake *Fake) SetApp(app AppOptions) {
+	if app.TokenTTL <= 0 {
+		app.TokenTTL = time.Hour
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.app = &app
+}
+
+// InstallationTokens
+// The installation tokens issued so far, the oldest first, with the claims of the JWT each was exchanged for
+func (fake *Fake) InstallationTokens() []AppClaims {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return append([]AppClaims(nil), fake.appClaims...)
+}
+
+// createInstallationToken
+// POST /app/installations/{id}/access_tokens: a token for a JWT of the App, like github checks it:
+// RS256 signed with the App key, issued by the App id, not expired and valid for at most 10 minutes
+func (fake *Fake) createInstallationToken(r *http.Request, installationID string) response {
+	fake.mu.Lock()
+	app := fake.app
+	fake.mu.Unlock()
+
+	if app == nil || installationID != strconv.FormatInt(app.InstallationID, 10) {
+		return messageResponse(http.StatusNotFound, "Not Found")
+	}
+
+	claims, err := verifyJWT(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), app, time.Now())
+	if err != nil {
+		return messageResponse(http.StatusUnauthorized, err.Error())
+	}
+
+	fake.mu.Lock()
+	fake.appClaims = append(fake.appClaims, claims)
+	token := fmt.Sprintf("ghs_fake%d", len(fake.appClaims))
+	fake.mu.Unlock()
+
+	return jsonResponse(http.StatusCreated, github.InstallationToken{
+		Token:     token,
+		ExpiresAt: time.Now().Add(app.TokenTTL).UTC().Truncate(time.Second),
+	})
+}
+
+func verifyJWT(jwt string, app *AppOptions, now time.Time) (AppClaims, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return AppClaims{}, fmt.Errorf("A JSON web token could not be decoded")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	var claims AppClaims
+	for i, value := range []any{&header, &claims} {
+		decoded, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil || json.Unmarshal(decoded, value) != nil {
+			return AppClaims{}, fmt.Errorf("A JSON web token could not be decoded")
+		}
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || header.Alg != "RS256" {
+		return AppClaims{}, fmt.Errorf("A JSON web token could not be decoded")
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(app.PublicKey, crypto.SHA256, hash[:], signature) != nil {
+		return AppClaims{}, fmt.Errorf("A JSON web token could not be decoded")
+	}
+
+	switch {
+	case claims.Issuer != strconv.FormatInt(app.AppID, 10):
+		return AppClaims{}, fmt.Errorf("'Issuer' claim ('iss') must be the App id")
+	case claims.IssuedAt > now.Unix():
+		return AppClaims{}, fmt.Errorf("'Issued at' claim ('iat') must be an Integer representing a time in the past")
+	case claims.ExpiresAt <= now.Unix():
+		return AppClaims{}, fmt.Errorf("'Expiration time' claim ('exp') must be a numeric value representing the future time at which the assertion expires")
+	case claims.ExpiresAt-claims.IssuedAt > int64(10*time.Minute/time.Second):
+		return AppClaims{}, fmt.Errorf("'Expiration time' claim ('exp') is too far in the future")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,468 @@
+// Package githubfake
+// A fake of the parts of the github REST api the service uses, to develop and test without github
+// the repositories are generated from a seed over an id space with gaps, like the real one
+package githubfake
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options
+// How the fake generates its repositories and behaves
+type Options struct {
+	// same seed, same repositories
+	Seed int64
+	// number of repositories generated
+	Count int
+	// id of the first repository
+	FirstID int
+	// ids are spaced by 1 to MaxGap, the ids in between don't exist (deleted or private repositories)
+	MaxGap int
+	// creation date of the first repository, the next ones are created every CreationInterval on average
+	Start            time.Time
+	CreationInterval time.Duration
+
+	// calls allowed per window, 0 for no limit
+	RateLimit       int
+	RateLimitWindow time.Duration
+
+	// added to every response
+	Latency time.Duration
+	// ratio of the calls answered with a 502, between 0 and 1
+	ErrorRate float64
+}
+
+// DefaultOptions
+// 10000 repositories created every minute over the last week, 5000 calls per hour
+func DefaultOptions() Options {
+	return Options{
+		Seed:             1,
+		Count:            10000,
+		FirstID:          1000,
+		MaxGap:           20,
+		Start:            time.Now().UTC().Add(-7 * 24 * time.Hour).Truncate(time.Second),
+		CreationInterval: time.Minute,
+		RateLimit:        5000,
+		RateLimitWindow:  time.Hour,
+	}
+}
+
+// Repository
+// A repository of the fake
+type Repository struct {
+	ID          int
+	Owner       string
+	OwnerType   string
+	Name        string
+	Description string
+	Stars       int
+	Forks       int
+	OpenIssues  int
+	Size        int
+	License     string
+	Languages   map[string]int
+	Topics      []string
+	CreatedAt   time.Time
+}
+
+func (repository Repository) FullName() string {
+	return repository.Owner + "/" + repository.Name
+}
+
+// Language
+// The language with the most code
+func (repository Repository) Language() string {
+	language, max := "", 0
+	for name, size := range repository.Languages {
+		if size > max || (size == max && name < language) {
+			language, max = name, size
+		}
+	}
+	return language
+}
+
+// ErrorRule
+// Answer the calls whose path starts with Path with the given status
+type ErrorRule struct {
+	Path   string
+	Status int
+	// how many calls fail, 0 for all of them
+	Times int
+}
+
+// Fake
+// The state of the fake github: its repositories, the rate limit and the errors to inject
+type Fake struct {
+	options Options
+
+	mu           sync.Mutex
+	repositories []Repository
+	byName       map[string]int
+	random       *rand.Rand
+	nextID       int
+	lastCreated  time.Time
+
+	rateLimitUsed  int
+	rateLimitReset time.Time
+	errorRules     []*ErrorRule
+	requests       int
+
+	app       *AppOptions
+	appClaims []AppClaims
+
+	hiddenFromGraphQL map[string]bool
+	graphQLQueries    []int
+}
+
+var (
+	words     = []string{"tiny", "awesome", "fast", "simple", "go", "rusty", "micro", "hyper", "cloud", "data", "web", "cli", "smart", "open", "deep"}
+	nouns     = []string{"parser", "server", "toolkit", "bot", "engine", "client", "dashboard", "notes", "api", "scraper", "compiler", "game", "sdk", "theme", "demo"}
+	languages = []string{"Go", "Python", "JavaScript", "TypeScript", "Rust", "Java", "C", "C++", "Ruby", "Shell", "HTML", "CSS"}
+	licenses  = []string{"", "", "mit", "apache-2.0", "gpl-3.0", "bsd-3-clause", "mpl-2.0"}
+	topics    = []string{"cli", "golang", "python", "web", "api", "machine-learning", "devops", "docker", "kubernetes", "hacktoberfest", "game", "database"}
+)
+
+// New
+// Generate the repositories of the options
+func New(options Options) *Fake {
+	if options.MaxGap < 1 {
+		options.MaxGap = 1
+	}
+	if options.CreationInterval <= 0 {
+		options.CreationInterval = time.Minute
+	}
+	if options.RateLimitWindow <= 0 {
+		options.RateLimitWindow = time.Hour
+	}
+
+	fake := &Fake{
+		options:     options,
+		byName:      map[string]int{},
+		random:      rand.New(rand.NewSource(options.Seed)),
+		nextID:      options.FirstID,
+		lastCreated: options.Start,
+	}
+
+	fake.Create(options.Count)
+
+	return fake
+}
+
+// Create
+// Add count repositories after the last one, with ids and creation dates after the last ones
+func (fake *Fake) Create(count int) []Repository {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	created := make([]Repository, 0, count)
+	for i := 0; i < count; i++ {
+		created = append(created, fake.create(""))
+	}
+
+	return created
+}
+
+// CreateOwned
+// Add count repositories owned by the user login, like Create
+func (fake *Fake) CreateOwned(login string, count int) []Repository {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	created := make([]Repository, 0, count)
+	for i := 0; i < count; i++ {
+		created = append(created, fake.create(login))
+	}
+
+	return created
+}
+
+// create must be called with the lock held
+// the owner is a random one unless login is set
+func (fake *Fake) create(login string) Repository {
+	random := fake.random
+
+	id := fake.nextID
+	fake.nextID += 1 + random.Intn(fake.options.MaxGap)
+
+	// twice the interval at most, the average is the interval
+	fake.lastCreated = fake.lastCreated.Add(time.Duration(random.Int63n(int64(2*fake.options.CreationInterval)) + 1)).Truncate(time.Second)
+
+	owner, ownerType := fmt.Sprintf("user%d", random.Intn(2000)), "User"
+	if random.Intn(5) == 0 {
+		owner, ownerType = fmt.Sprintf("org%d", random.Intn(300)), "Organization"
+	}
+	if login != "" {
+		owner, ownerType = login, "User"
+	}
+
+	repository := Repository{
+		ID:          id,
+		Owner:       owner,
+		OwnerType:   ownerType,
+		Name:        fmt.Sprintf("%s-%s-%d", words[random.Intn(len(words))], nouns[random.Intn(len(nouns))], id),
+		Description: fmt.Sprintf("A %s %s", words[random.Intn(len(words))], nouns[random.Intn(len(nouns))]),
+		License:     licenses[random.Intn(len(licenses))],
+		Languages:   map[string]int{},
+		Topics:      []string{},
+		CreatedAt:   fake.lastCreated,
+	}
+
+	// most repositories have no star, a few have a lot
+	if random.Intn(4) == 0 {
+		repository.Stars = int(random.ExpFloat64() * 50)
+		repository.Forks = repository.Stars / (1 + random.Intn(5))
+	}
+	repository.OpenIssues = random.Intn(5)
+
+	for i, count := 0, random.Intn(4); i < count; i++ {
+		size := 100 + random.Intn(100000)
+		repository.Languages[languages[random.Intn(len(languages))]] += size
+		repository.Size += size / 1024
+	}
+
+	for i, count := 0, random.Intn(4); i < count; i++ {
+		topic := topics[random.Intn(len(topics))]
+		if !contains(repository.Topics, topic) {
+			repository.Topics = append(repository.Topics, topic)
+		}
+	}
+	sort.Strings(repository.Topics)
+
+	fake.byName[strings.ToLower(repository.FullName())] = len(fake.repositories)
+	fake.repositories = append(fake.repositories, repository)
+
+	return repository
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Repositories
+// Every repository, the oldest first
+func (fake *Fake) Repositories() []Repository {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return append([]Repository(nil), fake.repositories...)
+}
+
+// Latest
+// The n repositories created last, the newest first
+func (fake *Fake) Latest(n int) []Repository {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	latest := make([]Repository, 0, n)
+	for i := len(fake.repositories) - 1; i >= 0 && len(latest) < n; i-- {
+		latest = append(latest, fake.repositories[i])
+	}
+
+	return latest
+}
+
+// Repository
+// The repository with this full name, case insensitive like github
+func (fake *Fake) Repository(fullName string) (Repository, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	index, ok := fake.byName[strings.ToLower(fullName)]
+	if !ok {
+		return Repository{}, false
+	}
+	return fake.repositories[index], true
+}
+
+// Since
+// Up to limit repositories with an id above since, ordered by id
+func (fake *Fake) Since(since int, limit int) []Repository {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	start := sort.Search(len(fake.repositories), func(i int) bool {
+		return fake.repositories[i].ID > since
+	})
+
+	end := start + limit
+	if end > len(fake.repositories) {
+		end = len(fake.repositories)
+	}
+
+	return append([]Repository(nil), fake.repositories[start:end]...)
+}
+
+// Owned
+// The repositories of an owner, ordered by name
+func (fake *Fake) Owned(login string) []Repository {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	var owned []Repository
+	for _, repository := range fake.repositories {
+		if strings.EqualFold(repository.Owner, login) {
+			owned = append(owned, repository)
+		}
+	}
+
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].Name < owned[j].Name
+	})
+
+	return owned
+}
+
+// Contributor
+// A contributor of a repository of the fake
+type Contributor struct {
+	Login         string
+	Type          string
+	Contributions int
+}
+
+// Contributors
+// The contributors of a repository, the ones with the most contributions first
+// they are generated from the seed and the id of the repository, the same each time
+func (fake *Fake) Contributors(fullName string) ([]Contributor, bool) {
+	repository, ok := fake.Repository(fullName)
+	if !ok {
+		return nil, false
+	}
+
+	random := rand.New(rand.NewSource(fake.options.Seed + int64(repository.ID)))
+
+	// most repositories have a single contributor, a few have hundreds
+	count := 1
+	if random.Intn(3) == 0 {
+		count += int(random.ExpFloat64() * 40)
+	}
+
+	contributors := []Contributor{{Login: repository.Owner, Type: "User", Contributions: 1 + random.Intn(500)}}
+	if repository.OwnerType == "Organization" {
+		contributors[0].Login = fmt.Sprintf("user%d", random.Intn(2000))
+	}
+	for i := 1; i < count; i++ {
+		contributor := Contributor{Login: fmt.Sprintf("user%d", random.Intn(2000)), Type: "User", Contributions: 1 + random.Intn(50)}
+		if random.Intn(20) == 0 {
+			contributor.Login, contributor.Type = fmt.Sprintf("bot%d[bot]", random.Intn(10)), "Bot"
+		}
+		contributors = append(contributors, contributor)
+	}
+
+	sort.SliceStable(contributors, func(i, j int) bool {
+		return contributors[i].Contributions > contributors[j].Contributions
+	})
+
+	return contributors, true
+}
+
+// InjectError
+// Fail the next calls matching the rule, the rules are checked in the order they are added
+func (fake *Fake) InjectError(rule ErrorRule) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.errorRules = append(fake.errorRules, &rule)
+}
+
+// ClearErrors
+// Remove every rule added with InjectError
+func (fake *Fake) ClearErrors() {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.errorRules = nil
+}
+
+// Requests
+// Number of calls received, 304 included
+func (fake *Fake) Requests() int {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return fake.requests
+}
+
+// injectedError
+// The status of the first rule matching the path, 0 if there is none
+func (fake *Fake) injectedError(path string) int {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	for i, rule := range fake.errorRules {
+		if !strings.HasPrefix(path, rule.Path) {
+			continue
+		}
+
+		if rule.Times > 0 {
+			rule.Times--
+			if rule.Times == 0 {
+				fake.errorRules = append(fake.errorRules[:i], fake.errorRules[i+1:]...)
+			}
+		}
+		return rule.Status
+	}
+
+	if fake.options.ErrorRate > 0 && fake.random.Float64() < fake.options.ErrorRate {
+		return http.StatusBadGateway
+	}
+
+	return 0
+}
+
+// RateLimit
+// State of the rate limit as github reports it in the X-RateLimit-* headers
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Used      int
+	Reset     time.Time
+}
+
+// RateLimit
+// The rate limit as /rate_limit reports it, without counting a call
+func (fake *Fake) RateLimit() RateLimit {
+	rateLimit, _ := fake.takeRateLimit(time.Now(), false)
+	return rateLimit
+}
+
+// takeRateLimit
+// Count a call, returns false once the limit is reached
+func (fake *Fake) takeRateLimit(now time.Time, count bool) (RateLimit, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if !now.Before(fake.rateLimitReset) {
+		fake.rateLimitUsed = 0
+		fake.rateLimitReset = now.Add(fake.options.RateLimitWindow).Truncate(time.Second)
+	}
+
+	limit := fake.options.RateLimit
+	if limit <= 0 {
+		return RateLimit{Limit: 0, Reset: fake.rateLimitReset}, true
+	}
+
+	if count && fake.rateLimitUsed < limit {
+		fake.rateLimitUsed++
+	} else if count {
+		return RateLimit{Limit: limit, Used: fake.rateLimitUsed, Reset: fake.rateLimitReset}, false
+	}
+
+	return RateLimit{
+		Limit:     limit,
+		Remaining: limit - fake.rateLimitUsed,
+		Used:      fake.rateLimitUsed,
+		Reset:     fake.rateLimitReset,
+	}, true
+}
@@ -0,0 +1,149 @@
+package githubfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// `alias: repository(owner: $o0, name: $n0)` or with the owner and the name inline
+var graphQLRepositoryField = regexp.MustCompile(`(\w+)\s*:\s*repository\s*\(\s*owner\s*:\s*(\$\w+|"[^"]*")\s*,\s*name\s*:\s*(\$\w+|"[^"]*")\s*\)`)
+
+// HideFromGraphQL
+// The repository is not found by the graphql api, the REST api still finds it
+// like a repository github fails to resolve in a batch
+func (fake *Fake) HideFromGraphQL(fullName string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if fake.hiddenFromGraphQL == nil {
+		fake.hiddenFromGraphQL = map[string]bool{}
+	}
+	fake.hiddenFromGraphQL[strings.ToLower(fullName)] = true
+}
+
+// GraphQLQueries
+// The number of repositories asked by each graphql query received, in the order they were received
+func (fake *Fake) GraphQLQueries() []int {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return append([]int(nil), fake.graphQLQueries...)
+}
+
+type graphQLError struct {
+	Type    string `json:"type,omitempty"`
+	Path    []any  `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// graphQL
+// POST /graphql with the aliased `repository` fields the stats backend sends, every other field is refused
+// a repository not found is null in data with an error giving its alias, like on github
+func (fake *Fake) graphQL(r *http.Request) response {
+	var request struct {
+		Query     string         `json:"query"`
+		Variables map[string]any `json:"variables"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		return messageResponse(http.StatusBadRequest, "Problems parsing JSON")
+	}
+
+	fields := graphQLRepositoryField.FindAllStringSubmatch(request.Query, -1)
+	if len(fields) == 0 {
+		return jsonResponse(http.StatusOK, map[string]any{
+			"errors": []graphQLError{{Message: "the fake github only resolves aliased `repository` fields"}},
+		})
+	}
+
+	fake.mu.Lock()
+	fake.graphQLQueries = append(fake.graphQLQueries, len(fields))
+	hidden := fake.hiddenFromGraphQL
+	fake.mu.Unlock()
+
+	argument := func(value string) (string, error) {
+		if strings.HasPrefix(value, `"`) {
+			return strings.Trim(value, `"`), nil
+		}
+		variable, ok := request.Variables[strings.TrimPrefix(value, "$")].(string)
+		if !ok {
+			return "", fmt.Errorf("Variable %s of type String! was provided invalid value", value)
+		}
+		return variable, nil
+	}
+
+	data := map[string]any{}
+	var errs []graphQLError
+	for _, field := range fields {
+		alias := field[1]
+
+		owner, err := argument(field[2])
+		if err != nil {
+			return jsonResponse(http.StatusOK, map[string]any{"errors": []graphQLError{{Message: err.Error()}}})
+		}
+		name, err := argument(field[3])
+		if err != nil {
+			return jsonResponse(http.StatusOK, map[string]any{"errors": []graphQLError{{Message: err.Error()}}})
+		}
+
+		repository, ok := fake.Repository(owner + "/" + name)
+		if !ok || hidden[strings.ToLower(owner+"/"+name)] {
+			data[alias] = nil
+			errs = append(errs, graphQLError{
+				Type:    "NOT_FOUND",
+				Path:    []any{alias},
+				Message: fmt.Sprintf("Could not resolve to a Repository with the name '%s/%s'.", owner, name),
+			})
+			continue
+		}
+
+		data[alias] = graphQLRepository(repository)
+	}
+
+	body := map[string]any{"data": data}
+	if len(errs) > 0 {
+		body["errors"] = errs
+	}
+
+	return jsonResponse(http.StatusOK, body)
+}
+
+// graphQLRepository
+// The fields of the `stats` fragment of the stats backend
+func graphQLRepository(repository Repository) map[string]any {
+	var licenseInfo any
+	if repository.License != "" {
+		licenseInfo = map[string]string{"key": repository.License}
+	}
+
+	names := make([]string, 0, len(repository.Languages))
+	for name := range repository.Languages {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return repository.Languages[names[i]] > repository.Languages[names[j]]
+	})
+
+	edges := make([]map[string]any, 0, len(names))
+	for _, name := range names {
+		edges = append(edges, map[string]any{"size": repository.Languages[name], "node": map[string]string{"name": name}})
+	}
+
+	topics := make([]map[string]any, 0, len(repository.Topics))
+	for _, topic := range repository.Topics {
+		topics = append(topics, map[string]any{"topic": map[string]string{"name": topic}})
+	}
+
+	return map[string]any{
+		"stargazerCount":   repository.Stars,
+		"createdAt":        repository.CreatedAt.Format(time.RFC3339),
+		"licenseInfo":      licenseInfo,
+		"languages":        map[string]any{"edges": edges},
+		"repositoryTopics": map[string]any{"nodes": topics},
+	}
+}
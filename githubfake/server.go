@@ -0,0 +1,455 @@
+package githubfake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+)
+
+// repositories per page of /repositories, like github
+const pageSize = 100
+
+// most repositories per page of the owner repositories
+const maxPerPage = 100
+
+// Server
+// The fake served by an httptest server, for the tests
+type Server struct {
+	*Fake
+	URL string
+
+	server *httptest.Server
+}
+
+// NewServer
+// Start a server on a random local port, Close must be called once done
+func NewServer(options Options) *Server {
+	fake := New(options)
+	server := httptest.NewServer(fake.Handler())
+
+	return &Server{Fake: fake, URL: server.URL, server: server}
+}
+
+func (server *Server) Close() {
+	server.server.Close()
+}
+
+// response
+// What a route answers, sent once the ETag and the rate limit are applied
+type response struct {
+	status int
+	body   any
+	header http.Header
+}
+
+func jsonResponse(status int, body any) response {
+	return response{status: status, body: body, header: http.Header{}}
+}
+
+func messageResponse(status int, message string) response {
+	return jsonResponse(status, map[string]string{
+		"message":           message,
+		"documentation_url": "https://docs.github.com/rest",
+	})
+}
+
+// Handler
+// The routes of the fake, the urls in the responses point to the host the request was made to
+func (fake *Fake) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		fake.requests++
+		fake.mu.Unlock()
+
+		if fake.options.Latency > 0 {
+			time.Sleep(fake.options.Latency)
+		}
+
+		if status := fake.injectedError(r.URL.Path); status != 0 {
+			fake.write(w, r, messageResponse(status, http.StatusText(status)))
+			return
+		}
+
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if r.Method == http.MethodPost && len(parts) == 4 && parts[0] == "app" && parts[1] == "installations" && parts[3] == "access_tokens" {
+			fake.write(w, r, fake.createInstallationToken(r, parts[2]))
+			return
+		}
+
+		if r.Method == http.MethodPost && len(parts) == 1 && parts[0] == "graphql" {
+			fake.write(w, r, fake.graphQL(r))
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			fake.write(w, r, messageResponse(http.StatusNotFound, "Not Found"))
+			return
+		}
+
+		fake.write(w, r, fake.route(r))
+	})
+}
+
+// write
+// Apply the conditional request and the rate limit then send the response
+// a 304 doesn't count against the rate limit, like on github
+func (fake *Fake) write(w http.ResponseWriter, r *http.Request, res response) {
+	payload, err := json.Marshal(res.body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(payload)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	notModified := res.status == http.StatusOK && r.Header.Get("If-None-Match") == etag
+
+	rateLimit, ok := fake.takeRateLimit(time.Now(), !notModified)
+
+	for key, values := range res.header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if rateLimit.Limit > 0 {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(rateLimit.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(rateLimit.Remaining))
+		w.Header().Set("X-RateLimit-Used", strconv.Itoa(rateLimit.Used))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(rateLimit.Reset.Unix(), 10))
+		w.Header().Set("X-RateLimit-Resource", "core")
+	}
+
+	if !ok {
+		w.Header().Del("Link")
+		payload, _ = json.Marshal(map[string]string{
+			"message":           "API rate limit exceeded",
+			"documentation_url": "https://docs.github.com/rest/overview/resources-in-the-rest-api#rate-limiting",
+		})
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write(payload)
+		return
+	}
+
+	if res.status == http.StatusOK {
+		w.Header().Set("ETag", etag)
+	}
+
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(res.status)
+	_, _ = w.Write(payload)
+}
+
+// route
+// /repositories, /repos/{owner}/{name}, /repos/{owner}/{name}/languages,
+// /repos/{owner}/{name}/contributors, /users/{login}/repos, /orgs/{login}/repos, /events and /rate_limit
+func (fake *Fake) route(r *http.Request) response {
+	base := baseURL(r)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "repositories":
+		return fake.listRepositories(base, r.URL.Query())
+	case len(parts) == 1 && parts[0] == "events":
+		return fake.listEvents(base)
+	case len(parts) == 1 && parts[0] == "rate_limit":
+		rateLimit, _ := fake.takeRateLimit(time.Now(), false)
+		return jsonResponse(http.StatusOK, map[string]any{
+			"resources": map[string]any{
+				"core": map[string]any{"limit": rateLimit.Limit, "remaining": rateLimit.Remaining, "used": rateLimit.Used, "reset": rateLimit.Reset.Unix()},
+			},
+		})
+	case len(parts) == 3 && parts[0] == "repos":
+		repository, ok := fake.Repository(parts[1] + "/" + parts[2])
+		if !ok {
+			return messageResponse(http.StatusNotFound, "Not Found")
+		}
+		return jsonResponse(http.StatusOK, fullRepository(base, repository))
+	case len(parts) == 4 && parts[0] == "repos" && parts[3] == "languages":
+		repository, ok := fake.Repository(parts[1] + "/" + parts[2])
+		if !ok {
+			return messageResponse(http.StatusNotFound, "Not Found")
+		}
+		return jsonResponse(http.StatusOK, repository.Languages)
+	case len(parts) == 4 && parts[0] == "repos" && parts[3] == "contributors":
+		return fake.listContributors(base, r.URL, parts[1]+"/"+parts[2])
+	case len(parts) == 3 && (parts[0] == "users" || parts[0] == "orgs") && parts[2] == "repos":
+		return fake.listOwnerRepositories(base, r.URL, parts[1])
+	}
+
+	return messageResponse(http.StatusNotFound, "Not Found")
+}
+
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// listRepositories
+// 100 repositories after `since`, with a `next` link like github
+func (fake *Fake) listRepositories(base string, query url.Values) response {
+	since := 0
+	if sinceParam := query.Get("since"); sinceParam != "" {
+		var err error
+		since, err = strconv.Atoi(sinceParam)
+		if err != nil {
+			return messageResponse(http.StatusUnprocessableEntity, "Invalid since")
+		}
+	}
+
+	repositories := fake.Since(since, pageSize)
+
+	results := make([]listedRepository, 0, len(repositories))
+	for _, repository := range repositories {
+		results = append(results, newListedRepository(base, repository))
+	}
+
+	res := jsonResponse(http.StatusOK, results)
+
+	// github always gives a next link, even on the last page
+	next := since
+	if len(repositories) > 0 {
+		next = repositories[len(repositories)-1].ID
+	}
+	res.header.Set("Link", fmt.Sprintf(`<%s/repositories?since=%d>; rel="next"`, base, next))
+
+	return res
+}
+
+// how long github asks to wait between two polls of /events
+const eventsPollInterval = 60
+
+// listEvents
+// The creation of the last 100 repositories as public CreateEvents, the most recent first
+func (fake *Fake) listEvents(base string) response {
+	latest := fake.Latest(pageSize)
+
+	events := make([]github.Event, 0, len(latest))
+	for _, repository := range latest {
+		var event github.Event
+		event.Id = strconv.Itoa(repository.ID)
+		event.Type = "CreateEvent"
+		event.Actor.Login = repository.Owner
+		event.Actor.DisplayLogin = repository.Owner
+		event.Actor.Url = base + "/users/" + repository.Owner
+		event.Repo.Id = uint(repository.ID)
+		event.Repo.Name = repository.FullName()
+		event.Repo.Url = base + "/repos/" + repository.FullName()
+		event.Payload.RefType = "repository"
+		event.Payload.Description = repository.Description
+		event.Public = true
+		event.CreatedAt = repository.CreatedAt
+		events = append(events, event)
+	}
+
+	res := jsonResponse(http.StatusOK, events)
+	res.header.Set("X-Poll-Interval", strconv.Itoa(eventsPollInterval))
+
+	return res
+}
+
+// pageParams
+// `page` and `per_page` of the request, 30 per page by default like github
+func pageParams(query url.Values) (int, int, error) {
+	perPage := 30
+	if perPageParam := query.Get("per_page"); perPageParam != "" {
+		value, err := strconv.Atoi(perPageParam)
+		if err != nil || value < 1 {
+			return 0, 0, fmt.Errorf("Invalid per_page")
+		}
+		perPage = value
+		if perPage > maxPerPage {
+			perPage = maxPerPage
+		}
+	}
+
+	page := 1
+	if pageParam := query.Get("page"); pageParam != "" {
+		value, err := strconv.Atoi(pageParam)
+		if err != nil || value < 1 {
+			return 0, 0, fmt.Errorf("Invalid page")
+		}
+		page = value
+	}
+
+	return page, perPage, nil
+}
+
+// paginate
+// The page of the items with the first, prev, next and last links
+func paginate[T any](base string, requestURL *url.URL, items []T) response {
+	query := requestURL.Query()
+
+	page, perPage, err := pageParams(query)
+	if err != nil {
+		return messageResponse(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	results := []T{}
+	for i := (page - 1) * perPage; i < page*perPage && i < len(items); i++ {
+		results = append(results, items[i])
+	}
+
+	res := jsonResponse(http.StatusOK, results)
+
+	lastPage := (len(items) + perPage - 1) / perPage
+	if lastPage > 1 {
+		res.header.Set("Link", paginationLinks(base+requestURL.Path, query, page, lastPage))
+	}
+
+	return res
+}
+
+// listOwnerRepositories
+// The repositories of an owner paginated with `page` and `per_page`
+// the fake only knows the owners of its repositories, any other login is not found like an unknown user on github
+func (fake *Fake) listOwnerRepositories(base string, requestURL *url.URL, login string) response {
+	owned := fake.Owned(login)
+	if len(owned) == 0 {
+		return messageResponse(http.StatusNotFound, "Not Found")
+	}
+
+	repositories := make([]github.Repository, 0, len(owned))
+	for _, repository := range owned {
+		repositories = append(repositories, fullRepository(base, repository))
+	}
+
+	return paginate(base, requestURL, repositories)
+}
+
+// listContributors
+// The contributors of a repository paginated with `page` and `per_page`
+func (fake *Fake) listContributors(base string, requestURL *url.URL, fullName string) response {
+	contributors, ok := fake.Contributors(fullName)
+	if !ok {
+		return messageResponse(http.StatusNotFound, "Not Found")
+	}
+
+	results := make([]github.Contributor, 0, len(contributors))
+	for i, contributor := range contributors {
+		results = append(results, github.Contributor{
+			Id:            uint(i + 1),
+			Login:         contributor.Login,
+			Type:          contributor.Type,
+			Url:           base + "/users/" + contributor.Login,
+			HtmlUrl:       "https://github.com/" + contributor.Login,
+			Contributions: contributor.Contributions,
+		})
+	}
+
+	return paginate(base, requestURL, results)
+}
+
+// paginationLinks
+// The Link header of a page, without the relations that don't apply to it
+func paginationLinks(path string, query url.Values, page, lastPage int) string {
+	link := func(rel string, page int) string {
+		values := url.Values{}
+		for key, value := range query {
+			values[key] = value
+		}
+		values.Set("page", strconv.Itoa(page))
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, path, values.Encode(), rel)
+	}
+
+	var links []string
+	if page > 1 {
+		links = append(links, link("prev", page-1))
+	}
+	if page < lastPage {
+		links = append(links, link("next", page+1))
+		links = append(links, link("last", lastPage))
+	}
+	if page > 1 {
+		links = append(links, link("first", 1))
+	}
+
+	return strings.Join(links, ", ")
+}
+
+// listedRepository
+// The partial record of a repository returned by the list endpoints
+type listedRepository struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Private  bool   `json:"private"`
+	Owner    struct {
+		ID    int    `json:"id"`
+		Login string `json:"login"`
+		Type  string `json:"type"`
+		URL   string `json:"url"`
+	} `json:"owner"`
+	HTMLURL      string `json:"html_url"`
+	Description  string `json:"description"`
+	Fork         bool   `json:"fork"`
+	URL          string `json:"url"`
+	LanguagesURL string `json:"languages_url"`
+}
+
+func newListedRepository(base string, repository Repository) listedRepository {
+	listed := listedRepository{
+		ID:           repository.ID,
+		Name:         repository.Name,
+		FullName:     repository.FullName(),
+		HTMLURL:      "https://github.com/" + repository.FullName(),
+		Description:  repository.Description,
+		URL:          base + "/repos/" + repository.FullName(),
+		LanguagesURL: base + "/repos/" + repository.FullName() + "/languages",
+	}
+	listed.Owner.Login = repository.Owner
+	listed.Owner.Type = repository.OwnerType
+	listed.Owner.URL = base + "/users/" + repository.Owner
+
+	return listed
+}
+
+// fullRepository
+// The complete record of a repository, as /repos/{owner}/{name} returns it
+func fullRepository(base string, repository Repository) github.Repository {
+	var full github.Repository
+
+	full.Id = uint(repository.ID)
+	full.Name = repository.Name
+	full.FullName = repository.FullName()
+	full.Owner.Login = repository.Owner
+	full.Owner.Type = repository.OwnerType
+	full.Owner.Url = base + "/users/" + repository.Owner
+	full.Owner.ReposUrl = base + "/users/" + repository.Owner + "/repos"
+	full.HtmlUrl = "https://github.com/" + repository.FullName()
+	full.Url = base + "/repos/" + repository.FullName()
+	full.LanguagesUrl = full.Url + "/languages"
+	full.Description = repository.Description
+	full.Language = repository.Language()
+	full.StargazersCount = repository.Stars
+	full.WatchersCount = uint(repository.Stars)
+	full.ForksCount = uint(repository.Forks)
+	full.OpenIssuesCount = uint(repository.OpenIssues)
+	full.Size = uint(repository.Size)
+	full.Topics = repository.Topics
+	full.Visibility = "public"
+	full.CreatedAt = repository.CreatedAt
+	full.UpdatedAt = repository.CreatedAt
+	full.PushedAt = repository.CreatedAt
+	if repository.License != "" {
+		full.License.Key = repository.License
+		full.License.Name = strings.ToUpper(repository.License)
+		full.License.Url = base + "/licenses/" + repository.License
+	}
+
+	return full
+}
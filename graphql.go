@@ -0,0 +1,989 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Scalingo/go-handlers"
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/printer"
+)
+
+const (
+	// github calls made by the search for the last repositories created
+	graphqlSearchCost = 40
+	// github calls made for the stats of one repository: the repository and its languages
+	graphqlRepositoryCost = 2
+	// repositories returned by a search, and most items returned in a page
+	graphqlPageSize = 100
+)
+
+// GraphQLLanguage
+// Bytes of code of a language in a repository
+type GraphQLLanguage struct {
+	Name  string `json:"name"`
+	Bytes int    `json:"bytes"`
+}
+
+// GraphQLLanguageCount
+// How many repositories of an owner use a language
+type GraphQLLanguageCount struct {
+	Name         string `json:"name"`
+	Repositories int    `json:"repositories"`
+}
+
+// GraphQLLanguageAggregate
+// How many repositories use a language and their bytes of code in it
+type GraphQLLanguageAggregate struct {
+	Name         string `json:"name"`
+	Repositories int    `json:"repositories"`
+	Bytes        int    `json:"bytes"`
+}
+
+// GraphQLLicenseAggregate
+// How many repositories use a license, an empty key for the ones without
+type GraphQLLicenseAggregate struct {
+	Key          string `json:"key"`
+	Repositories int    `json:"repositories"`
+}
+
+type GraphQLPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+// GraphQLConnection
+// A page of items
+type GraphQLConnection[T any] struct {
+	TotalCount int             `json:"totalCount"`
+	Nodes      []T             `json:"nodes"`
+	PageInfo   GraphQLPageInfo `json:"pageInfo"`
+}
+
+func graphqlCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte("cursor:" + strconv.Itoa(offset)))
+}
+
+// paginate
+// The `first` items after the `after` cursor, cursors are opaque offsets
+func paginate[T any](items []T, first int, after string) (GraphQLConnection[T], error) {
+	start := 0
+	if after != "" {
+		decoded, err := base64.StdEncoding.DecodeString(after)
+		offset, ok := strings.CutPrefix(string(decoded), "cursor:")
+		if err != nil || !ok {
+			return GraphQLConnection[T]{}, fmt.Errorf("invalid cursor `%s`", after)
+		}
+		start, err = strconv.Atoi(offset)
+		if err != nil || start < 0 {
+			return GraphQLConnection[T]{}, fmt.Errorf("invalid cursor `%s`", after)
+		}
+		start++
+	}
+
+	if first < 0 || first > graphqlPageSize {
+		return GraphQLConnection[T]{}, fmt.Errorf("first must be between 0 and %d", graphqlPageSize)
+	}
+
+	connection := GraphQLConnection[T]{TotalCount: len(items), Nodes: []T{}}
+	if start >= len(items) {
+		return connection, nil
+	}
+
+	end := start + first
+	if end > len(items) {
+		end = len(items)
+	}
+
+	connection.Nodes = items[start:end]
+	connection.PageInfo.HasNextPage = end < len(items)
+	if end > start {
+		connection.PageInfo.EndCursor = graphqlCursor(end - 1)
+	}
+
+	return connection, nil
+}
+
+type graphqlLoaderKey struct{}
+
+// GraphQLLoader
+// Per request cache of what the resolvers fetch
+// the repositories whose stats are asked are queued and fetched at once by the workers
+// when the first of them is needed, instead of one at a time
+type GraphQLLoader struct {
+	ctx context.Context
+
+	mu sync.Mutex
+	// the batch the next repositories are queued in, nil until one is queued
+	batch *graphqlStatsBatch
+	// batch and index of each repository queued
+	loads map[string]graphqlStatsLoad
+	// stats of each filter, by normalized query
+	stats map[string][]Stats
+}
+
+// graphqlStatsBatch
+// Repositories whose stats are fetched together, the fetch runs once without the lock of the loader
+type graphqlStatsBatch struct {
+	repositories []github.Repository
+	once         sync.Once
+	results      []WorkerStats
+}
+
+type graphqlStatsLoad struct {
+	batch *graphqlStatsBatch
+	index int
+}
+
+func newGraphQLLoader(ctx context.Context) *GraphQLLoader {
+	return &GraphQLLoader{
+		ctx:   ctx,
+		loads: map[string]graphqlStatsLoad{},
+		stats: map[string][]Stats{},
+	}
+}
+
+func graphqlLoaderFromContext(ctx context.Context) *GraphQLLoader {
+	loader, ok := ctx.Value(graphqlLoaderKey{}).(*GraphQLLoader)
+	if !ok {
+		return newGraphQLLoader(ctx)
+	}
+	return loader
+}
+
+// LoadRepositoryStats
+// Queue the repository and return a thunk, the executor calls it once every field of the list is resolved
+func (loader *GraphQLLoader) LoadRepositoryStats(repo Repo) func() (interface{}, error) {
+	key := repositoryKey(repo.Owner + "/" + repo.Name)
+
+	loader.mu.Lock()
+	load, ok := loader.loads[key]
+	if !ok {
+		if loader.batch == nil {
+			loader.batch = &graphqlStatsBatch{}
+		}
+		load = graphqlStatsLoad{batch: loader.batch, index: len(loader.batch.repositories)}
+		loader.batch.repositories = append(loader.batch.repositories, lookupRepository(repo.Owner, repo.Name))
+		loader.loads[key] = load
+	}
+	loader.mu.Unlock()
+
+	return func() (interface{}, error) {
+		loader.dispatch(load.batch)
+
+		result := load.batch.results[load.index]
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Stats, nil
+	}
+}
+
+// dispatch
+// Fetch the stats of every repository of the batch with a single batch of worker tasks
+// the first caller fetches them, the others wait for it
+// the repositories queued meanwhile go to a new batch
+func (loader *GraphQLLoader) dispatch(batch *graphqlStatsBatch) {
+	batch.once.Do(func() {
+		loader.mu.Lock()
+		if loader.batch == batch {
+			loader.batch = nil
+		}
+		loader.mu.Unlock()
+
+		batch.results = runStatsTasks(loader.ctx, url.Values{}, batch.repositories)
+	})
+}
+
+// Stats
+// The stats /stats would return with these parameters, fetched once per request
+func (loader *GraphQLLoader) Stats(params url.Values) ([]Stats, error) {
+	key := normalizeQuery(params)
+
+	loader.mu.Lock()
+	stats, ok := loader.stats[key]
+	loader.mu.Unlock()
+	if ok {
+		return stats, nil
+	}
+
+	stats, err := fetchStats(loader.ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	loader.mu.Lock()
+	loader.stats[key] = stats
+	loader.mu.Unlock()
+
+	return stats, nil
+}
+
+// graphqlParams
+// The `filter` argument as the query parameters of /stats
+func graphqlParams(args map[string]interface{}) url.Values {
+	params := url.Values{}
+
+	filter, _ := args["filter"].(map[string]interface{})
+	for _, name := range []string{"language", "license", "since"} {
+		if value, ok := filter[name].(string); ok && value != "" {
+			params.Set(name, value)
+		}
+	}
+
+	if topics, ok := filter["topics"].([]interface{}); ok {
+		for _, topic := range topics {
+			if topic, ok := topic.(string); ok {
+				params.Add("topic", topic)
+			}
+		}
+	}
+	if mode, ok := filter["topicMode"].(string); ok {
+		params.Set("topic_mode", mode)
+	}
+
+	return params
+}
+
+func graphqlFirst(args map[string]interface{}) int {
+	if first, ok := args["first"].(int); ok {
+		return first
+	}
+	return graphqlPageSize
+}
+
+func graphqlAfter(args map[string]interface{}) string {
+	after, _ := args["after"].(string)
+	return after
+}
+
+func sortedLanguages(languages map[string]int) []GraphQLLanguage {
+	results := make([]GraphQLLanguage, 0, len(languages))
+	for name, size := range languages {
+		results = append(results, GraphQLLanguage{Name: name, Bytes: size})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Bytes != results[j].Bytes {
+			return results[i].Bytes > results[j].Bytes
+		}
+		return results[i].Name < results[j].Name
+	})
+
+	return results
+}
+
+// sortedLanguageCounts
+// The languages of an owner, the ones used by the most repositories first
+func sortedLanguageCounts(languages map[string]int) []GraphQLLanguageCount {
+	results := make([]GraphQLLanguageCount, 0, len(languages))
+	for name, count := range languages {
+		results = append(results, GraphQLLanguageCount{Name: name, Repositories: count})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Repositories != results[j].Repositories {
+			return results[i].Repositories > results[j].Repositories
+		}
+		return results[i].Name < results[j].Name
+	})
+
+	return results
+}
+
+// aggregateLanguages
+// Repositories and bytes of code per language, the most used first
+func aggregateLanguages(stats []Stats) []GraphQLLanguageAggregate {
+	languages := map[string]*GraphQLLanguageAggregate{}
+	for _, stat := range stats {
+		for name, size := range stat.Languages {
+			language, ok := languages[name]
+			if !ok {
+				language = &GraphQLLanguageAggregate{Name: name}
+				languages[name] = language
+			}
+			language.Repositories++
+			language.Bytes += size
+		}
+	}
+
+	results := make([]GraphQLLanguageAggregate, 0, len(languages))
+	for _, language := range languages {
+		results = append(results, *language)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Repositories != results[j].Repositories {
+			return results[i].Repositories > results[j].Repositories
+		}
+		return results[i].Name < results[j].Name
+	})
+
+	return results
+}
+
+// aggregateLicenses
+// Repositories per license, the most used first
+func aggregateLicenses(stats []Stats) []GraphQLLicenseAggregate {
+	counts := map[string]int{}
+	for _, stat := range stats {
+		counts[stat.License]++
+	}
+
+	results := make([]GraphQLLicenseAggregate, 0, len(counts))
+	for key, count := range counts {
+		results = append(results, GraphQLLicenseAggregate{Key: key, Repositories: count})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Repositories != results[j].Repositories {
+			return results[i].Repositories > results[j].Repositories
+		}
+		return results[i].Key < results[j].Key
+	})
+
+	return results
+}
+
+// sortStats
+// by: NAME (owner/name), OWNER or STARS, order: ASC or DESC
+func sortStats(stats []Stats, by, order string) []Stats {
+	sorted := append([]Stats(nil), stats...)
+
+	less := func(i, j int) bool {
+		switch by {
+		case "STARS":
+			if sorted[i].StarCount != sorted[j].StarCount {
+				return sorted[i].StarCount < sorted[j].StarCount
+			}
+		case "OWNER":
+			if sorted[i].Owner != sorted[j].Owner {
+				return sorted[i].Owner < sorted[j].Owner
+			}
+		}
+		return sorted[i].Owner+"/"+sorted[i].Name < sorted[j].Owner+"/"+sorted[j].Name
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if order == "DESC" {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+
+	return sorted
+}
+
+func sortRepos(repos []Repo, by, order string) []Repo {
+	sorted := append([]Repo(nil), repos...)
+
+	less := func(i, j int) bool {
+		if by == "OWNER" && sorted[i].Owner != sorted[j].Owner {
+			return sorted[i].Owner < sorted[j].Owner
+		}
+		return sorted[i].Owner+"/"+sorted[i].Name < sorted[j].Owner+"/"+sorted[j].Name
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if order == "DESC" {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+
+	return sorted
+}
+
+// graphqlSchema
+// The schema of /graphql, the resolvers use the same functions as the REST handlers
+func graphqlSchema() (graphql.Schema, error) {
+	sortOrder := graphql.NewEnum(graphql.EnumConfig{
+		Name: "SortOrder",
+		Values: graphql.EnumValueConfigMap{
+			"ASC":  &graphql.EnumValueConfig{Value: "ASC"},
+			"DESC": &graphql.EnumValueConfig{Value: "DESC"},
+		},
+	})
+
+	statsSortField := graphql.NewEnum(graphql.EnumConfig{
+		Name: "StatsSortField",
+		Values: graphql.EnumValueConfigMap{
+			"NAME":  &graphql.EnumValueConfig{Value: "NAME"},
+			"OWNER": &graphql.EnumValueConfig{Value: "OWNER"},
+			"STARS": &graphql.EnumValueConfig{Value: "STARS"},
+		},
+	})
+
+	repositorySortField := graphql.NewEnum(graphql.EnumConfig{
+		Name: "RepositorySortField",
+		Values: graphql.EnumValueConfigMap{
+			"NAME":  &graphql.EnumValueConfig{Value: "NAME"},
+			"OWNER": &graphql.EnumValueConfig{Value: "OWNER"},
+		},
+	})
+
+	topicMode := graphql.NewEnum(graphql.EnumConfig{
+		Name: "TopicMode",
+		Values: graphql.EnumValueConfigMap{
+			"ANY": &graphql.EnumValueConfig{Value: topicModeAny},
+			"ALL": &graphql.EnumValueConfig{Value: topicModeAll},
+		},
+	})
+
+	statsFilter := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "StatsFilter",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"language":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"license":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"topics":    &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"topicMode": &graphql.InputObjectFieldConfig{Type: topicMode},
+			"since":     &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "id of the repository to list from, instead of the last ones created"},
+		},
+	})
+
+	language := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Language",
+		Fields: graphql.Fields{
+			"name":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"bytes": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	// Stats embeds Repo, the default resolver doesn't look into embedded structs
+	repoField := func(fieldType graphql.Output, fn func(repo Repo) interface{}) *graphql.Field {
+		return &graphql.Field{
+			Type: fieldType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				switch source := p.Source.(type) {
+				case Repo:
+					return fn(source), nil
+				case Stats:
+					return fn(source.Repo), nil
+				}
+				return nil, nil
+			},
+		}
+	}
+
+	repoFields := func(fields graphql.Fields) graphql.Fields {
+		fields["name"] = repoField(graphql.NewNonNull(graphql.String), func(repo Repo) interface{} { return repo.Name })
+		fields["owner"] = repoField(graphql.NewNonNull(graphql.String), func(repo Repo) interface{} { return repo.Owner })
+		fields["fullName"] = repoField(graphql.NewNonNull(graphql.String), func(repo Repo) interface{} { return repo.Owner + "/" + repo.Name })
+		fields["url"] = repoField(graphql.NewNonNull(graphql.String), func(repo Repo) interface{} { return repo.Url })
+		fields["description"] = repoField(graphql.String, func(repo Repo) interface{} { return repo.Description })
+		return fields
+	}
+
+	stats := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Stats",
+		Fields: repoFields(graphql.Fields{
+			"license": &graphql.Field{Type: graphql.String},
+			"topics":  &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"starsCount": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Stats).StarCount, nil
+				},
+			},
+			"languages": &graphql.Field{
+				Type: graphql.NewList(graphql.NewNonNull(language)),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return sortedLanguages(p.Source.(Stats).Languages), nil
+				},
+			},
+		}),
+	})
+
+	// the fields a repository doesn't have are resolved with its stats, batched by the loader
+	repositoryStatsField := func(fieldType graphql.Output, fn func(stats Stats) interface{}) *graphql.Field {
+		return &graphql.Field{
+			Type: fieldType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				thunk := graphqlLoaderFromContext(p.Context).LoadRepositoryStats(p.Source.(Repo))
+				return func() (interface{}, error) {
+					result, err := thunk()
+					if err != nil {
+						return nil, err
+					}
+					return fn(result.(Stats)), nil
+				}, nil
+			},
+		}
+	}
+
+	repository := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Repository",
+		Fields: repoFields(graphql.Fields{
+			"starsCount": repositoryStatsField(graphql.Int, func(stats Stats) interface{} {
+				return stats.StarCount
+			}),
+			"license": repositoryStatsField(graphql.String, func(stats Stats) interface{} {
+				return stats.License
+			}),
+			"topics": repositoryStatsField(graphql.NewList(graphql.NewNonNull(graphql.String)), func(stats Stats) interface{} {
+				return stats.Topics
+			}),
+			"languages": repositoryStatsField(graphql.NewList(graphql.NewNonNull(language)), func(stats Stats) interface{} {
+				return sortedLanguages(stats.Languages)
+			}),
+		}),
+	})
+
+	pageInfo := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"endCursor":   &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	connection := func(name string, node *graphql.Object) *graphql.Object {
+		return graphql.NewObject(graphql.ObjectConfig{
+			Name: name,
+			Fields: graphql.Fields{
+				"totalCount": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"nodes":      &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(node))},
+				"pageInfo":   &graphql.Field{Type: graphql.NewNonNull(pageInfo)},
+			},
+		})
+	}
+
+	languageAggregate := graphql.NewObject(graphql.ObjectConfig{
+		Name: "LanguageAggregate",
+		Fields: graphql.Fields{
+			"name":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"repositories": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"bytes":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	licenseAggregate := graphql.NewObject(graphql.ObjectConfig{
+		Name: "LicenseAggregate",
+		Fields: graphql.Fields{
+			"key":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"repositories": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	languageCount := graphql.NewObject(graphql.ObjectConfig{
+		Name: "LanguageCount",
+		Fields: graphql.Fields{
+			"name":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"repositories": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	owner := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Owner",
+		Fields: graphql.Fields{
+			"login":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"type":         &graphql.Field{Type: graphql.String},
+			"repositories": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"starsCount": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Owner).StarCount, nil
+				},
+			},
+			"languages": &graphql.Field{
+				Type: graphql.NewList(graphql.NewNonNull(languageCount)),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return sortedLanguageCounts(p.Source.(Owner).Languages), nil
+				},
+			},
+		},
+	})
+
+	pageArgs := func(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+		args["first"] = &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: graphqlPageSize}
+		args["after"] = &graphql.ArgumentConfig{Type: graphql.String}
+		return args
+	}
+
+	statsQuery := func(p graphql.ResolveParams) ([]Stats, error) {
+		return graphqlLoaderFromContext(p.Context).Stats(graphqlParams(p.Args))
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"repositories": &graphql.Field{
+				Type:        connection("RepositoryConnection", repository),
+				Description: "the last repositories created, or the ones after `since`",
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"since":  &graphql.ArgumentConfig{Type: graphql.String},
+					"sortBy": &graphql.ArgumentConfig{Type: repositorySortField, DefaultValue: "NAME"},
+					"order":  &graphql.ArgumentConfig{Type: sortOrder, DefaultValue: "ASC"},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					params := url.Values{}
+					if since, ok := p.Args["since"].(string); ok && since != "" {
+						params.Set("since", since)
+					}
+
+					repos, err := fetchRepositories(p.Context, params)
+					if err != nil {
+						return nil, err
+					}
+
+					return paginate(sortRepos(repos, p.Args["sortBy"].(string), p.Args["order"].(string)), graphqlFirst(p.Args), graphqlAfter(p.Args))
+				},
+			},
+			"stats": &graphql.Field{
+				Type:        connection("StatsConnection", stats),
+				Description: "what /stats returns with the same filter",
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: statsFilter},
+					"sortBy": &graphql.ArgumentConfig{Type: statsSortField, DefaultValue: "NAME"},
+					"order":  &graphql.ArgumentConfig{Type: sortOrder, DefaultValue: "ASC"},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					results, err := statsQuery(p)
+					if err != nil {
+						return nil, err
+					}
+
+					return paginate(sortStats(results, p.Args["sortBy"].(string), p.Args["order"].(string)), graphqlFirst(p.Args), graphqlAfter(p.Args))
+				},
+			},
+			"repository": &graphql.Field{
+				Type: stats,
+				Args: graphql.FieldConfigArgument{
+					"owner": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"name":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					result := lookupStats(p.Context, []string{p.Args["owner"].(string) + "/" + p.Args["name"].(string)})[0]
+
+					switch result.Status {
+					case lookupStatusOK:
+						return *result.Stats, nil
+					case lookupStatusNotFound:
+						return nil, nil
+					default:
+						return nil, fmt.Errorf("%s", result.Error)
+					}
+				},
+			},
+			"languages": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(languageAggregate)),
+				Description: "languages of the repositories /stats returns with the same filter, the most used first",
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: statsFilter},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					results, err := statsQuery(p)
+					if err != nil {
+						return nil, err
+					}
+					return aggregateLanguages(results), nil
+				},
+			},
+			"licenses": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(licenseAggregate)),
+				Description: "licenses of the repositories /stats returns with the same filter, the most used first",
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: statsFilter},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					results, err := statsQuery(p)
+					if err != nil {
+						return nil, err
+					}
+					return aggregateLicenses(results), nil
+				},
+			},
+			"owners": &graphql.Field{
+				Type:        connection("OwnerConnection", owner),
+				Description: "owners of the repositories /stats returns with the same filter",
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: statsFilter},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					results, err := statsQuery(p)
+					if err != nil {
+						return nil, err
+					}
+					return paginate(aggregateOwners(results), graphqlFirst(p.Args), graphqlAfter(p.Args))
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// graphqlComplexity
+// How many github calls the operation may cause at most, the whole selection set is walked
+// so a field nested in lists counts once per object the lists may return
+// * repositories: the search, plus the stats of each repository returned if a field needs them
+// * stats, languages, licenses, owners: the search and the stats of the repositories found,
+// once per distinct filter since they share the same fetch
+// * repository: its stats
+func graphqlComplexity(schema graphql.Schema, document *ast.Document, operationName string, variables map[string]interface{}) int {
+	fragments := map[string]*ast.FragmentDefinition{}
+	var operation *ast.OperationDefinition
+
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if operation == nil && (operationName == "" || (definition.Name != nil && definition.Name.Value == operationName)) {
+				operation = definition
+			}
+		}
+	}
+
+	if operation == nil {
+		return 0
+	}
+
+	// fields of a selection set, through the fragments
+	var fields func(selectionSet *ast.SelectionSet, visited map[string]bool) []*ast.Field
+	fields = func(selectionSet *ast.SelectionSet, visited map[string]bool) []*ast.Field {
+		if selectionSet == nil {
+			return nil
+		}
+
+		var results []*ast.Field
+		for _, selection := range selectionSet.Selections {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				results = append(results, selection)
+			case *ast.InlineFragment:
+				results = append(results, fields(selection.SelectionSet, visited)...)
+			case *ast.FragmentSpread:
+				fragment, ok := fragments[selection.Name.Value]
+				if !ok || visited[selection.Name.Value] {
+					continue
+				}
+				visited[selection.Name.Value] = true
+				results = append(results, fields(fragment.SelectionSet, visited)...)
+			}
+		}
+		return results
+	}
+
+	argument := func(field *ast.Field, name string) ast.Value {
+		for _, arg := range field.Arguments {
+			if arg.Name.Value != name {
+				continue
+			}
+			if variable, ok := arg.Value.(*ast.Variable); ok {
+				value, ok := variables[variable.Name.Value]
+				if !ok || value == nil {
+					return nil
+				}
+				return &ast.StringValue{Value: fmt.Sprint(value)}
+			}
+			return arg.Value
+		}
+		return nil
+	}
+
+	intArgument := func(field *ast.Field, name string, fallback int) int {
+		value := argument(field, name)
+		if value == nil {
+			return fallback
+		}
+		count, err := strconv.Atoi(fmt.Sprint(value.GetValue()))
+		if err != nil || count < 0 {
+			return fallback
+		}
+		return count
+	}
+
+	searchCost := func(since ast.Value) int {
+		if since != nil {
+			return 1
+		}
+		return graphqlSearchCost
+	}
+
+	complexity := 0
+	filters := map[string]bool{}
+
+	// walk every selection set with the type it's selected on
+	// count is how many objects of the type the query may return, pageSize the items of the lists below
+	var walk func(parent *graphql.Object, selectionSet *ast.SelectionSet, count int, pageSize int)
+	walk = func(parent *graphql.Object, selectionSet *ast.SelectionSet, count int, pageSize int) {
+		statsLoaded := false
+
+		for _, field := range fields(selectionSet, map[string]bool{}) {
+			definition, ok := parent.Fields()[field.Name.Value]
+			if !ok {
+				// __typename or an unknown field, graphql.Do reports the latter
+				continue
+			}
+
+			switch parent.Name() + "." + field.Name.Value {
+			case "Query.repositories":
+				complexity += count * searchCost(argument(field, "since"))
+			case "Query.stats", "Query.languages", "Query.licenses", "Query.owners":
+				filter := argument(field, "filter")
+
+				key := ""
+				if filter != nil {
+					key = fmt.Sprint(printer.Print(filter))
+				}
+				if filters[key] {
+					break
+				}
+				filters[key] = true
+
+				var since ast.Value
+				if object, ok := filter.(*ast.ObjectValue); ok {
+					for _, objectField := range object.Fields {
+						if objectField.Name.Value == "since" {
+							since = objectField.Value
+						}
+					}
+				}
+
+				complexity += count * (searchCost(since) + graphqlPageSize*graphqlRepositoryCost)
+			case "Query.repository":
+				complexity += count * graphqlRepositoryCost
+			case "Repository.starsCount", "Repository.license", "Repository.topics", "Repository.languages":
+				// the loader fetches the stats of a repository once, whatever fields need them
+				if !statsLoaded {
+					complexity += count * graphqlRepositoryCost
+					statsLoaded = true
+				}
+			}
+
+			if field.SelectionSet == nil {
+				continue
+			}
+
+			fieldPageSize := pageSize
+			for _, arg := range definition.Args {
+				if arg.Name() == "first" {
+					fieldPageSize = intArgument(field, "first", graphqlPageSize)
+					if fieldPageSize > graphqlPageSize {
+						fieldPageSize = graphqlPageSize
+					}
+				}
+			}
+
+			// a list returns up to a page of the objects
+			fieldCount := count
+			fieldType := definition.Type
+			for {
+				switch wrapper := fieldType.(type) {
+				case *graphql.NonNull:
+					fieldType = wrapper.OfType
+					continue
+				case *graphql.List:
+					fieldCount *= fieldPageSize
+					fieldType = wrapper.OfType
+					continue
+				}
+				break
+			}
+
+			if object, ok := fieldType.(*graphql.Object); ok {
+				walk(object, field.SelectionSet, fieldCount, fieldPageSize)
+			}
+		}
+	}
+
+	walk(schema.QueryType(), operation.SelectionSet, 1, graphqlPageSize)
+
+	return complexity
+}
+
+// GraphQLRequest
+// A query sent as JSON with POST, or with the query parameters of a GET
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlHandler
+// Execute the query if the github calls it may cause don't exceed maxComplexity
+func graphqlHandler(schema graphql.Schema, maxComplexity int) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+		log := logger.Get(r.Context())
+
+		var request GraphQLRequest
+
+		if r.Method == http.MethodGet {
+			request.Query = r.URL.Query().Get("query")
+			request.OperationName = r.URL.Query().Get("operationName")
+			if variables := r.URL.Query().Get("variables"); variables != "" {
+				err := json.Unmarshal([]byte(variables), &request.Variables)
+				if err != nil {
+					writeJSONError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid variables: %v", err))
+					return nil
+				}
+			}
+		} else {
+			err := json.NewDecoder(r.Body).Decode(&request)
+			if err != nil {
+				writeJSONError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+				return nil
+			}
+		}
+
+		if request.Query == "" {
+			writeJSONError(w, r, http.StatusBadRequest, "no query")
+			return nil
+		}
+
+		status := http.StatusOK
+		var result *graphql.Result
+
+		// a query which doesn't parse is left to graphql.Do to report the errors
+		complexity := 0
+		document, err := parser.Parse(parser.ParseParams{Source: request.Query})
+		if err == nil {
+			complexity = graphqlComplexity(schema, document, request.OperationName, request.Variables)
+		}
+
+		if maxComplexity > 0 && complexity > maxComplexity {
+			status = http.StatusBadRequest
+			result = &graphql.Result{
+				Errors: []gqlerrors.FormattedError{
+					gqlerrors.NewFormattedError(fmt.Sprintf("query too complex: it may cause %d github calls, at most %d are allowed", complexity, maxComplexity)),
+				},
+			}
+		} else {
+			ctx := withCallerAuthorization(r)
+
+			result = graphql.Do(graphql.Params{
+				Schema:         schema,
+				RequestString:  request.Query,
+				VariableValues: request.Variables,
+				OperationName:  request.OperationName,
+				Context:        context.WithValue(ctx, graphqlLoaderKey{}, newGraphQLLoader(ctx)),
+			})
+		}
+
+		result.Extensions = map[string]interface{}{"complexity": complexity, "max_complexity": maxComplexity}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(status)
+
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			log.WithError(err).Error("Fail to encode JSON")
+		}
+
+		return nil
+	}
+}
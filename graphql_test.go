@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+func testGraphQLSchema(t *testing.T) graphql.Schema {
+	t.Helper()
+
+	schema, err := graphqlSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestGraphQLComplexity(t *testing.T) {
+	schema := testGraphQLSchema(t)
+
+	cases := []struct {
+		name       string
+		query      string
+		complexity int
+	}{
+		{"repositories without stats", `{ repositories(first: 10) { nodes { name } } }`, graphqlSearchCost},
+		{"repositories with stats", `{ repositories(first: 10) { nodes { starsCount languages { name } } } }`, graphqlSearchCost + 10*graphqlRepositoryCost},
+		{"fields of a fragment", `fragment R on Repository { license } { repositories(first: 5) { nodes { ...R topics } } }`, graphqlSearchCost + 5*graphqlRepositoryCost},
+		{"repositories after since", `{ repositories(since: "10") { nodes { license } } }`, 1 + graphqlPageSize*graphqlRepositoryCost},
+		{"same filter shared", `{ stats { totalCount } licenses { key } owners { nodes { login languages { name repositories } } } }`, graphqlSearchCost + graphqlPageSize*graphqlRepositoryCost},
+		{"distinct filters", `{ a: languages(filter: {language: "Go"}) { name } b: languages(filter: {language: "C"}) { name } }`, 2 * (graphqlSearchCost + graphqlPageSize*graphqlRepositoryCost)},
+		{"repository", `{ repository(owner: "a", name: "b") { starsCount } }`, graphqlRepositoryCost},
+	}
+	for _, c := range cases {
+		document, err := parser.Parse(parser.ParseParams{Source: c.query})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if complexity := graphqlComplexity(schema, document, "", nil); complexity != c.complexity {
+			t.Errorf("%s: expected %d, got %d", c.name, c.complexity, complexity)
+		}
+	}
+}
+
+func postGraphQL(t *testing.T, schema graphql.Schema, query string) map[string]interface{} {
+	t.Helper()
+
+	body, _ := json.Marshal(GraphQLRequest{Query: query})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body))).WithContext(testContext())
+	res := httptest.NewRecorder()
+	_ = graphqlHandler(schema, 0)(res, req, nil)
+
+	var result struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []interface{}          `json:"errors"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors %v", result.Errors)
+	}
+	return result.Data
+}
+
+func TestGraphQLLoaderBatch(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 5, Count: 10, Latency: 50 * time.Millisecond})
+	repositories := server.Repositories()
+
+	loader := newGraphQLLoader(testContext())
+
+	var thunks []func() (interface{}, error)
+	for _, repository := range repositories[:6] {
+		thunks = append(thunks, loader.LoadRepositoryStats(Repo{Owner: repository.Owner, Name: repository.Name}))
+	}
+	// queued twice, fetched once
+	thunks = append(thunks, loader.LoadRepositoryStats(Repo{Owner: repositories[0].Owner, Name: repositories[0].Name}))
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, len(thunks))
+	for i, thunk := range thunks {
+		wg.Add(1)
+		go func(i int, thunk func() (interface{}, error)) {
+			defer wg.Done()
+
+			result, err := thunk()
+			if err != nil {
+				t.Errorf("thunk %d: %v", i, err)
+			}
+			results[i] = result
+		}(i, thunk)
+	}
+
+	// the loader is not locked while the batch is fetched, a repository queued meanwhile goes to the next batch
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	next := loader.LoadRepositoryStats(Repo{Owner: repositories[9].Owner, Name: repositories[9].Name})
+	if waited := time.Since(start); waited > 20*time.Millisecond {
+		t.Errorf("queuing a repository waited %v for the batch being fetched", waited)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		stats, ok := result.(Stats)
+		expected := repositories[i%6]
+		if !ok || stats.StarCount != expected.Stars {
+			t.Errorf("thunk %d: expected the stats of %s, got %v", i, expected.FullName(), result)
+		}
+	}
+	if requests := server.Requests(); requests != 6*2 {
+		t.Errorf("expected the 6 repositories fetched once, got %d calls", requests)
+	}
+
+	result, err := next()
+	if stats, ok := result.(Stats); err != nil || !ok || stats.StarCount != repositories[9].Stars {
+		t.Errorf("expected the stats of %s, got %v, %v", repositories[9].FullName(), result, err)
+	}
+	if requests := server.Requests(); requests != 7*2 {
+		t.Errorf("expected the next batch fetched, got %d calls", requests)
+	}
+}
+
+func TestGraphQLOwnerLanguages(t *testing.T) {
+	newTestGithub(t, githubfake.Options{Seed: 6, Count: 200})
+	schema := testGraphQLSchema(t)
+
+	data := postGraphQL(t, schema, `{ owners(first: 100) { nodes { login languages { name repositories } } } }`)
+
+	owners := aggregateOwners(repositoryStatsOf(t))
+	byLogin := map[string]Owner{}
+	for _, owner := range owners {
+		byLogin[owner.Login] = owner
+	}
+
+	nodes := data["owners"].(map[string]interface{})["nodes"].([]interface{})
+	if len(nodes) == 0 {
+		t.Fatal("no owner returned")
+	}
+	for _, node := range nodes {
+		node := node.(map[string]interface{})
+		owner := byLogin[node["login"].(string)]
+
+		previous := -1
+		for _, language := range node["languages"].([]interface{}) {
+			language := language.(map[string]interface{})
+			count := int(language["repositories"].(float64))
+			if count != owner.Languages[language["name"].(string)] {
+				t.Errorf("%s: %v repositories using %v, expected %d", owner.Login, count, language["name"], owner.Languages[language["name"].(string)])
+			}
+			if previous >= 0 && count > previous {
+				t.Errorf("%s: the languages are not sorted by repositories", owner.Login)
+			}
+			previous = count
+		}
+	}
+}
+
+// repositoryStatsOf
+// The stats /stats returns, from the fake the test started
+func repositoryStatsOf(t *testing.T) []Stats {
+	t.Helper()
+
+	stats, err := fetchStats(testContext(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/statspb"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// methods anyone can call with no api key, like the http probes
+const grpcAPIKeyExemptPrefix = "/grpc.health.v1.Health/"
+
+// StatsServer
+// gRPC counterpart of /repos and /stats
+type StatsServer struct {
+	statspb.UnimplementedStatsServiceServer
+}
+
+func repoToProto(repo Repo) *statspb.Repo {
+	return &statspb.Repo{
+		Name:        repo.Name,
+		Url:         repo.Url,
+		Owner:       repo.Owner,
+		Description: repo.Description,
+	}
+}
+
+func statsToProto(stats Stats) *statspb.Stats {
+	languages := make(map[string]int64, len(stats.Languages))
+	for language, size := range stats.Languages {
+		languages[language] = int64(size)
+	}
+
+	return &statspb.Stats{
+		Repo:       repoToProto(stats.Repo),
+		StarsCount: int64(stats.StarCount),
+		Languages:  languages,
+		License:    stats.License,
+		Topics:     stats.Topics,
+	}
+}
+
+// statsFilterParams
+// The filter as the query parameters of /stats
+func statsFilterParams(filter *statspb.StatsFilter) url.Values {
+	params := url.Values{}
+	if filter == nil {
+		return params
+	}
+
+	if filter.Language != "" {
+		params.Set("language", filter.Language)
+	}
+	if filter.License != "" {
+		params.Set("license", filter.License)
+	}
+	if filter.Since != "" {
+		params.Set("since", filter.Since)
+	}
+	for _, topic := range filter.Topics {
+		params.Add("topic", topic)
+	}
+
+	switch filter.TopicMode {
+	case statspb.TopicMode_TOPIC_MODE_ALL:
+		params.Set("topic_mode", topicModeAll)
+	case statspb.TopicMode_TOPIC_MODE_ANY:
+		params.Set("topic_mode", topicModeAny)
+	}
+
+	return params
+}
+
+func (server *StatsServer) ListRepositories(ctx context.Context, request *statspb.ListRepositoriesRequest) (*statspb.ListRepositoriesResponse, error) {
+	params := url.Values{}
+	if request.Since != "" {
+		params.Set("since", request.Since)
+	}
+
+	repos, err := fetchRepositories(ctx, params)
+	if err != nil {
+		return nil, status.Error(grpccodes.Internal, err.Error())
+	}
+
+	response := &statspb.ListRepositoriesResponse{Repositories: make([]*statspb.Repo, 0, len(repos))}
+	for _, repo := range repos {
+		response.Repositories = append(response.Repositories, repoToProto(repo))
+	}
+
+	return response, nil
+}
+
+// StreamStats
+// Send the stats of each repository as soon as a worker is done with it
+// the repositories discarded by the filter or whose stats failed are not sent, like with /stats
+func (server *StatsServer) StreamStats(request *statspb.StreamStatsRequest, stream statspb.StatsService_StreamStatsServer) error {
+	ctx := stream.Context()
+	log := logger.Get(ctx)
+
+	params := statsFilterParams(request.Filter)
+
+	repositories, err := fetchGithubRepositories(ctx, params)
+	if err != nil {
+		return status.Error(grpccodes.Internal, err.Error())
+	}
+
+	var sendErr error
+	streamStatsTasks(ctx, params, repositories, func(stat WorkerStats) {
+		if stat.Err != nil {
+			if !errors.Is(stat.Err, WorkerDiscardRepository{}) {
+				log.Warnf("error fetching stats: %v", stat.Err)
+			}
+			return
+		}
+
+		// the remaining tasks are still waited for, they fail fast once the stream is gone
+		if sendErr != nil {
+			return
+		}
+		sendErr = stream.Send(&statspb.StreamStatsResponse{Stats: statsToProto(stat.Stats)})
+	})
+
+	return sendErr
+}
+
+// grpcContext
+// What the http middlewares and handlers put in the request context:
+// the logger, a server span, the caller's github token, and the api client once authenticated
+func grpcContext(ctx context.Context, log logrus.FieldLogger, method string, keys *APIKeyStore, limiter *APILimiter) (context.Context, trace.Span, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	log = log.WithField("grpc_method", method)
+
+	ctx, span := tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindServer))
+
+	if keys.Enabled() && !strings.HasPrefix(method, grpcAPIKeyExemptPrefix) {
+		client := keys.Authenticate(first("x-api-key"))
+		if client == "" {
+			return ctx, span, status.Error(grpccodes.Unauthenticated, "missing or invalid api key")
+		}
+
+		log = log.WithField("api_client", client)
+		ctx = context.WithValue(ctx, APIClientKey{}, client)
+
+		rateLimit := limiter.Take(client, time.Now())
+		if rateLimit.Exhausted {
+			log.Infof("api client over its %s", rateLimit.Resource)
+			return ctx, span, status.Errorf(grpccodes.ResourceExhausted, "%s exceeded, retry after %s", rateLimit.Resource, rateLimit.Reset.Format(time.RFC3339))
+		}
+	}
+
+	ctx = context.WithValue(ctx, Authorization{}, Authorization{Token: first("authorization")})
+
+	return logger.ToCtx(ctx, log), span, nil
+}
+
+func endGRPCSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// grpcStream
+// Server stream with the context built by grpcContext
+type grpcStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream grpcStream) Context() context.Context {
+	return stream.ctx
+}
+
+// newGRPCServer
+// The stats service, the health checking and the reflection services
+// the api keys and their limits are the same as the http api
+func newGRPCServer(log logrus.FieldLogger, keys *APIKeyStore, limiter *APILimiter) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (response any, err error) {
+			ctx, span, err := grpcContext(ctx, log, info.FullMethod, keys, limiter)
+			defer func() { endGRPCSpan(span, err) }()
+			if err != nil {
+				return nil, err
+			}
+
+			return handler(ctx, request)
+		}),
+		grpc.StreamInterceptor(func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+			ctx, span, err := grpcContext(stream.Context(), log, info.FullMethod, keys, limiter)
+			defer func() { endGRPCSpan(span, err) }()
+			if err != nil {
+				return err
+			}
+
+			return handler(srv, grpcStream{ServerStream: stream, ctx: ctx})
+		}),
+	)
+
+	statspb.RegisterStatsServiceServer(server, &StatsServer{})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(statspb.StatsService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	reflection.Register(server)
+
+	return server
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+	"github.com/Scalingo/sclng-backend-test-v1/statspb"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestGRPCClient
+// A gRPC server over an in memory connection until the end of the test, and a client connected to it
+func newTestGRPCClient(t *testing.T, keys *APIKeyStore) *grpc.ClientConn {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer(logger.Default(), keys, newAPILimiter(0, 0))
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestGRPCStreamStats(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 9, FirstID: 1, Count: 30})
+	client := statspb.NewStatsServiceClient(newTestGRPCClient(t, &APIKeyStore{stored: map[string]storedAPIKey{}}))
+
+	cases := []struct {
+		name     string
+		filter   *statspb.StatsFilter
+		expected func(repository githubfake.Repository) bool
+	}{
+		{name: "all", filter: &statspb.StatsFilter{Since: "0"}, expected: func(githubfake.Repository) bool { return true }},
+		{name: "language", filter: &statspb.StatsFilter{Since: "0", Language: "Go"}, expected: func(repository githubfake.Repository) bool {
+			_, ok := repository.Languages["Go"]
+			return ok
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stream, err := client.StreamStats(testContext(), &statspb.StreamStatsRequest{Filter: c.filter})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			received := []string{}
+			for {
+				response, err := stream.Recv()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				received = append(received, response.Stats.Repo.Owner+"/"+response.Stats.Repo.Name)
+			}
+			sort.Strings(received)
+
+			expected := []string{}
+			for _, repository := range server.Repositories() {
+				if c.expected(repository) {
+					expected = append(expected, repository.FullName())
+				}
+			}
+			sort.Strings(expected)
+
+			if len(expected) == 0 || len(received) != len(expected) {
+				t.Fatalf("expected the stats of %d repositories, got %d", len(expected), len(received))
+			}
+			for i := range expected {
+				if received[i] != expected[i] {
+					t.Errorf("expected %s, got %s", expected[i], received[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGRPCStreamStatsCancel(t *testing.T) {
+	latency := 100 * time.Millisecond
+	server := newTestGithub(t, githubfake.Options{Seed: 10, FirstID: 1, Count: 100, Latency: latency})
+	client := statspb.NewStatsServiceClient(newTestGRPCClient(t, &APIKeyStore{stored: map[string]storedAPIKey{}}))
+
+	ctx, cancel := context.WithCancel(testContext())
+	defer cancel()
+
+	stream, err := client.StreamStats(ctx, &statspb.StreamStatsRequest{Filter: &statspb.StatsFilter{Since: "0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	// the stats sent before the cancel may still be read
+	for {
+		_, err := stream.Recv()
+		if err == nil {
+			continue
+		}
+		if status.Code(err) != grpccodes.Canceled {
+			t.Errorf("expected the stream canceled, got %v", err)
+		}
+		break
+	}
+
+	// the calls of the workers busy when the stream was canceled end, the tasks queued make none
+	requests := server.Requests()
+	for {
+		time.Sleep(3 * latency)
+		if server.Requests() == requests {
+			break
+		}
+		requests = server.Requests()
+	}
+	if all := 1 + 2*100; requests >= all {
+		t.Errorf("expected the canceled stream to stop the github calls, %d calls were made out of %d", requests, all)
+	}
+
+	// the workers are free for the next stream
+	repositories := server.Repositories()
+	since := strconv.Itoa(repositories[len(repositories)-2].ID)
+	stream, err = client.StreamStats(testContext(), &statspb.StreamStatsRequest{Filter: &statspb.StatsFilter{Since: since}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response, err := stream.Recv(); err != nil || response.Stats.Repo.Name != repositories[len(repositories)-1].Name {
+		t.Errorf("expected the stats of the last repository, got %v: %v", response, err)
+	}
+}
+
+func TestGRPCAPIKeys(t *testing.T) {
+	newTestGithub(t, githubfake.Options{Seed: 11, FirstID: 1, Count: 5})
+
+	keys := &APIKeyStore{stored: map[string]storedAPIKey{}}
+	keys.SetConfigured([]APIKey{configuredAPIKey("reader", "reader-key")}, nil)
+	conn := newTestGRPCClient(t, keys)
+	client := statspb.NewStatsServiceClient(conn)
+
+	_, err := client.ListRepositories(testContext(), &statspb.ListRepositoriesRequest{Since: "0"})
+	if status.Code(err) != grpccodes.Unauthenticated {
+		t.Errorf("expected a call without api key refused, got %v", err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(testContext(), "x-api-key", "reader-key")
+	response, err := client.ListRepositories(ctx, &statspb.ListRepositoriesRequest{Since: "0"})
+	if err != nil || len(response.Repositories) != 5 {
+		t.Errorf("expected the 5 repositories, got %v: %v", response, err)
+	}
+
+	// the health checks need no key
+	health, err := healthpb.NewHealthClient(conn).Check(testContext(), &healthpb.HealthCheckRequest{Service: statspb.StatsService_ServiceDesc.ServiceName})
+	if err != nil || health.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected the service serving, got %v: %v", health, err)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Scalingo/go-handlers"
+	"github.com/Scalingo/go-utils/logger"
+)
+
+// version of the service, set at build time with
+// go build -ldflags "-X main.version=x.y.z"
+var version = "dev"
+
+var startedAt = time.Now()
+
+// last time the repositories were successfully fetched from github
+var lastIngestionAt atomic.Int64
+
+func markIngestion() {
+	lastIngestionAt.Store(time.Now().UnixNano())
+}
+
+// GithubRateLimit
+// Last rate limit reported by github in the response headers
+type GithubRateLimit struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var githubRateLimit struct {
+	sync.Mutex
+	GithubRateLimit
+}
+
+// recordGithubRateLimit
+// Keep track of the rate limit headers returned by github
+// responses without those headers are ignored
+func recordGithubRateLimit(res *http.Response) {
+	if res == nil {
+		return
+	}
+
+	remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+
+	limit, _ := strconv.Atoi(res.Header.Get("X-RateLimit-Limit"))
+	reset, _ := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64)
+
+	githubRateLimit.Lock()
+	defer githubRateLimit.Unlock()
+
+	githubRateLimit.GithubRateLimit = GithubRateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     time.Unix(reset, 0),
+		UpdatedAt: time.Now(),
+	}
+}
+
+func currentGithubRateLimit() GithubRateLimit {
+	githubRateLimit.Lock()
+	defer githubRateLimit.Unlock()
+
+	return githubRateLimit.GithubRateLimit
+}
+
+// HealthCheck
+// A dependency checked by /readyz
+// Check returns an error when the dependency is not usable
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// readinessChecks
+// Build the list of checks run by /readyz
+// each check reads the configuration when it runs, so a reload applies to it
+func readinessChecks() []HealthCheck {
+	return []HealthCheck{
+		{Name: "workers", Check: checkWorkers},
+		{Name: "queue", Check: checkQueue},
+		{Name: "github_rate_limit", Check: checkGithubRateLimit},
+		{Name: "store", Check: func(ctx context.Context) error { return repositoryStore.Ping(ctx) }},
+	}
+}
+
+func checkWorkers(context.Context) error {
+	running, expected := workerStatsRunning.Load(), int32(currentConfig().WorkerCount)
+	if running < expected {
+		return fmt.Errorf("%d/%d stats workers running", running, expected)
+	}
+	return nil
+}
+
+func checkQueue(context.Context) error {
+	usage := float64(len(workerStatsTasks)) / float64(cap(workerStatsTasks))
+	if usage >= currentConfig().HealthQueueSaturation {
+		return fmt.Errorf("stats queue saturated: %d/%d tasks", len(workerStatsTasks), cap(workerStatsTasks))
+	}
+	return nil
+}
+
+func checkGithubRateLimit(context.Context) error {
+	minimum := currentConfig().HealthMinRateLimit
+
+	// with server side tokens what matters is the budget left across the pool
+	if budget, ok := githubCredentials.Budget(); ok {
+		if budget < minimum {
+			return fmt.Errorf("github tokens budget too low: %d calls remaining", budget)
+		}
+		return nil
+	}
+
+	rateLimit := currentGithubRateLimit()
+
+	// nothing known yet or the window has been reset since
+	if rateLimit.UpdatedAt.IsZero() || time.Now().After(rateLimit.Reset) {
+		return nil
+	}
+
+	if rateLimit.Remaining < minimum {
+		return fmt.Errorf("github rate limit too low: %d/%d remaining until %s", rateLimit.Remaining, rateLimit.Limit, rateLimit.Reset.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func healthzHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+	log := logger.Get(r.Context())
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+	return nil
+}
+
+// readyzHandlerGet
+// Run every readiness check, respond 503 if any of them fails
+func readyzHandlerGet(checks []HealthCheck) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+		log := logger.Get(r.Context())
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		status := http.StatusOK
+		results := map[string]string{}
+
+		for _, check := range checks {
+			if err := check.Check(ctx); err != nil {
+				status = http.StatusServiceUnavailable
+				results[check.Name] = err.Error()
+				continue
+			}
+			results[check.Name] = "ok"
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(status)
+
+		err := json.NewEncoder(w).Encode(map[string]any{
+			"status": http.StatusText(status),
+			"checks": results,
+		})
+		if err != nil {
+			log.WithError(err).Error("Fail to encode JSON")
+		}
+		return nil
+	}
+}
+
+// statusHandlerGet
+// Some information about the running service
+func statusHandlerGet() handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+		log := logger.Get(r.Context())
+
+		status := map[string]any{
+			"version":             version,
+			"started_at":          startedAt,
+			"uptime":              time.Since(startedAt).Round(time.Second).String(),
+			"config":              redactConfig(runningConfig.Load()),
+			"workers_running":     workerStatsRunning.Load(),
+			"queue_length":        len(workerStatsTasks),
+			"queue_capacity":      cap(workerStatsTasks),
+			"stored_repositories": repositoryStore.Len(),
+			"github_rate_limit":   currentGithubRateLimit(),
+			"github_tokens":       githubCredentials.Status(),
+		}
+
+		if info, ok := debug.ReadBuildInfo(); ok {
+			status["go_version"] = info.GoVersion
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" {
+					status["revision"] = setting.Value
+				}
+			}
+		}
+
+		if at := lastIngestionAt.Load(); at != 0 {
+			status["last_ingestion_at"] = time.Unix(0, at)
+		} else {
+			status["last_ingestion_at"] = nil
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err := json.NewEncoder(w).Encode(status)
+		if err != nil {
+			log.WithError(err).Error("Fail to encode JSON")
+		}
+		return nil
+	}
+}
+
+// redactConfig
+// Returns the config as a map, fields tagged with `secret:"true"` are redacted
+func redactConfig(cfg *Config) map[string]any {
+	result := map[string]any{}
+
+	value := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+
+		name := field.Tag.Get("envconfig")
+		if name == "" {
+			name = field.Name
+		}
+
+		if field.Tag.Get("secret") == "true" {
+			if !value.Field(i).IsZero() {
+				result[name] = "[REDACTED]"
+			} else {
+				result[name] = ""
+			}
+			continue
+		}
+
+		result[name] = value.Field(i).Interface()
+	}
+
+	return result
+}
@@ -1,35 +1,167 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
 // HttpRequest
 // Little helper to make some http requests
 // just set the method, url, headers and query parameters of the request
+// Body, if set, is sent encoded in JSON
 type HttpRequest struct {
 	Method  string
 	Url     string
 	Headers map[string]string
 	Query   map[string]string
+	Body    any
+}
+
+// HttpRequestError
+// Returned by Do when github responds with an unexpected status code
+type HttpRequestError struct {
+	Method     string
+	Url        string
+	Query      string
+	StatusCode int
+	Body       string
+	// github refused the call because the rate limit is exhausted
+	RateLimited bool
+	// how long github asks to wait before calling again, when RateLimited
+	RetryAfter time.Duration
+}
+
+func (err *HttpRequestError) Error() string {
+	return fmt.Sprintf("request %s %s?%s failed: %s.", err.Method, err.Url, err.Query, err.Body)
+}
+
+// isNotFound
+// true if the error comes from a github response 404
+func isNotFound(err error) bool {
+	var httpErr *HttpRequestError
+	return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound
+}
+
+// isRateLimited
+// true if the error comes from github refusing a call past the rate limit
+func isRateLimited(err error) bool {
+	var httpErr *HttpRequestError
+	return errors.As(err, &httpErr) && httpErr.RateLimited
+}
+
+// githubRetryAfter
+// The wait github asks for in a refused response: its Retry-After or the time left until X-RateLimit-Reset
+func githubRetryAfter(res *http.Response) time.Duration {
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		if wait := time.Until(time.Unix(reset, 0)); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// writeGithubRateLimited
+// Respond 503 to a request github refused past its rate limit, with a Retry-After of at least a second
+func writeGithubRateLimited(w http.ResponseWriter, r *http.Request, err error) {
+	retryAfter := time.Second
+	var httpErr *HttpRequestError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > retryAfter {
+		retryAfter = httpErr.RetryAfter
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeJSONError(w, r, http.StatusServiceUnavailable, "github rate limit exceeded, retry later")
+}
+
+// githubClient
+// Client of the github calls, its transport records or replays them with GITHUB_CASSETTE_MODE
+var githubClient = http.DefaultClient
+
+// in flight GET requests, identical requests made at the same time share the same upstream call
+var httpRequestGroup singleflight.Group
+
+// httpResponse
+// Response of an upstream call with its body already read
+// so it can be shared between the requests coalesced together
+type httpResponse struct {
+	res  *http.Response
+	body []byte
 }
 
 // Do
 // Execute the http request
-// Set Authorization header if provided in the context
+// Set Authorization header with one of the server side tokens
+// or with the caller's token found in the context if allowed
+// The call is traced as a client span, child of the span found in the context
+// GET requests identical to one already in flight wait for its response instead of calling github again
+// and are revalidated with the ETag of their last response, see conditionalRoundTrip
+// The url must be one of the configured github endpoints, see checkGithubURL
 // Then based on the returned status code
 // 200: unmarshal the response.Body into the `body` argument
+// 304: nothing to unmarshal, the caller sent an If-None-Match header
 // any: read and returns the response.Body as an error
-func (request *HttpRequest) Do(ctx context.Context, body any) (*http.Response, error) {
-	req, err := http.NewRequest(request.Method, request.Url, nil)
+func (request *HttpRequest) Do(ctx context.Context, body any) (res *http.Response, err error) {
+	ctx, span := tracer.Start(ctx, fmt.Sprintf("HTTP %s", request.Method),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethod(request.Method),
+			semconv.HTTPURL(request.Url),
+		),
+	)
+	defer func() {
+		if res != nil {
+			span.SetAttributes(semconv.HTTPStatusCode(res.StatusCode))
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	// the url may come from a github response, it must not send the call and its token elsewhere
+	err = checkGithubURL(request.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	var reqBody io.Reader
+	if request.Body != nil {
+		payload, err := json.Marshal(request.Body)
+		if err != nil {
+			return nil, fmt.Errorf("json.Marshal failed: %w", err)
+		}
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, request.Method, request.Url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("http.NewRequest failed: %w", err)
 	}
 
+	if request.Body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
 	for key, value := range request.Headers {
 		req.Header.Set(key, value)
 	}
@@ -44,23 +176,134 @@ func (request *HttpRequest) Do(ctx context.Context, body any) (*http.Response, e
 		req.URL.RawQuery = params.Encode()
 	}
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return res, fmt.Errorf("http.DefaultClient.Do failed: %w", err)
+	var response *httpResponse
+
+	if request.Method == http.MethodGet {
+		// the caller's token is part of the key, its response may differ from the server side tokens one
+		key := fmt.Sprintf("%s %s %s %v", req.Method, req.URL.String(), githubCredentials.Identity(ctx), req.Header)
+
+		results := httpRequestGroup.DoChan(key, func() (any, error) {
+			// the shared call must not be cancelled because the first caller went away
+			// GITHUB_REQUEST_TIMEOUT bounds it instead
+			ctx, cancel := context.WithTimeout(detachedContext{ctx}, currentConfig().GithubRequestTimeout)
+			defer cancel()
+
+			cacheKey := fmt.Sprintf("%s %s %s", req.URL.String(), githubCredentials.Identity(ctx), req.Header.Get("Accept"))
+			return conditionalRoundTrip(req.WithContext(ctx), cacheKey)
+		})
+
+		// a caller going away stops waiting, the call goes on for the others
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case result := <-results:
+			if result.Err != nil {
+				return nil, result.Err
+			}
+			if result.Shared {
+				span.SetAttributes(attribute.Bool("http.coalesced", true))
+			}
+
+			response = result.Val.(*httpResponse)
+		}
+	} else {
+		response, err = roundTrip(req)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	res = response.res
+
 	switch res.StatusCode {
 	case 200:
-		if err := json.NewDecoder(res.Body).Decode(body); err != nil {
-			_, _ = io.ReadAll(res.Body)
-
-			return res, fmt.Errorf("json.NewDecoder failed: %w", err)
+		if err := json.Unmarshal(response.body, body); err != nil {
+			return res, fmt.Errorf("json.Unmarshal failed: %w", err)
 		}
+	case http.StatusNotModified:
 	default:
-		bytes, _ := io.ReadAll(res.Body)
-
-		return res, fmt.Errorf("request %s %s?%s failed: %s.", request.Method, request.Url, req.URL.RawQuery, string(bytes))
+		httpErr := &HttpRequestError{
+			Method:     request.Method,
+			Url:        request.Url,
+			Query:      req.URL.RawQuery,
+			StatusCode: res.StatusCode,
+			Body:       string(response.body),
+			// the primary rate limit empties X-RateLimit-Remaining, the secondary one sends a Retry-After
+			RateLimited: (res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusTooManyRequests) &&
+				(res.Header.Get("X-RateLimit-Remaining") == "0" || res.Header.Get("Retry-After") != ""),
+		}
+		if httpErr.RateLimited {
+			httpErr.RetryAfter = githubRetryAfter(res)
+		}
+		return res, httpErr
 	}
 
 	return res, nil
 }
+
+// roundTrip
+// Authorize and send the request to github then read the whole response
+func roundTrip(req *http.Request) (*httpResponse, error) {
+	token, err := githubCredentials.Authorize(req.Context(), req)
+	if err != nil {
+		return nil, fmt.Errorf("githubCredentials.Authorize failed: %w", err)
+	}
+
+	res, err := githubClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("githubClient.Do failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	recordGithubRateLimit(res)
+	githubCredentials.Record(token, res)
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response body failed: %w", err)
+	}
+
+	return &httpResponse{res: res, body: body}, nil
+}
+
+// detachedContext
+// Keep the values of a context (span, caller's token) but not its cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (ctx detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (ctx detachedContext) Done() <-chan struct{}       { return nil }
+func (ctx detachedContext) Err() error                  { return nil }
+func (ctx detachedContext) Value(key any) any           { return ctx.parent.Value(key) }
+
+// newGithubPaginator
+// The pages of a github list starting at firstURL with the query parameters, each page fetched with HttpRequest.Do
+// the next pages are the `next` links github returns, they have the query parameters already
+func newGithubPaginator[T any](firstURL string, query map[string]string, maxPages int) *github.Paginator[T] {
+	if len(query) > 0 {
+		params := url.Values{}
+		for key, value := range query {
+			params.Set(key, value)
+		}
+		firstURL += "?" + params.Encode()
+	}
+
+	return github.NewPaginator(firstURL, maxPages, func(ctx context.Context, pageURL string) ([]T, *http.Response, error) {
+		httpRequest := HttpRequest{
+			Method: http.MethodGet,
+			Url:    pageURL,
+			Headers: map[string]string{
+				"Accept": "application/vnd.github.v3+json",
+			},
+		}
+
+		var items []T
+		res, err := httpRequest.Do(ctx, &items)
+		if err != nil {
+			return nil, res, err
+		}
+
+		return items, res, nil
+	})
+}
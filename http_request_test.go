@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+func TestHttpRequestCancelledCaller(t *testing.T) {
+	options := githubfake.DefaultOptions()
+	options.Latency = 300 * time.Millisecond
+	server := newTestGithub(t, options)
+
+	request := HttpRequest{Method: http.MethodGet, Url: server.URL + "/repositories"}
+
+	// a call in flight the next caller is coalesced with
+	shared := make(chan error, 1)
+	go func() {
+		var repositories []map[string]any
+		_, err := request.Do(context.Background(), &repositories)
+		shared <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(testContext(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	var repositories []map[string]any
+	_, err := request.Do(ctx, &repositories)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline of the caller, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("the cancelled caller waited %s for the shared call", elapsed)
+	}
+
+	if err := <-shared; err != nil {
+		t.Errorf("the shared call failed for the caller still waiting: %v", err)
+	}
+	if requests := server.Requests(); requests != 1 {
+		t.Errorf("expected a single call to github, got %d", requests)
+	}
+}
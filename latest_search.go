@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// repositories returned per page of /repositories
+const githubRepositoriesPageSize = 100
+
+// id above every github repository, where the search starts from
+const githubRepositoriesMaxID = 10000000000
+
+// RepositoryPageFetcher
+// One page of the repositories with an id above `since`, ordered by id like /repositories?since=
+type RepositoryPageFetcher interface {
+	FetchSince(ctx context.Context, since int) ([]github.Repository, error)
+}
+
+// githubPageFetcher
+// The pages of the github /repositories endpoint
+type githubPageFetcher struct {
+	request HttpRequest
+}
+
+func (fetcher githubPageFetcher) FetchSince(ctx context.Context, since int) ([]github.Repository, error) {
+	request := fetcher.request
+	request.Query = map[string]string{"since": strconv.Itoa(since)}
+
+	// a new slice each time, a page must never be decoded into the previous one
+	var repositories []github.Repository
+	_, err := request.Do(ctx, &repositories)
+	if err != nil {
+		return nil, err
+	}
+
+	return repositories, nil
+}
+
+// LatestSearch
+// Find the Count repositories with the highest ids with pages of PageSize repositories
+//
+// With f(since) the number of repositories above since, capped to the page size, f only decreases as since grows
+// the search keeps low with f(low) >= Count and high with f(high) < Count and narrows the interval between them
+// once high = low + 1, low + 1 is the id of a repository, so there are exactly Count repositories above low
+// and the page of low is the answer
+//
+// Each round fetches Parallelism pages at once, evenly spread in the interval, which divides it by Parallelism + 1
+// Low and High are hints, both are checked: low is reset to 0 if it has less than Count repositories above it,
+// high is moved up, twice further each round, while it has Count repositories above it
+type LatestSearch struct {
+	Fetcher  RepositoryPageFetcher
+	Count    int
+	PageSize int
+	// pages fetched at once, 1 is a binary search
+	Parallelism int
+	Low         int
+	High        int
+}
+
+// LatestResult
+// The repositories found, ordered by id, the number of pages fetched to find them
+// and the number of rounds, each round being a single round trip to github
+type LatestResult struct {
+	Repositories []github.Repository
+	Calls        int
+	Rounds       int
+}
+
+// latestSearchRounds
+// Most rounds needed to narrow an interval of size ids down to 1 with the given parallelism
+func latestSearchRounds(size, parallelism int) int {
+	rounds := 0
+	for size > 1 {
+		size = (size + parallelism) / (parallelism + 1)
+		rounds++
+	}
+	return rounds
+}
+
+// latestSearchMaxCalls
+// Most calls the search makes when the highest id is maxID, whatever its hints:
+// either the low hint is wrong, found in the first round, and [0, low] is narrowed
+// or high is moved up until it is above maxID and the interval it ends with is narrowed
+// a round fetches at most max(Parallelism, 2) pages, plus the page of low if it was never fetched
+func latestSearchMaxCalls(search LatestSearch, maxID int) int {
+	parallelism := search.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	perRound := parallelism
+	if perRound < 2 {
+		perRound = 2
+	}
+
+	wrongLow := 0
+	if search.Low > 0 {
+		wrongLow = 1 + latestSearchRounds(search.Low, parallelism)
+	}
+
+	low, high := search.Low, search.High
+	rounds := 1
+	for high < maxID {
+		low, high = high, high+2*(high-low)
+		rounds++
+	}
+	rounds += latestSearchRounds(high-low, parallelism)
+
+	if wrongLow > rounds {
+		rounds = wrongLow
+	}
+
+	return rounds*perRound + 1
+}
+
+// Find
+// The Count repositories with the highest ids, all of them if there are less
+func (search LatestSearch) Find(ctx context.Context) (LatestResult, error) {
+	if search.Count <= 0 || search.Count > search.PageSize {
+		return LatestResult{}, fmt.Errorf("the search needs a count between 1 and the page size %d, got %d", search.PageSize, search.Count)
+	}
+	if search.Low < 0 || search.High <= search.Low {
+		return LatestResult{}, fmt.Errorf("the search needs 0 <= low < high, got %d and %d", search.Low, search.High)
+	}
+
+	parallelism := search.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	var result LatestResult
+
+	// done returns the answer when a page holds every repository above its since
+	// and at least Count of them, no need to look further
+	done := func(page []github.Repository) bool {
+		if len(page) < search.Count || len(page) >= search.PageSize {
+			return false
+		}
+		result.Repositories = page[len(page)-search.Count:]
+		return true
+	}
+
+	low, high := search.Low, search.High
+	// 0 needs no check, less than Count repositories above it means there are less than Count repositories
+	lowChecked, highChecked := low == 0, false
+	var lowPage []github.Repository
+
+	for !lowChecked || !highChecked || high-low > 1 {
+		previousLow, previousHigh := low, high
+
+		var points []int
+		if !lowChecked {
+			points = append(points, low)
+		}
+
+		slots := parallelism - len(points)
+		if slots < 1 {
+			slots = 1
+		}
+		if highChecked {
+			// inside the interval, low and high are known already
+			for i := 1; i <= slots; i++ {
+				point := low + int(int64(high-low)*int64(i)/int64(slots+1))
+				if point > low && point < high && (len(points) == 0 || point != points[len(points)-1]) {
+					points = append(points, point)
+				}
+			}
+		} else {
+			// up to high which must be checked
+			for i := 1; i <= slots; i++ {
+				point := low + int(int64(high-low)*int64(i)/int64(slots))
+				if point > low && (len(points) == 0 || point != points[len(points)-1]) {
+					points = append(points, point)
+				}
+			}
+		}
+
+		pages, err := search.probeRound(ctx, result.Calls, points)
+		result.Calls += len(points)
+		result.Rounds++
+		if err != nil {
+			return LatestResult{}, err
+		}
+
+		for i, point := range points {
+			if done(pages[i]) {
+				return result, nil
+			}
+
+			switch {
+			case len(pages[i]) >= search.Count && point >= low:
+				low, lowPage, lowChecked = point, pages[i], true
+			case len(pages[i]) >= search.Count:
+			case !lowChecked && point == low:
+				// wrong hint, the answer is below it
+				low, high, lowChecked, highChecked = 0, point, true, true
+			case !highChecked || point < high:
+				high, highChecked = point, true
+			}
+		}
+
+		// every page fetched up to high is full, the newest repositories are further
+		if !highChecked {
+			high = low + 2*(previousHigh-previousLow)
+		}
+
+		// the interval can only be empty if repositories were created during the round
+		if high <= low {
+			high, highChecked = low+1, false
+		}
+	}
+
+	if lowPage == nil {
+		pages, err := search.probeRound(ctx, result.Calls, []int{low})
+		result.Calls++
+		result.Rounds++
+		if err != nil {
+			return LatestResult{}, err
+		}
+		lowPage = pages[0]
+	}
+
+	// less than Count repositories exist
+	if len(lowPage) < search.Count {
+		result.Repositories = lowPage
+		return result, nil
+	}
+
+	result.Repositories = lowPage[:search.Count]
+	return result, nil
+}
+
+// probeRound
+// Fetch the pages of the points at once, the round fails if one of them fails
+func (search LatestSearch) probeRound(ctx context.Context, calls int, points []int) ([][]github.Repository, error) {
+	pages := make([][]github.Repository, len(points))
+	errs := make([]error, len(points))
+
+	var wg sync.WaitGroup
+	for i, point := range points {
+		wg.Add(1)
+		go func(i, point int) {
+			defer wg.Done()
+			pages[i], errs[i] = search.probe(ctx, calls+i+1, point)
+		}(i, point)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pages, nil
+}
+
+// probe
+// One step of the search, traced in its own span
+func (search LatestSearch) probe(ctx context.Context, try int, since int) ([]github.Repository, error) {
+	ctx, span := tracer.Start(ctx, "github.repositories.probe", trace.WithAttributes(
+		attribute.Int("github.search.try", try),
+		attribute.Int("github.search.since", since),
+	))
+	defer span.End()
+
+	repositories, err := search.Fetcher.FetchSince(ctx, since)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("list repositories since %d failed: %w", since, err)
+	}
+
+	span.SetAttributes(attribute.Int("github.search.results", len(repositories)))
+
+	return repositories, nil
+}
+
+// searchLatestRepositories
+// The last 100 repositories, searched from the interval the estimator expects them in
+// with as many pages at once as the rate limit left allows
+func searchLatestRepositories(ctx context.Context, fetcher RepositoryPageFetcher, estimator *LatestSearchEstimator, now time.Time) (LatestResult, error) {
+	span := trace.SpanFromContext(ctx)
+
+	cfg := currentConfig()
+
+	low, high := estimator.Hints(now)
+	search := LatestSearch{
+		Fetcher:     fetcher,
+		Count:       githubRepositoriesPageSize,
+		PageSize:    githubRepositoriesPageSize,
+		Parallelism: budgetedParallelism(cfg.SearchParallelism, high-low, currentGithubRateLimit(), cfg.SearchRateLimitShare, now),
+		Low:         low,
+		High:        high,
+	}
+	span.SetAttributes(
+		attribute.Int("github.search.parallelism", search.Parallelism),
+		attribute.Int("github.search.low", low),
+		attribute.Int("github.search.high", high),
+	)
+
+	result, err := search.Find(ctx)
+	if err != nil {
+		return LatestResult{}, err
+	}
+
+	estimator.Record(result.Repositories, now)
+
+	return result, nil
+}
+
+// ids added above the estimated highest id, the estimate is never exact
+const latestSearchSlack = 1000
+
+// budgetedParallelism
+// The parallelism lowered until the search of an interval of size ids fits in its share of the rate limit left
+// the search is at least a binary search, even with no budget left
+func budgetedParallelism(parallelism, size int, rateLimit GithubRateLimit, share float64, now time.Time) int {
+	if rateLimit.UpdatedAt.IsZero() || now.After(rateLimit.Reset) {
+		return parallelism
+	}
+
+	budget := int(float64(rateLimit.Remaining) * share)
+	for parallelism > 1 && parallelism*latestSearchRounds(size, parallelism)+1 > budget {
+		parallelism--
+	}
+
+	return parallelism
+}
+
+// latestSearchObservation
+// The answer of a search: the since of its page and the highest id, found when the newest repository was just created
+type latestSearchObservation struct {
+	Low   int
+	MaxID int
+	At    time.Time
+}
+
+// LatestSearchEstimator
+// Estimate the interval of the next search from the previous ones
+// ids grow with the creation time of the repositories, at a rate measured between two searches
+type LatestSearchEstimator struct {
+	mu       sync.Mutex
+	previous *latestSearchObservation
+	last     *latestSearchObservation
+}
+
+var latestSearchEstimator = &LatestSearchEstimator{}
+
+// Record
+// Keep the answer of a search, the rate is measured over at least a minute
+func (estimator *LatestSearchEstimator) Record(repositories []github.Repository, at time.Time) {
+	if len(repositories) == 0 {
+		return
+	}
+
+	observation := &latestSearchObservation{
+		Low:   int(repositories[0].Id) - 1,
+		MaxID: int(repositories[len(repositories)-1].Id),
+		At:    at,
+	}
+
+	estimator.mu.Lock()
+	defer estimator.mu.Unlock()
+
+	if estimator.last == nil || at.Sub(estimator.last.At) >= time.Minute {
+		estimator.previous = estimator.last
+	}
+	estimator.last = observation
+}
+
+// Hints
+// The low and high bounds to start the search with
+// low is the since of the last answer, high the highest id expected now plus half its growth and some slack
+// with no rate measured yet, high is above every github repository
+func (estimator *LatestSearchEstimator) Hints(now time.Time) (int, int) {
+	estimator.mu.Lock()
+	defer estimator.mu.Unlock()
+
+	if estimator.last == nil {
+		return 0, githubRepositoriesMaxID
+	}
+
+	low := estimator.last.Low
+	if estimator.previous == nil || !estimator.last.At.After(estimator.previous.At) {
+		return low, githubRepositoriesMaxID
+	}
+
+	rate := float64(estimator.last.MaxID-estimator.previous.MaxID) / estimator.last.At.Sub(estimator.previous.At).Seconds()
+	growth := int(rate * now.Sub(estimator.last.At).Seconds())
+	if growth < 0 {
+		growth = 0
+	}
+
+	return low, estimator.last.MaxID + growth + growth/2 + latestSearchSlack
+}
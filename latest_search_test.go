@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+// TestLatestSearchProperties
+// Search the last repositories of fakes with random sparse id spaces and random hints, right or wrong:
+// the search must find exactly the newest 100 repositories (all of them if there are less),
+// ordered, with no duplicates, in no more calls than latestSearchMaxCalls
+// a failing seed is replayed with -run 'TestLatestSearchProperties/seed_<seed>$'
+func TestLatestSearchProperties(t *testing.T) {
+	seeds := 100
+	if testing.Short() {
+		seeds = 20
+	}
+
+	for seed := int64(1); seed <= int64(seeds); seed++ {
+		seed := seed
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			random := rand.New(rand.NewSource(seed))
+
+			options := githubfake.Options{Seed: seed, FirstID: 1 + random.Intn(1000000), MaxGap: 1 + random.Intn(1000)}
+			// none, less than a page, around a page, or a lot
+			switch random.Intn(4) {
+			case 0:
+				options.Count = random.Intn(githubRepositoriesPageSize + 1)
+			case 1:
+				options.Count = githubRepositoriesPageSize - 1 + random.Intn(3)
+			default:
+				options.Count = random.Intn(5001)
+			}
+			server := newTestGithub(t, options)
+
+			maxID := 1
+			if latest := server.Latest(1); len(latest) > 0 {
+				maxID = latest[0].ID
+			}
+
+			search := LatestSearch{
+				Fetcher:     githubPageFetcher{request: HttpRequest{Method: http.MethodGet, Url: githubAPIURL + "/repositories"}},
+				Count:       githubRepositoriesPageSize,
+				PageSize:    githubRepositoriesPageSize,
+				Parallelism: 1 + random.Intn(16),
+				High:        githubRepositoriesMaxID,
+			}
+			// hints like the ones estimated from the previous searches, right or wrong:
+			// low sometimes with less than 100 repositories above it, high sometimes below the highest id
+			if random.Intn(2) == 0 {
+				search.Low = random.Intn(maxID + 1)
+			}
+			if random.Intn(2) == 0 {
+				search.High = search.Low + 1 + random.Intn(2*maxID)
+			}
+
+			result, err := search.Find(testContext())
+			if err != nil {
+				t.Fatalf("search failed with %+v: %v", search, err)
+			}
+
+			expected := server.Latest(githubRepositoriesPageSize)
+			if len(result.Repositories) != len(expected) {
+				t.Fatalf("%d repositories found, expected %d, search %+v", len(result.Repositories), len(expected), search)
+			}
+			for i, repository := range result.Repositories {
+				if i > 0 && repository.Id <= result.Repositories[i-1].Id {
+					t.Fatalf("repository %d is not after %d, duplicated or out of order", repository.Id, result.Repositories[i-1].Id)
+				}
+				// the fake lists the newest first
+				if want := expected[len(expected)-1-i].ID; int(repository.Id) != want {
+					t.Fatalf("repository %d found at %d, expected %d, search %+v", repository.Id, i, want, search)
+				}
+			}
+
+			if maxCalls := latestSearchMaxCalls(search, maxID); result.Calls > maxCalls {
+				t.Errorf("%d calls made, at most %d expected, search %+v", result.Calls, maxCalls, search)
+			}
+			if server.Requests() > result.Calls {
+				t.Errorf("%d calls to github for %d pages fetched", server.Requests(), result.Calls)
+			}
+		})
+	}
+}
+
+// BenchmarkLatestSearch
+// Search the last repositories of a fake github with 50ms of latency, for each parallelism
+// repositories are created between the searches and the time between two searches is simulated,
+// so the estimator learns the rate as it would in production; the first search, without hints, is not measured
+func BenchmarkLatestSearch(b *testing.B) {
+	const interval = 5 * time.Minute
+
+	for _, parallelism := range []int{1, 4, 8, 16} {
+		parallelism := parallelism
+		b.Run(fmt.Sprintf("parallelism %d", parallelism), func(b *testing.B) {
+			ctx := testContext()
+
+			options := githubfake.DefaultOptions()
+			options.Count = 50000
+			options.Latency = 50 * time.Millisecond
+			options.RateLimit = 0
+			server := newTestGithub(b, options)
+
+			fetcher := githubPageFetcher{request: HttpRequest{Method: http.MethodGet, Url: server.URL + "/repositories"}}
+			estimator := &LatestSearchEstimator{}
+			now := time.Now()
+
+			search := func() LatestResult {
+				low, high := estimator.Hints(now)
+				search := LatestSearch{
+					Fetcher:     fetcher,
+					Count:       githubRepositoriesPageSize,
+					PageSize:    githubRepositoriesPageSize,
+					Parallelism: parallelism,
+					Low:         low,
+					High:        high,
+				}
+				result, err := search.Find(ctx)
+				if err != nil {
+					b.Fatal(err)
+				}
+				estimator.Record(result.Repositories, now)
+
+				expected := server.Latest(githubRepositoriesPageSize)
+				if len(result.Repositories) != len(expected) || int(result.Repositories[len(result.Repositories)-1].Id) != expected[0].ID {
+					b.Fatalf("wrong repositories found with parallelism %d", parallelism)
+				}
+				return result
+			}
+
+			search()
+
+			var calls, rounds int
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				now = now.Add(interval)
+				server.Create(int(interval / options.CreationInterval))
+				b.StartTimer()
+
+				result := search()
+				calls += result.Calls
+				rounds += result.Rounds
+			}
+
+			b.ReportMetric(float64(calls)/float64(b.N), "calls/op")
+			b.ReportMetric(float64(rounds)/float64(b.N), "rounds/op")
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+)
+
+// most entries accepted by POST /repos/lookup
+const lookupMaxRepositories = 500
+
+// biggest body of POST /repos/lookup, far more than 500 `owner/name` need
+const lookupMaxBodySize = 1 << 20
+
+const (
+	lookupStatusOK       = "ok"
+	lookupStatusNotFound = "not_found"
+	lookupStatusInvalid  = "invalid"
+	lookupStatusError    = "error"
+)
+
+// LookupResult
+// Stats of one of the repositories asked, or why there are none
+type LookupResult struct {
+	Repository string `json:"repository"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	Stats      *Stats `json:"stats,omitempty"`
+}
+
+// parseRepositoryName
+// `owner/name` to the owner and the name
+func parseRepositoryName(fullName string) (string, string, error) {
+	owner, name, ok := strings.Cut(strings.TrimSpace(fullName), "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("invalid repository `%s`, expected owner/name", fullName)
+	}
+	return owner, name, nil
+}
+
+// lookupRepository
+// The repository as the workers need it, the urls are the ones github would return
+func lookupRepository(owner, name string) github.Repository {
+	var repository github.Repository
+
+	repository.Name = name
+	repository.FullName = owner + "/" + name
+	repository.Owner.Login = owner
+	repository.Url = fmt.Sprintf("%s/repos/%s/%s", githubAPIURL, url.PathEscape(owner), url.PathEscape(name))
+	repository.LanguagesUrl = repository.Url + "/languages"
+
+	return repository
+}
+
+// lookupStats
+// Stats of each repository given as `owner/name`, computed by the workers
+// Returns a result per repository, in the same order
+func lookupStats(ctx context.Context, fullNames []string) []LookupResult {
+	ctx, span := tracer.Start(ctx, "lookupStats")
+	defer span.End()
+
+	results := make([]LookupResult, len(fullNames))
+
+	// index in the results of each repository queued
+	var indexes []int
+	var repositories []github.Repository
+
+	for i, fullName := range fullNames {
+		results[i].Repository = fullName
+
+		owner, name, err := parseRepositoryName(fullName)
+		if err != nil {
+			results[i].Status = lookupStatusInvalid
+			results[i].Error = err.Error()
+			continue
+		}
+
+		indexes = append(indexes, i)
+		repositories = append(repositories, lookupRepository(owner, name))
+	}
+
+	for i, stat := range runStatsTasks(ctx, url.Values{}, repositories) {
+		result := &results[indexes[i]]
+
+		switch {
+		case stat.Err == nil:
+			stats := stat.Stats
+			result.Status = lookupStatusOK
+			result.Stats = &stats
+		case isNotFound(stat.Err):
+			result.Status = lookupStatusNotFound
+		default:
+			result.Status = lookupStatusError
+			result.Error = stat.Err.Error()
+		}
+	}
+
+	return results
+}
+
+// repositoryStatsHandlerGet
+// Stats of a single repository
+func repositoryStatsHandlerGet(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	log := logger.Get(r.Context())
+
+	result := lookupStats(withCallerAuthorization(r), []string{vars["owner"] + "/" + vars["name"]})[0]
+
+	switch result.Status {
+	case lookupStatusOK:
+	case lookupStatusNotFound:
+		writeJSONError(w, r, http.StatusNotFound, fmt.Sprintf("repository `%s` not found", result.Repository))
+		return nil
+	case lookupStatusInvalid:
+		writeJSONError(w, r, http.StatusBadRequest, result.Error)
+		return nil
+	default:
+		writeJSONError(w, r, http.StatusInternalServerError, result.Error)
+		return nil
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(result.Stats)
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
+
+// repositoriesLookupHandlerPost
+// Stats of a batch of repositories
+// body: {"repositories": ["owner/name", ...]}
+func repositoriesLookupHandlerPost(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+	log := logger.Get(r.Context())
+
+	var body struct {
+		Repositories []string `json:"repositories"`
+	}
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, lookupMaxBodySize)).Decode(&body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSONError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("body too large, at most %d bytes", lookupMaxBodySize))
+		return nil
+	}
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		return nil
+	}
+
+	if len(body.Repositories) == 0 {
+		writeJSONError(w, r, http.StatusBadRequest, "no repository to look up")
+		return nil
+	}
+
+	if len(body.Repositories) > lookupMaxRepositories {
+		writeJSONError(w, r, http.StatusBadRequest, fmt.Sprintf("too many repositories, at most %d can be looked up at once", lookupMaxRepositories))
+		return nil
+	}
+
+	results := lookupStats(withCallerAuthorization(r), body.Repositories)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
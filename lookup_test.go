@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+// postLookup
+// Call POST /repos/lookup with the body, returns the response and the results
+func postLookup(t *testing.T, body string) (*httptest.ResponseRecorder, []LookupResult) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/repos/lookup", strings.NewReader(body)).WithContext(testContext())
+	res := httptest.NewRecorder()
+	_ = repositoriesLookupHandlerPost(res, req, nil)
+
+	var results []LookupResult
+	if res.Code == http.StatusOK {
+		if err := json.Unmarshal(res.Body.Bytes(), &results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res, results
+}
+
+func TestParseRepositoryName(t *testing.T) {
+	cases := map[string]bool{
+		"owner/name":      true,
+		" owner/name ":    true,
+		"owner":           false,
+		"/name":           false,
+		"owner/":          false,
+		"owner/name/more": false,
+	}
+	for fullName, valid := range cases {
+		owner, name, err := parseRepositoryName(fullName)
+		if (err == nil) != valid {
+			t.Errorf("%q: expected valid %v, got %v", fullName, valid, err)
+		}
+		if valid && (owner != "owner" || name != "name") {
+			t.Errorf("%q: got %q and %q", fullName, owner, name)
+		}
+	}
+}
+
+func TestRepositoryStats(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 3, Count: 5})
+	repository := server.Repositories()[0]
+
+	cases := []struct {
+		name   string
+		owner  string
+		repo   string
+		status int
+	}{
+		{name: "known", owner: repository.Owner, repo: repository.Name, status: http.StatusOK},
+		{name: "unknown", owner: "nobody", repo: "nothing", status: http.StatusNotFound},
+		{name: "invalid", owner: "", repo: repository.Name, status: http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/repos/"+c.owner+"/"+c.repo+"/stats", nil).WithContext(testContext())
+			res := httptest.NewRecorder()
+			_ = repositoryStatsHandlerGet(res, req, map[string]string{"owner": c.owner, "name": c.repo})
+			if res.Code != c.status {
+				t.Fatalf("expected a %d, got %d %s", c.status, res.Code, res.Body.String())
+			}
+			if c.status != http.StatusOK {
+				return
+			}
+
+			var stats Stats
+			if err := json.Unmarshal(res.Body.Bytes(), &stats); err != nil {
+				t.Fatal(err)
+			}
+			if stats.Owner != repository.Owner || stats.Name != repository.Name || stats.StarCount != repository.Stars || stats.License != repository.License {
+				t.Errorf("expected the stats of %s, got %+v", repository.FullName(), stats)
+			}
+		})
+	}
+}
+
+func TestRepositoriesLookup(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 4, Count: 5})
+	repositories := server.Repositories()
+
+	// github failing for a repository only fails its entry
+	server.InjectError(githubfake.ErrorRule{Path: "/repos/" + repositories[1].FullName(), Status: http.StatusInternalServerError})
+
+	fullNames := []string{repositories[0].FullName(), "nobody/nothing", "nope", repositories[1].FullName()}
+	content, _ := json.Marshal(map[string][]string{"repositories": fullNames})
+
+	res, results := postLookup(t, string(content))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected a 200, got %d %s", res.Code, res.Body.String())
+	}
+	if len(results) != len(fullNames) {
+		t.Fatalf("expected a result per repository, got %+v", results)
+	}
+
+	expected := []string{lookupStatusOK, lookupStatusNotFound, lookupStatusInvalid, lookupStatusError}
+	for i, result := range results {
+		if result.Repository != fullNames[i] || result.Status != expected[i] {
+			t.Errorf("entry %d: expected %s %s, got %s %s", i, fullNames[i], expected[i], result.Repository, result.Status)
+		}
+		if (result.Stats != nil) != (expected[i] == lookupStatusOK) {
+			t.Errorf("entry %d: stats only expected when ok, got %+v", i, result.Stats)
+		}
+		if (result.Error != "") != (expected[i] == lookupStatusInvalid || expected[i] == lookupStatusError) {
+			t.Errorf("entry %d: unexpected error %q", i, result.Error)
+		}
+	}
+	if results[0].Stats.StarCount != repositories[0].Stars {
+		t.Errorf("expected %d stars, got %d", repositories[0].Stars, results[0].Stats.StarCount)
+	}
+}
+
+func TestRepositoriesLookupBody(t *testing.T) {
+	newTestGithub(t, githubfake.Options{Seed: 5, Count: 1})
+
+	tooMany, _ := json.Marshal(map[string][]string{"repositories": make([]string, lookupMaxRepositories+1)})
+	tooLarge := `{"repositories": ["` + strings.Repeat("a", lookupMaxBodySize) + `"]}`
+
+	cases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "not json", body: "repositories", status: http.StatusBadRequest},
+		{name: "empty", body: `{"repositories": []}`, status: http.StatusBadRequest},
+		{name: "too many", body: string(tooMany), status: http.StatusBadRequest},
+		{name: "too large", body: tooLarge, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, _ := postLookup(t, c.body)
+			if res.Code != c.status {
+				t.Errorf("expected a %d, got %d %s", c.status, res.Code, res.Body.String())
+			}
+		})
+	}
+}
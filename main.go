@@ -3,9 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Scalingo/go-handlers"
 	"github.com/Scalingo/go-utils/logger"
@@ -15,32 +21,205 @@ type Authorization struct {
 	Token string
 }
 
+// withCallerAuthorization
+// Keep the caller's Authorization header in the context
+// it's only forwarded to github if the deployment allows caller tokens
+func withCallerAuthorization(r *http.Request) context.Context {
+	return context.WithValue(r.Context(), Authorization{}, Authorization{Token: r.Header.Get("Authorization")})
+}
+
+// how long the requests being served are waited for once the server is asked to stop
+const serverShutdownTimeout = 10 * time.Second
+
 func main() {
-	log := logger.Default()
-	log.Info("Initializing app")
-	cfg, err := newConfig()
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// initApp
+// What every command needs to fetch from github: the credentials, the stats backend, the workers and the store
+func initApp(ctx context.Context, cfg *Config) error {
+	log := logger.Get(ctx)
+
+	runningConfig.Store(cfg)
+	setLogLevel(log, configLogLevel(cfg))
+
+	githubAPIURL = strings.TrimSuffix(cfg.GithubAPIURL, "/")
+	githubUploadURL = strings.TrimSuffix(cfg.GithubUploadURL, "/")
+	githubGraphQLURL = cfg.GithubGraphQLURL
+	if cfg.GithubEnterpriseURL != "" {
+		log.Infof("Using the GitHub Enterprise Server api %s", githubAPIURL)
+	}
+
+	var err error
+	githubBaseTransport, err = newGithubTransport(cfg)
 	if err != nil {
-		log.WithError(err).Error("Fail to initialize configuration")
-		os.Exit(1)
+		return fmt.Errorf("initialize github transport failed: %w", err)
+	}
+
+	transport, err := newCassetteTransport(cfg.GithubCassetteMode, cfg.GithubCassettePath, cfg.GithubCassetteMatch, githubBaseTransport)
+	if err != nil {
+		return fmt.Errorf("initialize github cassette failed: %w", err)
+	}
+	githubCassette = transport
+	githubClient = &http.Client{Transport: transport, CheckRedirect: checkGithubRedirect}
+	if cfg.GithubCassetteMode != cassetteModePassthrough {
+		log.Infof("Github calls in %s mode with cassette %s", cfg.GithubCassetteMode, cfg.GithubCassettePath)
+	}
+
+	githubCredentials, err = newGithubCredentials(cfg)
+	if err != nil {
+		return fmt.Errorf("initialize github credentials failed: %w", err)
+	}
+	log.Infof("Using %d server side github tokens, github App: %v, caller tokens allowed: %v", len(cfg.GithubTokens), cfg.GithubAppID != 0, cfg.GithubAllowCallerToken)
+
+	switch cfg.StatsBackend {
+	case statsBackendREST, statsBackendGraphQL:
+		statsBackend = cfg.StatsBackend
+	default:
+		return fmt.Errorf("unknown stats backend `%s`", cfg.StatsBackend)
 	}
 
 	// start workers
-	// TODO handle SIGINT so we finish the requests being processed
-	initStatsWorkers(context.Background(), 16)
+	initStatsWorkers(ctx, cfg.WorkerCount, cfg.StatsQueueSize)
+
+	repositoryStore = newRepositoryStore(cfg.SnapshotRetention, cfg.StoreMaxRepositories)
+	eventStore = newEventStore(cfg.EventsBufferSize)
+	githubETagCache = newETagCache(cfg.GithubETagCacheSize)
+
+	return nil
+}
+
+// serve
+// Run the http and grpc servers, returns the exit code once they stop
+// the configuration is loaded again from the same options on SIGHUP
+func serve(ctx context.Context, cfg *Config, options ConfigOptions) int {
+	log := logger.Get(ctx)
+	log.Info("Initializing app")
+
+	shutdownTracing, err := initTracing(ctx, cfg)
+	if err != nil {
+		log.WithError(err).Error("Fail to initialize tracing")
+		return exitConfig
+	}
+	defer func() { _ = shutdownTracing(context.Background()) }()
+
+	err = initApp(ctx, cfg)
+	if err != nil {
+		log.WithError(err).Error("Fail to initialize app")
+		return exitConfig
+	}
+
+	trackedRepositories = newTrackedRepositories(cfg.TrackedRepositories)
+	go startSnapshotScheduler(ctx, cfg.SnapshotInterval)
+	go startStorePruner(ctx, repositoryStore, storePruneInterval)
+
+	if cfg.EventsPoller {
+		go startEventsPoller(ctx, eventStore, cfg.EventsPollInterval)
+	}
 
 	log.Info("Initializing routes")
 	router := handlers.NewRouter(log)
+	router.Use(tracingMiddleware)
+
+	apiKeys, err := parseAPIKeys(cfg.APIKeys)
+	if err != nil {
+		log.WithError(err).Error("Fail to parse the api keys")
+		return exitConfig
+	}
+	apiKeyStore, err = newAPIKeyStore(cfg.APIKeysStorePath)
+	if err != nil {
+		log.WithError(err).Error("Fail to load the api keys store")
+		return exitConfig
+	}
+	apiKeyStore.SetConfigured(apiKeys, cfg.APIAdminKeys)
+	apiLimiter := newAPILimiter(cfg.APIRateLimit, cfg.APIDailyQuota)
+	go watchConfigReload(ctx, options, apiLimiter)
+	router.Use(apiKeyMiddleware(apiKeyStore, apiLimiter))
+	if !apiKeyStore.Enabled() {
+		log.Warn("No api key configured, the api is open to anyone")
+	}
 	router.HandleFunc("/ping", pongHandler)
+	router.HandleFunc("/healthz", healthzHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", readyzHandlerGet(readinessChecks())).Methods(http.MethodGet)
+	router.HandleFunc("/status", statusHandlerGet()).Methods(http.MethodGet)
+	router.HandleFunc("/api-keys", apiKeysHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/api-keys", apiKeysHandlerPost).Methods(http.MethodPost)
+	router.HandleFunc("/api-keys/{name}", apiKeysHandlerDelete).Methods(http.MethodDelete)
 	router.HandleFunc("/repos", reposHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/repos/lookup", repositoriesLookupHandlerPost).Methods(http.MethodPost)
+	router.HandleFunc("/repos/tracked", trackedRepositoriesHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/repos/{owner}/{name}/stats", repositoryStatsHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/repos/{owner}/{name}/history", repositoryHistoryHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/repos/{owner}/{name}/contributors", repositoryContributorsHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/repos/{owner}/{name}/track", repositoryTrackHandlerPut).Methods(http.MethodPut)
+	router.HandleFunc("/repos/{owner}/{name}/track", repositoryTrackHandlerDelete).Methods(http.MethodDelete)
+	router.HandleFunc("/leaderboard/stars", starsLeaderboardHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/timeseries/created", timeseriesCreatedHandlerGet).Methods(http.MethodGet)
 	router.HandleFunc("/stats", statsHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/stats/topics", statsTopicsHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/owners", ownersHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/owners/{login}/stats", ownerStatsHandlerGet).Methods(http.MethodGet)
+	schema, err := graphqlSchema()
+	if err != nil {
+		log.WithError(err).Error("Fail to build the graphql schema")
+		return exitFailure
+	}
+	router.HandleFunc("/graphql", graphqlHandler(schema, cfg.GraphQLMaxComplexity)).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/cache", cacheHandlerDelete).Methods(http.MethodDelete)
+	router.HandleFunc("/events", eventsHandlerGet).Methods(http.MethodGet)
+	router.HandleFunc("/events/aggregates", eventsAggregatesHandlerGet).Methods(http.MethodGet)
+
+	if cfg.GithubWebhookSecret != "" {
+		deliveries := newWebhookDeliveries(10000)
+		router.HandleFunc("/webhooks/github", webhooksHandlerPost(cfg.GithubWebhookSecret, deliveries)).Methods(http.MethodPost)
+		router.HandleFunc("/webhooks/github/deliveries", webhookDeliveriesHandlerGet(deliveries)).Methods(http.MethodGet)
+	} else {
+		log.Info("No github webhook secret configured, webhooks are disabled")
+	}
+
+	if cfg.GRPCPort != 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
+		if err != nil {
+			log.WithError(err).Error("Fail to listen to the given grpc port")
+			return exitFailure
+		}
+
+		go func() {
+			log.WithField("grpc_port", cfg.GRPCPort).Info("Listening for grpc...")
+			err := newGRPCServer(log, apiKeyStore, apiLimiter).Serve(listener)
+			if err != nil {
+				log.WithError(err).Error("Fail to serve grpc")
+			}
+		}()
+	}
+
+	if cfg.GithubCassetteMode == cassetteModeRecord {
+		go startCassetteFlusher(ctx, githubCassette, cassetteFlushInterval)
+	}
+
+	// SIGINT and SIGTERM stop the server, the command then writes what's left of the cassette
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: router}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
 
 	log = log.WithField("port", cfg.Port)
 	log.Info("Listening...")
-	err = http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), router)
-	if err != nil {
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.WithError(err).Error("Fail to listen to the given port")
-		os.Exit(2)
+		return exitFailure
 	}
+
+	log.Info("Stopped")
+
+	return exitOK
 }
 
 func pongHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
@@ -58,7 +237,7 @@ func pongHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) er
 func reposHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
 	log := logger.Get(r.Context())
 
-	ctx := context.WithValue(r.Context(), Authorization{}, Authorization{Token: r.Header.Get("Authorization")})
+	ctx := withCallerAuthorization(r)
 
 	repos, err := fetchRepositories(ctx, r.URL.Query())
 	if err != nil {
@@ -87,10 +266,25 @@ func reposHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string
 func statsHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
 	log := logger.Get(r.Context())
 
-	ctx := r.Context()
-	ctx = context.WithValue(ctx, Authorization{}, Authorization{Token: r.Header.Get("Authorization")})
+	options, err := newExportOptions(r.URL.Query(), r.Header.Get("Accept"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	err = validateTopicMode(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	ctx := withCallerAuthorization(r)
 
 	stats, err := fetchStats(ctx, r.URL.Query())
+	if isRateLimited(err) {
+		writeGithubRateLimited(w, r, err)
+		return nil
+	}
 	if err != nil {
 		w.Header().Add("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -103,6 +297,11 @@ func statsHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string
 		return nil
 	}
 
+	if options.Format != exportFormatJSON {
+		writeStatsExport(w, r, stats, options)
+		return nil
+	}
+
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
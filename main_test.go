@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+// testContext
+// The context of a request, with a logger
+func testContext() context.Context {
+	return logger.ToCtx(context.Background(), logger.Default())
+}
+
+// newTestGithub
+// Start a fake github and point the github calls to it until the end of the test
+// the stats workers, the repository store, the ETag cache and the github rate limit are the ones of a fresh service
+func newTestGithub(t testing.TB, options githubfake.Options) *githubfake.Server {
+	t.Helper()
+
+	server := githubfake.NewServer(options)
+	t.Cleanup(server.Close)
+
+	cfg, err := newConfig(ConfigOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousAPIURL, previousGraphQLURL, previousClient := githubAPIURL, githubGraphQLURL, githubClient
+	previousConfig, previousStore, previousETagCache := runningConfig.Load(), repositoryStore, githubETagCache
+	t.Cleanup(func() {
+		githubAPIURL, githubGraphQLURL, githubClient = previousAPIURL, previousGraphQLURL, previousClient
+		repositoryStore, githubETagCache = previousStore, previousETagCache
+		if previousConfig != nil {
+			runningConfig.Store(previousConfig)
+		}
+	})
+
+	githubAPIURL = server.URL
+	githubGraphQLURL = server.URL + "/graphql"
+	githubClient = &http.Client{Transport: http.DefaultTransport, CheckRedirect: checkGithubRedirect}
+	runningConfig.Store(cfg)
+	repositoryStore = newRepositoryStore(cfg.SnapshotRetention, cfg.StoreMaxRepositories)
+	githubETagCache = newETagCache(cfg.GithubETagCacheSize)
+
+	githubRateLimit.Lock()
+	previousRateLimit := githubRateLimit.GithubRateLimit
+	githubRateLimit.GithubRateLimit = GithubRateLimit{}
+	githubRateLimit.Unlock()
+	t.Cleanup(func() {
+		githubRateLimit.Lock()
+		githubRateLimit.GithubRateLimit = previousRateLimit
+		githubRateLimit.Unlock()
+	})
+
+	if workerStatsTasks == nil {
+		initStatsWorkers(testContext(), cfg.WorkerCount, cfg.StatsQueueSize)
+	}
+
+	return server
+}
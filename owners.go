@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// at most 10 pages of 100 repositories are fetched for an owner
+const ownerMaxPages = 10
+
+// Owner
+// Aggregates of the repositories of an owner
+type Owner struct {
+	Login        string `json:"login"`
+	Type         string `json:"type"`
+	Repositories int    `json:"repositories"`
+	StarCount    int    `json:"stars_count"`
+	// number of repositories using each language
+	Languages map[string]int `json:"languages"`
+}
+
+// aggregateOwners
+// Group the stats by owner, the owners with the most repositories first
+func aggregateOwners(stats []Stats) []Owner {
+	owners := map[string]*Owner{}
+
+	for _, stat := range stats {
+		owner, ok := owners[stat.Owner]
+		if !ok {
+			owner = &Owner{
+				Login:     stat.Owner,
+				Type:      ownerType(stat),
+				Languages: map[string]int{},
+			}
+			owners[stat.Owner] = owner
+		}
+
+		owner.Repositories++
+		owner.StarCount += stat.StarCount
+		for language := range stat.Languages {
+			owner.Languages[language]++
+		}
+	}
+
+	results := make([]Owner, 0, len(owners))
+	for _, owner := range owners {
+		results = append(results, *owner)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Repositories != results[j].Repositories {
+			return results[i].Repositories > results[j].Repositories
+		}
+		if results[i].StarCount != results[j].StarCount {
+			return results[i].StarCount > results[j].StarCount
+		}
+		return results[i].Login < results[j].Login
+	})
+
+	return results
+}
+
+// ownerType
+// User or Organization, the stats don't have it but the stored repository does
+func ownerType(stat Stats) string {
+	stored, ok := repositoryStore.Get(stat.Owner + "/" + stat.Name)
+	if !ok {
+		return ""
+	}
+	return stored.Repository.Owner.Type
+}
+
+// OwnerStats
+// Stats of the repositories of an owner, Truncated is true when it has more than ownerMaxPages pages of them
+type OwnerStats struct {
+	Stats     []Stats `json:"stats"`
+	Truncated bool    `json:"truncated"`
+}
+
+// fetchOwnerGithubRepositories
+// Page through the repositories of a user or an organization following the `next` links
+// Returns whether some were left out because of ownerMaxPages
+func fetchOwnerGithubRepositories(ctx context.Context, login string) ([]github.Repository, bool, error) {
+	ctx, span := tracer.Start(ctx, "fetchOwnerGithubRepositories")
+	defer span.End()
+
+	pages := newGithubPaginator[github.Repository](
+		fmt.Sprintf("%s/users/%s/repos", githubAPIURL, url.PathEscape(login)),
+		map[string]string{"per_page": "100"},
+		ownerMaxPages,
+	)
+
+	repositories, err := github.CollectPages(ctx, pages)
+	if err != nil {
+		return nil, false, fmt.Errorf("list %s repositories failed: %w", login, err)
+	}
+
+	span.SetAttributes(
+		attribute.Int("github.owner.repositories", len(repositories)),
+		attribute.Bool("github.owner.truncated", pages.Truncated()),
+	)
+	repositoryStore.Add(repositories)
+
+	return repositories, pages.Truncated(), nil
+}
+
+// fetchOwnerStats
+// Stats of the repositories of an owner, with the same filters as /stats
+func fetchOwnerStats(ctx context.Context, login string, params url.Values) (OwnerStats, error) {
+	return coalesceQuery(ctx, "/owners/"+login+"/stats", params, func(ctx context.Context) (OwnerStats, error) {
+		repositories, truncated, err := fetchOwnerGithubRepositories(ctx, login)
+		if err != nil {
+			return OwnerStats{}, err
+		}
+
+		return OwnerStats{Stats: enrichStats(ctx, params, repositories), Truncated: truncated}, nil
+	})
+}
+
+// ownersHandlerGet
+// The owners of the repositories /stats returns with the same parameters
+func ownersHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+	log := logger.Get(r.Context())
+
+	err := validateTopicMode(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	stats, err := fetchStats(withCallerAuthorization(r), r.URL.Query())
+	if isRateLimited(err) {
+		writeGithubRateLimited(w, r, err)
+		return nil
+	}
+	if err != nil {
+		writeJSONError(w, r, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(aggregateOwners(stats))
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
+
+// ownerStatsHandlerGet
+// Stats of every repository of an owner, `truncated` is true when it has more than we fetch
+func ownerStatsHandlerGet(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	log := logger.Get(r.Context())
+
+	err := validateTopicMode(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	stats, err := fetchOwnerStats(withCallerAuthorization(r), vars["login"], r.URL.Query())
+	if isNotFound(err) {
+		writeJSONError(w, r, http.StatusNotFound, fmt.Sprintf("owner `%s` not found", vars["login"]))
+		return nil
+	}
+	if isRateLimited(err) {
+		writeGithubRateLimited(w, r, err)
+		return nil
+	}
+	if err != nil {
+		writeJSONError(w, r, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+// getOwnerStats
+// Call /owners/{login}/stats, returns the response and the stats of the owner
+func getOwnerStats(t *testing.T, login, query string) (*httptest.ResponseRecorder, OwnerStats) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/owners/"+login+"/stats?"+query, nil).WithContext(testContext())
+	res := httptest.NewRecorder()
+	_ = ownerStatsHandlerGet(res, req, map[string]string{"login": login})
+
+	var stats OwnerStats
+	if res.Code == http.StatusOK {
+		if err := json.Unmarshal(res.Body.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res, stats
+}
+
+func TestAggregateOwners(t *testing.T) {
+	stats := []Stats{
+		{Repo: Repo{Owner: "b", Name: "one"}, StarCount: 1, Languages: map[string]int{"Go": 10}},
+		{Repo: Repo{Owner: "a", Name: "one"}, StarCount: 5, Languages: map[string]int{"Go": 10, "C": 5}},
+		{Repo: Repo{Owner: "b", Name: "two"}, StarCount: 2, Languages: map[string]int{"Go": 3}},
+		{Repo: Repo{Owner: "c", Name: "one"}, StarCount: 5},
+	}
+
+	owners := aggregateOwners(stats)
+	expected := []Owner{
+		{Login: "b", Repositories: 2, StarCount: 3, Languages: map[string]int{"Go": 2}},
+		{Login: "a", Repositories: 1, StarCount: 5, Languages: map[string]int{"Go": 1, "C": 1}},
+		{Login: "c", Repositories: 1, StarCount: 5, Languages: map[string]int{}},
+	}
+	if !reflect.DeepEqual(owners, expected) {
+		t.Errorf("got %+v, expected %+v", owners, expected)
+	}
+}
+
+func TestOwnerStats(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 1, Count: 20})
+	server.CreateOwned("octocat", 5)
+
+	res, stats := getOwnerStats(t, "octocat", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected a 200, got %d %s", res.Code, res.Body.String())
+	}
+	if stats.Truncated || len(stats.Stats) != 5 {
+		t.Errorf("expected the stats of the 5 repositories, got %d, truncated: %v", len(stats.Stats), stats.Truncated)
+	}
+	for _, stat := range stats.Stats {
+		if stat.Owner != "octocat" {
+			t.Errorf("repository %s/%s is not of the owner", stat.Owner, stat.Name)
+		}
+	}
+}
+
+func TestOwnerStatsTruncated(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 1})
+	server.CreateOwned("prolific", ownerMaxPages*100+1)
+
+	repositories, truncated, err := fetchOwnerGithubRepositories(testContext(), "prolific")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !truncated || len(repositories) != ownerMaxPages*100 {
+		t.Errorf("expected the first %d repositories and the list truncated, got %d, truncated: %v", ownerMaxPages*100, len(repositories), truncated)
+	}
+	if server.Requests() != ownerMaxPages {
+		t.Errorf("expected %d pages fetched, got %d calls", ownerMaxPages, server.Requests())
+	}
+}
+
+func TestOwnerStatsNotFound(t *testing.T) {
+	newTestGithub(t, githubfake.Options{Seed: 1, Count: 20})
+
+	res, _ := getOwnerStats(t, "nobody", "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected a 404 for an unknown owner, got %d %s", res.Code, res.Body.String())
+	}
+}
+
+func TestOwnersRateLimited(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 1, FirstID: 1, Count: 10, RateLimit: 1})
+	server.CreateOwned("octocat", 2)
+
+	// the only call of the window is taken
+	req := httptest.NewRequest(http.MethodGet, "/owners?since=1", nil).WithContext(testContext())
+	res := httptest.NewRecorder()
+	_ = ownersHandlerGet(res, req, nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected a 200 while github answers, got %d %s", res.Code, res.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/owners?since=0", nil).WithContext(testContext())
+	res = httptest.NewRecorder()
+	_ = ownersHandlerGet(res, req, nil)
+	if res.Code != http.StatusServiceUnavailable || res.Header().Get("Retry-After") == "" {
+		t.Errorf("/owners: expected a 503 with Retry-After, got %d %s", res.Code, res.Body.String())
+	}
+
+	res, _ = getOwnerStats(t, "octocat", "")
+	if res.Code != http.StatusServiceUnavailable {
+		t.Fatalf("/owners/octocat/stats: expected a 503, got %d %s", res.Code, res.Body.String())
+	}
+	if retryAfter, err := strconv.Atoi(res.Header().Get("Retry-After")); err != nil || retryAfter < 1 {
+		t.Errorf("expected a Retry-After, got %q", res.Header().Get("Retry-After"))
+	}
+}
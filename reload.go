@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// runningConfig
+// The configuration in use, replaced on reload
+// the settings that can be reloaded are read from it rather than copied at startup
+var runningConfig atomic.Pointer[Config]
+
+// currentConfig
+// The configuration in use, the defaults until one is loaded
+func currentConfig() *Config {
+	if cfg := runningConfig.Load(); cfg != nil {
+		return cfg
+	}
+
+	cfg, _ := newConfig(ConfigOptions{})
+	return cfg
+}
+
+// applyReloadableConfig
+// Apply the settings tagged `reload:"true"`, the other ones read them from runningConfig
+func applyReloadableConfig(ctx context.Context, cfg *Config, limiter *APILimiter) {
+	// a LOG_LEVEL removed from the sources sets the level back to its default
+	setLogLevel(logger.Get(ctx), configLogLevel(cfg))
+
+	if limiter != nil {
+		limiter.SetLimits(cfg.APIRateLimit, cfg.APIDailyQuota)
+	}
+
+	// validated before, the keys can't be invalid
+	if keys, err := parseAPIKeys(cfg.APIKeys); err == nil {
+		apiKeyStore.SetConfigured(keys, cfg.APIAdminKeys)
+	}
+
+	resizeStatsWorkers(ctx, cfg.WorkerCount)
+}
+
+// configLogLevel
+// LOG_LEVEL, or the level go-utils takes from LOGGER_LEVEL when it's empty
+func configLogLevel(cfg *Config) logrus.Level {
+	if level, err := logrus.ParseLevel(cfg.LogLevel); err == nil {
+		return level
+	}
+
+	switch log := logger.Default().(type) {
+	case *logrus.Logger:
+		return log.GetLevel()
+	case *logrus.Entry:
+		return log.Logger.GetLevel()
+	}
+	return logrus.InfoLevel
+}
+
+// setLogLevel
+// The level of the logger behind the logger of the context, shared by every entry made from it
+func setLogLevel(log logrus.FieldLogger, level logrus.Level) {
+	switch log := log.(type) {
+	case *logrus.Logger:
+		log.SetLevel(level)
+	case *logrus.Entry:
+		log.Logger.SetLevel(level)
+	}
+}
+
+// reloadConfig
+// Load the configuration again from the same sources, it's ignored if it's invalid
+// the settings that can't be reloaded keep their value, a warning tells a restart is needed to change them
+func reloadConfig(ctx context.Context, options ConfigOptions, limiter *APILimiter) error {
+	log := logger.Get(ctx)
+
+	next, err := newConfig(options)
+	if err != nil {
+		return err
+	}
+	err = next.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	reloaded := *runningConfig.Load()
+
+	current := reflect.ValueOf(&reloaded).Elem()
+	nextValue := reflect.ValueOf(next).Elem()
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Type().Field(i)
+		if reflect.DeepEqual(current.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			continue
+		}
+
+		if field.Tag.Get("reload") != "true" {
+			log.Warnf("%s changed, restart to apply it", field.Tag.Get("envconfig"))
+			continue
+		}
+
+		current.Field(i).Set(nextValue.Field(i))
+		if field.Tag.Get("secret") == "true" {
+			log.Infof("%s reloaded", field.Tag.Get("envconfig"))
+		} else {
+			log.Infof("%s reloaded: %v", field.Tag.Get("envconfig"), current.Field(i).Interface())
+		}
+	}
+
+	runningConfig.Store(&reloaded)
+	applyReloadableConfig(ctx, &reloaded, limiter)
+
+	return nil
+}
+
+// watchConfigReload
+// Reload the configuration on SIGHUP until the context is done
+func watchConfigReload(ctx context.Context, options ConfigOptions, limiter *APILimiter) {
+	log := logger.Get(ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			log.Info("Reloading configuration")
+			err := reloadConfig(ctx, options, limiter)
+			if err != nil {
+				log.WithError(err).Error("Fail to reload configuration, keeping the current one")
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+	"github.com/sirupsen/logrus"
+)
+
+// useAPIKeyStore
+// The api keys are checked against an empty store until the end of the test
+func useAPIKeyStore(t *testing.T) *APIKeyStore {
+	t.Helper()
+
+	previous := apiKeyStore
+	apiKeyStore = &APIKeyStore{admins: map[string]bool{}, stored: map[string]storedAPIKey{}}
+	t.Cleanup(func() { apiKeyStore = previous })
+
+	return apiKeyStore
+}
+
+func TestApplyReloadableConfigLogLevel(t *testing.T) {
+	t.Setenv("LOGGER_LEVEL", "warn")
+
+	log := logrus.New()
+	ctx := logger.ToCtx(context.Background(), log.WithField("test", t.Name()))
+	useAPIKeyStore(t)
+
+	cfg := *currentConfig()
+	for level, expected := range map[string]logrus.Level{"debug": logrus.DebugLevel, "error": logrus.ErrorLevel, "": logrus.WarnLevel} {
+		cfg.LogLevel = level
+		applyReloadableConfig(ctx, &cfg, nil)
+		if log.GetLevel() != expected {
+			t.Errorf("LOG_LEVEL %q: expected the level %s, got %s", level, expected, log.GetLevel())
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	newTestGithub(t, githubfake.Options{})
+	keys := useAPIKeyStore(t)
+	t.Setenv("LOGGER_LEVEL", "info")
+
+	path := writeConfigFile(t, "config.yaml", "log_level: debug\nport: 6000\napi_rate_limit: 10\n")
+	options := ConfigOptions{File: path}
+
+	cfg, err := newConfig(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runningConfig.Store(cfg)
+
+	log := logrus.New()
+	ctx := logger.ToCtx(context.Background(), log)
+	limiter := newAPILimiter(cfg.APIRateLimit, cfg.APIDailyQuota)
+	applyReloadableConfig(ctx, cfg, limiter)
+	if log.GetLevel() != logrus.DebugLevel {
+		t.Fatalf("expected the level of the file, got %s", log.GetLevel())
+	}
+
+	hash := sha256.Sum256([]byte("ops-key"))
+	content := "port: 7000\napi_rate_limit: 20\napi_keys: ops:" + hex.EncodeToString(hash[:]) + "\napi_admin_keys: [ops]\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("API_DAILY_QUOTA", "50")
+
+	if err := reloadConfig(ctx, options, limiter); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := currentConfig()
+	if reloaded.Port != 6000 {
+		t.Errorf("PORT can't be reloaded, expected 6000, got %d", reloaded.Port)
+	}
+	if reloaded.APIRateLimit != 20 || reloaded.APIDailyQuota != 50 || limiter.ratePerMin != 20 || limiter.dailyQuota != 50 {
+		t.Errorf("expected the limits of the file and the environment applied, got %d and %d", limiter.ratePerMin, limiter.dailyQuota)
+	}
+	if keys.Authenticate("ops-key") != "ops" || !keys.IsAdmin("ops") {
+		t.Errorf("expected the reloaded api keys applied")
+	}
+
+	// LOG_LEVEL removed from the file is back to its default
+	if reloaded.LogLevel != "" || log.GetLevel() != logrus.InfoLevel {
+		t.Errorf("expected the default level, got %q and %s", reloaded.LogLevel, log.GetLevel())
+	}
+
+	// an invalid configuration is ignored
+	for _, content := range []string{"api_rate_limit: -1\n", "colour: blue\n"} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := reloadConfig(ctx, options, limiter); err == nil {
+			t.Errorf("%q: expected the reload to fail", content)
+		}
+		if currentConfig() != reloaded || limiter.ratePerMin != 20 {
+			t.Errorf("%q: the running configuration was replaced", content)
+		}
+	}
+}
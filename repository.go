@@ -5,27 +5,31 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"strconv"
+	"time"
 
 	"github.com/Scalingo/go-utils/logger"
 	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// githubAPIURL
+// The github REST api, GITHUB_API_URL points it to the fake github of githubfake in development
+var githubAPIURL = "https://api.github.com"
+
 func fetchGithubRepositories(ctx context.Context, params url.Values) ([]github.Repository, error) {
+	ctx, span := tracer.Start(ctx, "fetchGithubRepositories")
+	defer span.End()
+
 	log := logger.Get(ctx)
 
 	httpRequest := HttpRequest{
 		Method: http.MethodGet,
-		Url:    "https://api.github.com/repositories",
+		Url:    githubAPIURL + "/repositories",
 		Headers: map[string]string{
 			"Accept": "application/vnd.github.v3+json",
 		},
 	}
 
-	if auth, ok := ctx.Value(Authorization{}).(Authorization); ok && auth.Token != "" {
-		httpRequest.Headers["Authorization"] = auth.Token
-	}
-
 	var repositories []github.Repository
 
 	// just a quick param to fetch 100 repository that are not the last created
@@ -33,69 +37,53 @@ func fetchGithubRepositories(ctx context.Context, params url.Values) ([]github.R
 		httpRequest.Query = map[string]string{"since": sinceIdParam}
 
 		_, err := httpRequest.Do(ctx, &repositories)
+		if err == nil {
+			markIngestion()
+			repositoryStore.Add(repositories)
+		}
 
 		return repositories, err
 	}
 
 	// Find the last 100 repositories created
-	//
-	// Since the API won't tell us if we have the last page
-	// we look for a call that returns 99 repositories
-	// then decrement until we find that last one repository missing
-	//
-	// the algorithm is basically: find a low and a high bound
-	// (low: 100 repositories returned, high: less than 100 repositories returned)
-	// then get closer to the correct id by increments of (high - low) / 2
-	sinceIdPrev := 1
-	sinceId := 10000000000
-
-	var tryCount int
-	for tryCount = 0; ; tryCount++ {
-		httpRequest.Query = map[string]string{"since": strconv.Itoa(sinceId)}
-
-		repositories = []github.Repository{}
-
-		_, err := httpRequest.Do(ctx, &repositories)
-		if err != nil {
-			return nil, err
-		}
+	// the api won't tell us which page is the last one, see LatestSearch
+	result, err := searchLatestRepositories(ctx, githubPageFetcher{request: httpRequest}, latestSearchEstimator, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("search the last repositories failed: %w", err)
+	}
 
-		diff := sinceIdPrev - sinceId
-		if diff < 0 {
-			diff = -diff
-		} else if diff == 0 {
-			// just so we don't loop infinitely on the same id
-			diff = 2
-		}
+	log.Infof("took %d calls in %d rounds to find the last %d repositories", result.Calls, result.Rounds, len(result.Repositories))
+	span.SetAttributes(attribute.Int("github.search.calls", result.Calls), attribute.Int("github.search.rounds", result.Rounds))
+	markIngestion()
+	repositoryStore.Add(result.Repositories)
 
-		sinceIdPrev = sinceId
+	return result.Repositories, nil
+}
 
-		if len(repositories) < 99 {
-			sinceId = sinceId - (diff / 2)
-		} else if len(repositories) > 99 {
-			sinceId = sinceId + (diff / 2)
-		} else {
-			break
-		}
+// fetchGithubRepository
+// The record of a single repository, kept in the repository store
+func fetchGithubRepository(ctx context.Context, owner, name string) (github.Repository, error) {
+	ctx, span := tracer.Start(ctx, "fetchGithubRepository")
+	defer span.End()
 
-		log.Debugf("tryCount %d sinceIdPrev %d sinceId %d len(results) %d", tryCount, sinceIdPrev, sinceId, len(repositories))
+	httpRequest := HttpRequest{
+		Method: http.MethodGet,
+		Url:    lookupRepository(owner, name).Url,
+		Headers: map[string]string{
+			"Accept": "application/vnd.github.v3+json",
+		},
 	}
 
-	// at this point we just have to find that one more repository missing
-	for ; len(repositories) < 100; tryCount++ {
-		sinceId -= 1
+	var repository github.Repository
 
-		httpRequest.Query = map[string]string{"since": strconv.Itoa(sinceId)}
-
-		_, err := httpRequest.Do(ctx, &repositories)
-		if err != nil {
-			return nil, fmt.Errorf("list github repositories failed: %w", err)
-		}
+	_, err := httpRequest.Do(ctx, &repository)
+	if err != nil {
+		return repository, err
 	}
 
-	log.Infof("took %d calls to find the last 100 repositories", tryCount)
+	repositoryStore.Put(repository)
 
-	return repositories, nil
+	return repository, nil
 }
 
 type Repo struct {
@@ -105,7 +93,15 @@ type Repo struct {
 	Description string `json:"description"`
 }
 
+// fetchRepositories
+// Identical queries made at the same time share the same computation
 func fetchRepositories(ctx context.Context, params url.Values) ([]Repo, error) {
+	return coalesceQuery(ctx, "/repos", params, func(ctx context.Context) ([]Repo, error) {
+		return computeRepositories(ctx, params)
+	})
+}
+
+func computeRepositories(ctx context.Context, params url.Values) ([]Repo, error) {
 	repositories, err := fetchGithubRepositories(ctx, params)
 	if err != nil {
 		return nil, fmt.Errorf("fetchGithubRepositories failed: %w", err)
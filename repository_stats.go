@@ -6,43 +6,121 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 
 	"github.com/Scalingo/go-utils/logger"
 	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func initStatsWorkers(ctx context.Context, count int) {
+// number of stats workers currently running, reported by /readyz
+var workerStatsRunning atomic.Int32
+
+// number of stats workers there should be, WORKER_COUNT can change on reload
+var workerStatsTarget atomic.Int32
+
+// closed and replaced each time the number of workers is lowered, to wake up the idle workers
+var workerStatsResized = make(chan struct{})
+
+// serializes the changes of the number of workers
+var workerStatsResize sync.Mutex
+
+func initStatsWorkers(ctx context.Context, count int, queueSize int) {
+	workerStatsTasks = make(chan WorkerStatsTask, queueSize)
+	resizeStatsWorkers(ctx, count)
+}
+
+// resizeStatsWorkers
+// Start or stop workers until there are count of them
+// a worker is only stopped once done with its current task, the resize doesn't wait for it
+func resizeStatsWorkers(ctx context.Context, count int) {
 	log := logger.Get(ctx)
 
-	// TODO workerCount in conf
-	// and some thinking about how many workers we need
-	for i := 0; i < 16; i++ {
+	workerStatsResize.Lock()
+	defer workerStatsResize.Unlock()
+
+	current := int(workerStatsTarget.Load())
+	workerStatsTarget.Store(int32(count))
+
+	// TODO some thinking about how many workers we need
+	for i := current; i < count; i++ {
+		// counted before it starts, so a resize right after sees it
+		workerStatsRunning.Add(1)
 		go func(i int) {
 			log.Debugf("worker stats %d started", i)
 
-			// you'd want to know if one of thoses stops unexpectedly
-			defer log.Errorf("worker stats %d exited", i)
+			if startWorkerStats(ctx) {
+				log.Debugf("worker stats %d stopped", i)
+				return
+			}
+			workerStatsRunning.Add(-1)
 
-			startWorkerStats(ctx)
+			// you'd want to know if one of thoses stops unexpectedly
+			if ctx.Err() != nil {
+				log.Debugf("worker stats %d exited", i)
+			} else {
+				log.Errorf("worker stats %d exited unexpectedly", i)
+			}
 		}(i)
 	}
+
+	// the workers above the target stop by themselves, the idle ones are woken up to do so
+	if count < current {
+		close(workerStatsResized)
+		workerStatsResized = make(chan struct{})
+	}
+
+	if count != current {
+		log.Infof("%d stats workers, %d before", count, current)
+	}
+}
+
+// statsWorkersResized
+// Closed the next time the number of workers is lowered
+func statsWorkersResized() <-chan struct{} {
+	workerStatsResize.Lock()
+	defer workerStatsResize.Unlock()
+
+	return workerStatsResized
+}
+
+// claimStatsWorkerStop
+// true if there are more workers running than needed, the caller is then no longer counted as running and must stop
+func claimStatsWorkerStop() bool {
+	for {
+		running := workerStatsRunning.Load()
+		if running <= workerStatsTarget.Load() {
+			return false
+		}
+		if workerStatsRunning.CompareAndSwap(running, running-1) {
+			return true
+		}
+	}
 }
 
 type WorkerStats struct {
+	// index of the task in the batch the caller queued
+	Index int
 	Stats Stats
 	Err   error
 }
 
 type WorkerStatsTask struct {
-	auth       Authorization
+	// context of the request that queued the task
+	// carries the parent span so the task shows up in the request trace
+	ctx        context.Context
+	index      int
 	params     url.Values
 	repository github.Repository
 	stats      chan<- WorkerStats
 }
 
 // tasks to pool from for the workers
-// limit to 1000 just to set a limit but the actual limit would require some thinking
-var workerStatsTasks = make(chan WorkerStatsTask, 1000)
+// STATS_QUEUE_SIZE of them at most, the actual limit would require some thinking
+var workerStatsTasks chan WorkerStatsTask
 
 type WorkerDiscardRepository struct{}
 
@@ -50,83 +128,129 @@ func (w WorkerDiscardRepository) Error() string {
 	return "discard repository"
 }
 
-func startWorkerStats(ctx context.Context) {
-	for task := range workerStatsTasks {
-		httpRequest := HttpRequest{
-			Method: http.MethodGet,
-			Headers: map[string]string{
-				"Accept": "application/vnd.github.v3+json",
-			},
+// startWorkerStats
+// Process the tasks until the context is done or there are more workers than needed
+// true if the worker stopped because resizeStatsWorkers lowered their number
+func startWorkerStats(ctx context.Context) bool {
+	for {
+		// taken before the check, a resize right after it closes this one
+		resized := statsWorkersResized()
+		if claimStatsWorkerStop() {
+			return true
 		}
 
-		if task.auth.Token != "" {
-			httpRequest.Headers["Authorization"] = task.auth.Token
+		select {
+		case <-ctx.Done():
+			return false
+		case <-resized:
+		case task := <-workerStatsTasks:
+			result := processWorkerStatsTask(task)
+			result.Index = task.index
+
+			task.stats <- result
 		}
+	}
+}
 
-		// fetch repository
-		// we do so to get the repository's license, stars count
-		httpRequest.Url = task.repository.Url
+// processWorkerStatsTask
+// Fetch the stats of one repository, traced in a span child of the request that queued it
+func processWorkerStatsTask(task WorkerStatsTask) (result WorkerStats) {
+	ctx, span := tracer.Start(task.ctx, "WorkerStatsTask", trace.WithAttributes(
+		attribute.String("github.repository", task.repository.FullName),
+	))
+	defer func() {
+		if result.Err != nil && !errors.Is(result.Err, WorkerDiscardRepository{}) {
+			span.RecordError(result.Err)
+			span.SetStatus(codes.Error, result.Err.Error())
+		}
+		span.End()
+	}()
+
+	httpRequest := HttpRequest{
+		Method: http.MethodGet,
+		Headers: map[string]string{
+			"Accept": "application/vnd.github.v3+json",
+		},
+	}
 
-		var repository github.Repository
+	// fetch repository
+	// we do so to get the repository's license, stars count
+	httpRequest.Url = task.repository.Url
 
-		_, err := httpRequest.Do(ctx, &repository)
-		if err != nil {
-			task.stats <- WorkerStats{
-				Err: fmt.Errorf("failed to fetch repository: %w", err),
-			}
+	var repository github.Repository
 
-			continue
+	_, err := httpRequest.Do(ctx, &repository)
+	if err != nil {
+		return WorkerStats{
+			Err: fmt.Errorf("failed to fetch repository: %w", err),
 		}
+	}
 
-		// fetch languages
-		httpRequest.Url = task.repository.LanguagesUrl
-
-		var languages map[string]int
+	// fetch languages
+	httpRequest.Url = task.repository.LanguagesUrl
 
-		_, err = httpRequest.Do(ctx, &languages)
-		if err != nil {
-			task.stats <- WorkerStats{
-				Err: fmt.Errorf("failed to fetch languages: %w", err),
-			}
+	var languages map[string]int
 
-			continue
+	_, err = httpRequest.Do(ctx, &languages)
+	if err != nil {
+		return WorkerStats{
+			Err: fmt.Errorf("failed to fetch languages: %w", err),
 		}
+	}
 
-		// filters out repositories based on the query parameters
-		license := task.params.Get("license")
-		if license != "" && repository.License.Key != license {
-			task.stats <- WorkerStats{
-				Err: fmt.Errorf("wrong license `%s`: %w", repository.License.Key, WorkerDiscardRepository{}),
-			}
+	stats := newStats(repository, repository.StargazersCount, repository.License.Key, languages, repository.Topics)
 
-			continue
-		}
+	repositoryStore.Put(repository)
+	repositoryStore.PutStats(repository, stats)
+
+	// filters out repositories based on the query parameters
+	if err := filterStats(task.params, stats); err != nil {
+		return WorkerStats{Err: err}
+	}
 
-		language := task.params.Get("language")
-		if language != "" {
-			if _, ok := languages[language]; !ok {
-				task.stats <- WorkerStats{
-					Err: fmt.Errorf("wrong language `%v`: %w", languages, WorkerDiscardRepository{}),
-				}
+	// send back the repository stats
+	return WorkerStats{Stats: stats}
+}
 
-				continue
-			}
-		}
+func newStats(repository github.Repository, starCount int, license string, languages map[string]int, topics []string) Stats {
+	if topics == nil {
+		topics = []string{}
+	}
 
-		// send back the repository stats
-		task.stats <- WorkerStats{
-			Stats: Stats{
-				Repo: Repo{
-					Url:         task.repository.Url,
-					Name:        task.repository.Name,
-					Owner:       task.repository.Owner.Login,
-					Description: task.repository.Description,
-				},
-				StarCount: repository.StargazersCount,
-				Languages: languages,
-			},
+	return Stats{
+		Repo: Repo{
+			Url:         repository.Url,
+			Name:        repository.Name,
+			Owner:       repository.Owner.Login,
+			Description: repository.Description,
+		},
+		StarCount: starCount,
+		Languages: languages,
+		License:   license,
+		Topics:    topics,
+	}
+}
+
+// filterStats
+// Returns a WorkerDiscardRepository error if the stats don't match the query parameters
+func filterStats(params url.Values, stats Stats) error {
+	license := params.Get("license")
+	if license != "" && stats.License != license {
+		return fmt.Errorf("wrong license `%s`: %w", stats.License, WorkerDiscardRepository{})
+	}
+
+	language := params.Get("language")
+	if language != "" {
+		if _, ok := stats.Languages[language]; !ok {
+			return fmt.Errorf("wrong language `%v`: %w", stats.Languages, WorkerDiscardRepository{})
 		}
 	}
+
+	if !matchTopics(params, stats.Topics) {
+		return fmt.Errorf("wrong topics `%v`: %w", stats.Topics, WorkerDiscardRepository{})
+	}
+
+	return nil
 }
 
 // fetch the repositories stats
@@ -135,51 +259,106 @@ type Stats struct {
 	StarCount int            `json:"stars_count"`
 	Languages map[string]int `json:"languages"`
 	License   string         `json:"license"`
+	Topics    []string       `json:"topics"`
 }
 
+// fetchStats
+// Identical queries made at the same time share the same computation
 func fetchStats(ctx context.Context, params url.Values) ([]Stats, error) {
-	log := logger.Get(ctx)
-
-	stats := make(chan WorkerStats)
-	defer close(stats)
+	return coalesceQuery(ctx, "/stats", params, func(ctx context.Context) ([]Stats, error) {
+		return computeStats(ctx, params)
+	})
+}
 
+func computeStats(ctx context.Context, params url.Values) ([]Stats, error) {
 	repositories, err := fetchGithubRepositories(ctx, params)
 	if err != nil {
 		return nil, fmt.Errorf("fetchGithubRepositories failed: %w", err)
 	}
 
-	auth, _ := ctx.Value(Authorization{}).(Authorization)
+	return enrichStats(ctx, params, repositories), nil
+}
+
+// enrichStats
+// Fetch the stats of the repositories with the configured backend
+// what the graphql backend could not fetch is fetched by the workers with the REST api
+func enrichStats(ctx context.Context, params url.Values, repositories []github.Repository) []Stats {
+	log := logger.Get(ctx)
 
-	for _, repository := range repositories {
-		workerStatsTasks <- WorkerStatsTask{
-			auth:       auth,
-			params:     params,
-			repository: repository,
-			stats:      stats,
-		}
+	if statsBackend != statsBackendGraphQL {
+		return enrichStatsWithWorkers(ctx, params, repositories)
 	}
 
-	results := make([]Stats, 0, len(repositories))
+	results, remaining, err := enrichStatsWithGraphQL(ctx, params, repositories)
+	if err != nil {
+		log.WithError(err).Warn("graphql enrichment failed, falling back to REST")
+		return enrichStatsWithWorkers(ctx, params, repositories)
+	}
 
-	eventCount := len(repositories)
+	if len(remaining) > 0 {
+		log.Infof("%d repositories not found with graphql, falling back to REST", len(remaining))
+		results = append(results, enrichStatsWithWorkers(ctx, params, remaining)...)
+	}
 
-	for eventCount > 0 {
-		select {
-		case stat := <-stats:
-			eventCount -= 1
-
-			if stat.Err != nil {
-				if errors.Is(stat.Err, WorkerDiscardRepository{}) {
-					log.Debug(stat.Err.Error())
-				} else {
-					log.Warnf("error fetching stats: %w", stat.Err)
-				}
-				break
+	return results
+}
+
+// enrichStatsWithWorkers
+// Queue a task per repository and wait for the workers to process all of them
+func enrichStatsWithWorkers(ctx context.Context, params url.Values, repositories []github.Repository) []Stats {
+	log := logger.Get(ctx)
+
+	results := make([]Stats, 0, len(repositories))
+
+	for _, stat := range runStatsTasks(ctx, params, repositories) {
+		if stat.Err != nil {
+			if errors.Is(stat.Err, WorkerDiscardRepository{}) {
+				log.Debug(stat.Err.Error())
+			} else {
+				log.Warnf("error fetching stats: %v", stat.Err)
 			}
+			continue
+		}
+
+		results = append(results, stat.Stats)
+	}
+
+	return results
+}
 
-			results = append(results, stat.Stats)
+// runStatsTasks
+// Queue a task per repository and wait for the workers to process all of them
+// Returns the result of each task, in the order of the repositories
+func runStatsTasks(ctx context.Context, params url.Values, repositories []github.Repository) []WorkerStats {
+	results := make([]WorkerStats, len(repositories))
+
+	streamStatsTasks(ctx, params, repositories, func(stat WorkerStats) {
+		results[stat.Index] = stat
+	})
+
+	return results
+}
+
+// streamStatsTasks
+// Queue a task per repository and call fn with each result as soon as a worker is done with it
+// Returns once every task is processed
+func streamStatsTasks(ctx context.Context, params url.Values, repositories []github.Repository, fn func(stat WorkerStats)) {
+	// buffered so the workers never wait for us to read a result
+	// we may still be queueing the tasks when there are more than the queue can hold
+	stats := make(chan WorkerStats, len(repositories))
+	defer close(stats)
+
+	for i, repository := range repositories {
+		workerStatsTasks <- WorkerStatsTask{
+			ctx:        ctx,
+			index:      i,
+			params:     params,
+			repository: repository,
+			stats:      stats,
 		}
 	}
 
-	return results, nil
+	for eventCount := len(repositories); eventCount > 0; eventCount-- {
+		fn(<-stats)
+	}
 }
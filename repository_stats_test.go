@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+// getStats
+// Call /stats, returns the status and the stats sorted by name, or the error message
+func getStats(t *testing.T, query string) (int, []Stats, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/stats?"+query, nil).WithContext(testContext())
+	res := httptest.NewRecorder()
+	_ = statsHandlerGet(res, req, nil)
+
+	if res.Code != http.StatusOK {
+		var body map[string]string
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		return res.Code, nil, body["error"]
+	}
+
+	var stats []Stats
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Owner+"/"+stats[i].Name < stats[j].Owner+"/"+stats[j].Name
+	})
+	return res.Code, stats, ""
+}
+
+func TestStatsInjectedError(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 7, FirstID: 1, Count: 10})
+	repositories := server.Repositories()
+
+	// a repository github fails to return is left out
+	server.InjectError(githubfake.ErrorRule{Path: "/repos/" + repositories[2].FullName() + "/languages", Status: http.StatusBadGateway, Times: 1})
+
+	status, stats, _ := getStats(t, "since=0")
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if len(stats) != len(repositories)-1 {
+		t.Errorf("expected the stats of %d repositories, got %d", len(repositories)-1, len(stats))
+	}
+	for _, stat := range stats {
+		if stat.Owner+"/"+stat.Name == repositories[2].FullName() {
+			t.Errorf("%s is returned without its languages", repositories[2].FullName())
+		}
+	}
+
+	// the list itself failing fails the query
+	server.InjectError(githubfake.ErrorRule{Path: "/repositories", Status: http.StatusBadGateway, Times: 1})
+
+	status, _, message := getStats(t, "since=1")
+	if status != http.StatusInternalServerError || !strings.Contains(message, "Bad Gateway") {
+		t.Errorf("expected a 500 with the error of github, got %d %q", status, message)
+	}
+}
+
+func TestStatsRateLimited(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 8, FirstID: 1, Count: 10, RateLimit: 1})
+
+	// the list takes the only call left, the stats of the repositories are refused
+	status, stats, _ := getStats(t, "since=1")
+	if status != http.StatusOK || len(stats) != 0 {
+		t.Errorf("expected no stats once the rate limit is exhausted, got %d with %d stats", status, len(stats))
+	}
+
+	status, _, message := getStats(t, "since=0")
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 when github refuses the list, got %d %q", status, message)
+	}
+	if used := server.RateLimit().Used; used != 1 {
+		t.Errorf("expected a single call counted, got %d", used)
+	}
+}
+
+func TestStatsRevalidation(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 9, FirstID: 1, Count: 10, RateLimit: 100})
+
+	status, first, _ := getStats(t, "since=0")
+	if status != http.StatusOK || len(first) != 10 {
+		t.Fatalf("expected the stats of 10 repositories, got %d with %d stats", status, len(first))
+	}
+	used, requests := server.RateLimit().Used, server.Requests()
+	if used != 1+2*10 {
+		t.Errorf("expected the list and 2 calls per repository, got %d", used)
+	}
+
+	// every call is revalidated with its ETag, github answers 304 and doesn't count them
+	status, second, _ := getStats(t, "since=0")
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("the revalidated stats differ:\n%+v\n%+v", first, second)
+	}
+	if calls := server.Requests() - requests; calls != used {
+		t.Errorf("expected the %d calls sent again, got %d", used, calls)
+	}
+	if server.RateLimit().Used != used {
+		t.Errorf("the revalidated calls were counted: %d used, %d before", server.RateLimit().Used, used)
+	}
+}
+
+// waitStatsWorkers
+// Wait until count stats workers are running
+func waitStatsWorkers(t *testing.T, count int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for workerStatsRunning.Load() != int32(count) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d stats workers running, expected %d", workerStatsRunning.Load(), count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestResizeStatsWorkersDoesNotWaitForBusyWorkers(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 1, Count: 8, Latency: 300 * time.Millisecond})
+	count := currentConfig().WorkerCount
+	t.Cleanup(func() {
+		resizeStatsWorkers(testContext(), count)
+		waitStatsWorkers(t, count)
+	})
+
+	resizeStatsWorkers(testContext(), 4)
+	waitStatsWorkers(t, 4)
+
+	// every worker waits for github
+	done := make(chan []Stats)
+	go func() {
+		done <- enrichStatsWithWorkers(testContext(), url.Values{}, listedRepositories(server))
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	resizeStatsWorkers(testContext(), 1)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("the resize waited %s for the busy workers", elapsed)
+	}
+	if target := workerStatsTarget.Load(); target != 1 {
+		t.Errorf("target of %d workers, expected 1", target)
+	}
+
+	// the workers stop once done with their task, the last one processes the rest of the queue
+	waitStatsWorkers(t, 1)
+	if stats := <-done; len(stats) != 8 {
+		t.Errorf("expected the stats of the 8 repositories, got %d", len(stats))
+	}
+
+	// idle workers stop too
+	resizeStatsWorkers(testContext(), 3)
+	waitStatsWorkers(t, 3)
+	time.Sleep(50 * time.Millisecond)
+	resizeStatsWorkers(testContext(), 2)
+	waitStatsWorkers(t, 2)
+}
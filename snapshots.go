@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// TrackedRepositories
+// The repositories refreshed periodically by the snapshot scheduler, by full name
+type TrackedRepositories struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+func newTrackedRepositories(names []string) *TrackedRepositories {
+	tracked := &TrackedRepositories{names: map[string]string{}}
+	for _, name := range names {
+		if name != "" {
+			tracked.Add(name, 0)
+		}
+	}
+	return tracked
+}
+
+// repositories refreshed by the snapshot scheduler, set in main
+var trackedRepositories = newTrackedRepositories(nil)
+
+// Add
+// Track the repository, unless limit repositories are tracked already, 0 for no limit
+// Returns false if the limit is reached, a repository tracked already is always accepted
+func (tracked *TrackedRepositories) Add(name string, limit int) bool {
+	tracked.mu.Lock()
+	defer tracked.mu.Unlock()
+
+	key := repositoryKey(name)
+	if _, ok := tracked.names[key]; !ok && limit > 0 && len(tracked.names) >= limit {
+		return false
+	}
+	tracked.names[key] = name
+
+	return true
+}
+
+func (tracked *TrackedRepositories) Remove(name string) bool {
+	tracked.mu.Lock()
+	defer tracked.mu.Unlock()
+
+	_, ok := tracked.names[repositoryKey(name)]
+	delete(tracked.names, repositoryKey(name))
+
+	return ok
+}
+
+func (tracked *TrackedRepositories) List() []string {
+	tracked.mu.Lock()
+	defer tracked.mu.Unlock()
+
+	names := make([]string, 0, len(tracked.names))
+	for _, name := range tracked.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// startSnapshotScheduler
+// Refresh the tracked repositories now then every interval until the context is done
+func startSnapshotScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		refreshTrackedRepositories(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshTrackedRepositories
+// Fetch the tracked repositories with the stats workers
+// and record a snapshot of each repository fetched, only the tracked repositories have a history
+func refreshTrackedRepositories(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "refreshTrackedRepositories")
+	defer span.End()
+
+	log := logger.Get(ctx)
+
+	names := trackedRepositories.List()
+	if len(names) == 0 {
+		return
+	}
+
+	var repositories []github.Repository
+	for _, name := range names {
+		owner, repositoryName, err := parseRepositoryName(name)
+		if err != nil {
+			log.WithError(err).Warn("invalid tracked repository")
+			continue
+		}
+		repositories = append(repositories, lookupRepository(owner, repositoryName))
+	}
+
+	failed := 0
+	now := time.Now()
+	for i, stat := range runStatsTasks(ctx, url.Values{}, repositories) {
+		if stat.Err != nil {
+			failed++
+			log.WithError(stat.Err).Warnf("fail to refresh tracked repository %s", repositories[i].FullName)
+			continue
+		}
+		repositoryStore.Snapshot(repositories[i].FullName, now)
+	}
+
+	span.SetAttributes(
+		attribute.Int("snapshots.repositories", len(repositories)),
+		attribute.Int("snapshots.failed", failed),
+	)
+	log.Infof("refreshed %d tracked repositories, %d failed", len(repositories), failed)
+}
+
+// parsePeriod
+// A duration as time.ParseDuration accepts it, or a number of days: 7d
+func parsePeriod(period string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(period, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil || count <= 0 {
+			return 0, fmt.Errorf("invalid period `%s`", period)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(period)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid period `%s`", period)
+	}
+
+	return duration, nil
+}
+
+// Growth
+// How much a repository grew over a period
+type Growth struct {
+	Repository string    `json:"repository"`
+	Since      time.Time `json:"since"`
+	StarsThen  int       `json:"stars_then"`
+	StarsNow   int       `json:"stars_now"`
+	StarsGrown int       `json:"stars_grown"`
+	ForksGrown int       `json:"forks_grown"`
+}
+
+// starsLeaderboard
+// The repositories which gained the most stars since the given time
+// compares the last snapshot with the first one taken after since
+// repositories with less than 2 snapshots in the period are left out
+func starsLeaderboard(store *RepositoryStore, since time.Time, limit int) []Growth {
+	leaderboard := []Growth{}
+
+	store.Each(func(stored StoredRepository) {
+		var first, last *Snapshot
+		for i := range stored.Snapshots {
+			if stored.Snapshots[i].At.Before(since) {
+				continue
+			}
+			if first == nil {
+				first = &stored.Snapshots[i]
+			}
+			last = &stored.Snapshots[i]
+		}
+
+		if first == nil || first == last {
+			return
+		}
+
+		leaderboard = append(leaderboard, Growth{
+			Repository: fullName(stored.Repository),
+			Since:      first.At,
+			StarsThen:  first.StargazersCount,
+			StarsNow:   last.StargazersCount,
+			StarsGrown: last.StargazersCount - first.StargazersCount,
+			ForksGrown: int(last.ForksCount) - int(first.ForksCount),
+		})
+	})
+
+	sort.Slice(leaderboard, func(i, j int) bool {
+		if leaderboard[i].StarsGrown != leaderboard[j].StarsGrown {
+			return leaderboard[i].StarsGrown > leaderboard[j].StarsGrown
+		}
+		return leaderboard[i].Repository < leaderboard[j].Repository
+	})
+
+	if limit > 0 && len(leaderboard) > limit {
+		leaderboard = leaderboard[:limit]
+	}
+
+	return leaderboard
+}
+
+// repositoryHistoryHandlerGet
+// The snapshots of a repository, `period` keeps the last ones only: 24h, 7d...
+func repositoryHistoryHandlerGet(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	log := logger.Get(r.Context())
+
+	name := vars["owner"] + "/" + vars["name"]
+
+	stored, ok := repositoryStore.Get(name)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotFound, fmt.Sprintf("no history for repository `%s`", name))
+		return nil
+	}
+
+	snapshots := stored.Snapshots
+	if periodParam := r.URL.Query().Get("period"); periodParam != "" {
+		period, err := parsePeriod(periodParam)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, err.Error())
+			return nil
+		}
+
+		since := time.Now().Add(-period)
+		snapshots = []Snapshot{}
+		for _, snapshot := range stored.Snapshots {
+			if !snapshot.At.Before(since) {
+				snapshots = append(snapshots, snapshot)
+			}
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(map[string]any{
+		"repository": fullName(stored.Repository),
+		"snapshots":  snapshots,
+	})
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
+
+// starsLeaderboardHandlerGet
+// The fastest growing repositories over `period` (24h by default), `limit` of them (10 by default)
+func starsLeaderboardHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+	log := logger.Get(r.Context())
+
+	periodParam := r.URL.Query().Get("period")
+	if periodParam == "" {
+		periodParam = "24h"
+	}
+
+	period, err := parsePeriod(periodParam)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	limit := 10
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid limit `%s`", limitParam))
+			return nil
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(starsLeaderboard(repositoryStore, time.Now().Add(-period), limit))
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
+
+// trackedRepositoriesHandlerGet
+// The repositories refreshed by the snapshot scheduler
+func trackedRepositoriesHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+	log := logger.Get(r.Context())
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(trackedRepositories.List())
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
+
+// repositoryTrackHandlerPut
+// Start tracking a repository github knows, it's refreshed with the next run of the scheduler
+// admin only, each tracked repository costs github calls on every run
+func repositoryTrackHandlerPut(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if !requireAPIKeyAdmin(w, r) {
+		return nil
+	}
+
+	name := vars["owner"] + "/" + vars["name"]
+
+	owner, repositoryName, err := parseRepositoryName(name)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	repository, err := fetchGithubRepository(withCallerAuthorization(r), owner, repositoryName)
+	if isNotFound(err) {
+		writeJSONError(w, r, http.StatusNotFound, fmt.Sprintf("repository `%s` not found", name))
+		return nil
+	}
+	if isRateLimited(err) {
+		writeGithubRateLimited(w, r, err)
+		return nil
+	}
+	if err != nil {
+		writeJSONError(w, r, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+
+	limit := currentConfig().SnapshotMaxTracked
+	if !trackedRepositories.Add(fullName(repository), limit) {
+		writeJSONError(w, r, http.StatusConflict, fmt.Sprintf("%d repositories are tracked already, the most SNAPSHOT_MAX_TRACKED allows", limit))
+		return nil
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// repositoryTrackHandlerDelete
+// Stop tracking a repository, its history is kept, admin only
+func repositoryTrackHandlerDelete(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if !requireAPIKeyAdmin(w, r) {
+		return nil
+	}
+
+	name := vars["owner"] + "/" + vars["name"]
+
+	if !trackedRepositories.Remove(name) {
+		writeJSONError(w, r, http.StatusNotFound, fmt.Sprintf("repository `%s` is not tracked", name))
+		return nil
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+// useTrackedRepositories
+// The scheduler refreshes these repositories until the end of the test
+func useTrackedRepositories(t *testing.T, names ...string) *TrackedRepositories {
+	t.Helper()
+
+	previous := trackedRepositories
+	trackedRepositories = newTrackedRepositories(names)
+	t.Cleanup(func() { trackedRepositories = previous })
+
+	return trackedRepositories
+}
+
+// asAdmin
+// A request authenticated with a key of API_ADMIN_KEYS, or with another key when admin is false
+func asAdmin(t *testing.T, req *http.Request, admin bool) *http.Request {
+	t.Helper()
+
+	store := &APIKeyStore{stored: map[string]storedAPIKey{}}
+	store.SetConfigured([]APIKey{configuredAPIKey("ops", "ops-key"), configuredAPIKey("reader", "reader-key")}, []string{"ops"})
+
+	previous := apiKeyStore
+	apiKeyStore = store
+	t.Cleanup(func() { apiKeyStore = previous })
+
+	client := "reader"
+	if admin {
+		client = "ops"
+	}
+	return req.WithContext(context.WithValue(req.Context(), APIClientKey{}, client))
+}
+
+// callTrack
+// Call PUT or DELETE /repos/{owner}/{name}/track
+func callTrack(t *testing.T, method, owner, name string, admin bool) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, "/repos/"+owner+"/"+name+"/track", nil).WithContext(testContext())
+	req = asAdmin(t, req, admin)
+	res := httptest.NewRecorder()
+
+	handler := repositoryTrackHandlerPut
+	if method == http.MethodDelete {
+		handler = repositoryTrackHandlerDelete
+	}
+	_ = handler(res, req, map[string]string{"owner": owner, "name": name})
+
+	return res
+}
+
+// storeSnapshots
+// A repository with a snapshot of each stars count, an hour apart and the last one at now
+func storeSnapshots(store *RepositoryStore, name string, now time.Time, stars ...int) {
+	store.Put(newTestRepository(name, 0))
+	for i, count := range stars {
+		store.Update(name, func(stored *StoredRepository) { stored.Repository.StargazersCount = count })
+		store.Snapshot(name, now.Add(time.Duration(i-len(stars)+1)*time.Hour))
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	cases := map[string]time.Duration{
+		"24h":  24 * time.Hour,
+		"90m":  90 * time.Minute,
+		"7d":   7 * 24 * time.Hour,
+		"0d":   0,
+		"-1h":  0,
+		"d":    0,
+		"week": 0,
+	}
+	for period, expected := range cases {
+		duration, err := parsePeriod(period)
+		if (err == nil) != (expected != 0) || duration != expected {
+			t.Errorf("%q: expected %s, got %s: %v", period, expected, duration, err)
+		}
+	}
+}
+
+func TestTrackedRepositoriesLimit(t *testing.T) {
+	tracked := newTrackedRepositories([]string{"octo/cat", "octo/dog"})
+
+	if tracked.Add("octo/bird", 2) {
+		t.Error("a repository was tracked past the limit")
+	}
+	if !tracked.Add("Octo/Cat", 2) {
+		t.Error("a repository tracked already was refused")
+	}
+	if !tracked.Add("octo/bird", 0) {
+		t.Error("a repository was refused without limit")
+	}
+	if names := tracked.List(); !reflect.DeepEqual(names, []string{"Octo/Cat", "octo/bird", "octo/dog"}) {
+		t.Errorf("got %v", names)
+	}
+}
+
+func TestRepositoryTrack(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 6, Count: 5})
+	repositories := server.Repositories()
+	useTrackedRepositories(t)
+
+	cfg := *currentConfig()
+	cfg.SnapshotMaxTracked = 1
+	runningConfig.Store(&cfg)
+
+	first, second := repositories[0], repositories[1]
+	cases := []struct {
+		name   string
+		method string
+		owner  string
+		repo   string
+		admin  bool
+		status int
+	}{
+		{name: "not admin", method: http.MethodPut, owner: first.Owner, repo: first.Name, status: http.StatusForbidden},
+		{name: "invalid", method: http.MethodPut, owner: "", repo: first.Name, admin: true, status: http.StatusBadRequest},
+		{name: "unknown", method: http.MethodPut, owner: "nobody", repo: "nothing", admin: true, status: http.StatusNotFound},
+		{name: "tracked", method: http.MethodPut, owner: first.Owner, repo: first.Name, admin: true, status: http.StatusNoContent},
+		{name: "tracked again", method: http.MethodPut, owner: first.Owner, repo: first.Name, admin: true, status: http.StatusNoContent},
+		{name: "past the limit", method: http.MethodPut, owner: second.Owner, repo: second.Name, admin: true, status: http.StatusConflict},
+		{name: "untracked by a non admin", method: http.MethodDelete, owner: first.Owner, repo: first.Name, status: http.StatusForbidden},
+		{name: "not tracked", method: http.MethodDelete, owner: second.Owner, repo: second.Name, admin: true, status: http.StatusNotFound},
+	}
+	for _, c := range cases {
+		res := callTrack(t, c.method, c.owner, c.repo, c.admin)
+		if res.Code != c.status {
+			t.Errorf("%s: expected a %d, got %d %s", c.name, c.status, res.Code, res.Body.String())
+		}
+	}
+	if names := trackedRepositories.List(); !reflect.DeepEqual(names, []string{first.FullName()}) {
+		t.Fatalf("expected %s tracked, got %v", first.FullName(), names)
+	}
+
+	// the next run of the scheduler starts its history
+	refreshTrackedRepositories(testContext())
+	if stored, ok := repositoryStore.Get(first.FullName()); !ok || len(stored.Snapshots) != 1 || stored.Snapshots[0].StargazersCount != first.Stars {
+		t.Errorf("expected a snapshot of %d stars, got %+v", first.Stars, stored.Snapshots)
+	}
+
+	if res := callTrack(t, http.MethodDelete, first.Owner, first.Name, true); res.Code != http.StatusNoContent {
+		t.Errorf("expected a 204, got %d %s", res.Code, res.Body.String())
+	}
+	if res := callTrack(t, http.MethodPut, second.Owner, second.Name, true); res.Code != http.StatusNoContent {
+		t.Errorf("expected a 204 once under the limit, got %d %s", res.Code, res.Body.String())
+	}
+}
+
+func TestStarsLeaderboard(t *testing.T) {
+	store := newRepositoryStore(48*time.Hour, 0)
+	now := time.Now()
+
+	storeSnapshots(store, "octo/slow", now, 10, 11, 12)
+	storeSnapshots(store, "octo/fast", now, 10, 20, 40)
+	storeSnapshots(store, "octo/same", now, 5, 15, 15)
+	storeSnapshots(store, "octo/single", now, 1000)
+	// grew a lot, but before the period
+	storeSnapshots(store, "octo/old", now.Add(-30*time.Hour), 0, 500, 500)
+
+	leaderboard := starsLeaderboard(store, now.Add(-24*time.Hour), 0)
+
+	names := []string{}
+	for _, growth := range leaderboard {
+		names = append(names, growth.Repository)
+	}
+	if !reflect.DeepEqual(names, []string{"octo/fast", "octo/same", "octo/slow"}) {
+		t.Fatalf("got %v", names)
+	}
+	if fast := leaderboard[0]; fast.StarsThen != 10 || fast.StarsNow != 40 || fast.StarsGrown != 30 || !fast.Since.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("got %+v", fast)
+	}
+
+	// the period only keeps the last snapshots
+	if leaderboard := starsLeaderboard(store, now.Add(-90*time.Minute), 1); len(leaderboard) != 1 || leaderboard[0].Repository != "octo/fast" || leaderboard[0].StarsGrown != 20 {
+		t.Errorf("expected octo/fast grown by 20 over the last hour, got %+v", leaderboard)
+	}
+}
+
+func TestStarsLeaderboardHandler(t *testing.T) {
+	newTestGithub(t, githubfake.Options{})
+	storeSnapshots(repositoryStore, "octo/cat", time.Now(), 1, 3)
+
+	for query, expected := range map[string]int{"": http.StatusOK, "period=7d&limit=5": http.StatusOK, "period=soon": http.StatusBadRequest, "limit=ten": http.StatusBadRequest} {
+		req := httptest.NewRequest(http.MethodGet, "/stars/leaderboard?"+query, nil).WithContext(testContext())
+		res := httptest.NewRecorder()
+		_ = starsLeaderboardHandlerGet(res, req, nil)
+		if res.Code != expected {
+			t.Errorf("%q: expected a %d, got %d %s", query, expected, res.Code, res.Body.String())
+			continue
+		}
+		if expected != http.StatusOK {
+			continue
+		}
+
+		var leaderboard []Growth
+		if err := json.NewDecoder(res.Body).Decode(&leaderboard); err != nil {
+			t.Fatal(err)
+		}
+		if len(leaderboard) != 1 || leaderboard[0].StarsGrown != 2 {
+			t.Errorf("%q: got %+v", query, leaderboard)
+		}
+	}
+}
+
+func TestRepositoryHistory(t *testing.T) {
+	newTestGithub(t, githubfake.Options{})
+	now := time.Now()
+	storeSnapshots(repositoryStore, "octo/cat", now, 1, 2, 3, 4)
+
+	getHistory := func(name, query string) (int, []Snapshot) {
+		req := httptest.NewRequest(http.MethodGet, "/repos/octo/"+name+"/history?"+query, nil).WithContext(testContext())
+		res := httptest.NewRecorder()
+		_ = repositoryHistoryHandlerGet(res, req, map[string]string{"owner": "octo", "name": name})
+
+		var history struct {
+			Snapshots []Snapshot `json:"snapshots"`
+		}
+		if res.Code == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&history); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.Code, history.Snapshots
+	}
+
+	if status, snapshots := getHistory("cat", ""); status != http.StatusOK || len(snapshots) != 4 {
+		t.Errorf("expected the 4 snapshots, got %d %+v", status, snapshots)
+	}
+	if status, snapshots := getHistory("cat", "period=90m"); status != http.StatusOK || len(snapshots) != 2 || snapshots[0].StargazersCount != 3 {
+		t.Errorf("expected the 2 snapshots of the last 90 minutes, got %d %+v", status, snapshots)
+	}
+	if status, _ := getHistory("cat", "period=never"); status != http.StatusBadRequest {
+		t.Errorf("expected a 400 for an invalid period, got %d", status)
+	}
+	if status, _ := getHistory("dog", ""); status != http.StatusNotFound {
+		t.Errorf("expected a 404 for an unknown repository, got %d", status)
+	}
+
+	// the snapshots past the retention are pruned from the history
+	repositoryStore.Prune(now.Add(repositoryStore.retention - 90*time.Minute))
+	if status, snapshots := getHistory("cat", ""); status != http.StatusOK || len(snapshots) != 2 {
+		t.Errorf("expected the 2 snapshots in the retention left, got %d %+v", status, snapshots)
+	}
+}
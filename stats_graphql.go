@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	statsBackendREST    = "rest"
+	statsBackendGraphQL = "graphql"
+)
+
+// how /stats fetches the stars, license and languages of the repositories, set in main
+var statsBackend = statsBackendREST
+
+// graphql api of github, set in main
+var githubGraphQLURL = githubAPIURL + "/graphql"
+
+// github accepts up to 100 aliased repositories in a query without hitting the node limit
+const graphQLBatchSize = 100
+
+// enrichStatsWithGraphQL
+// Fetch the stats of the repositories with one aliased graphql query per batch of 100
+// instead of 2 REST calls per repository
+// Returns the stats and the repositories graphql could not return, those are left to the REST workers
+func enrichStatsWithGraphQL(ctx context.Context, params url.Values, repositories []github.Repository) ([]Stats, []github.Repository, error) {
+	ctx, span := tracer.Start(ctx, "enrichStatsWithGraphQL")
+	defer span.End()
+
+	log := logger.Get(ctx)
+
+	results := make([]Stats, 0, len(repositories))
+	var remaining []github.Repository
+
+	for start := 0; start < len(repositories); start += graphQLBatchSize {
+		end := start + graphQLBatchSize
+		if end > len(repositories) {
+			end = len(repositories)
+		}
+		batch := repositories[start:end]
+
+		found, err := fetchGraphQLRepositories(ctx, batch)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for i, repository := range batch {
+			graphQLRepository, ok := found[i]
+			if !ok {
+				remaining = append(remaining, repository)
+				continue
+			}
+
+			license := ""
+			if graphQLRepository.LicenseInfo != nil {
+				license = graphQLRepository.LicenseInfo.Key
+			}
+
+			languages := make(map[string]int, len(graphQLRepository.Languages.Edges))
+			for _, edge := range graphQLRepository.Languages.Edges {
+				languages[edge.Node.Name] = edge.Size
+			}
+
+			topics := make([]string, 0, len(graphQLRepository.RepositoryTopics.Nodes))
+			for _, node := range graphQLRepository.RepositoryTopics.Nodes {
+				topics = append(topics, node.Topic.Name)
+			}
+
+			stats := newStats(repository, graphQLRepository.StargazerCount, license, languages, topics)
+
+			// the repositories listed don't have their creation date, the time series need it
+			repository.CreatedAt = graphQLRepository.CreatedAt
+			repositoryStore.PutStats(repository, stats)
+
+			if err := filterStats(params, stats); err != nil {
+				log.Debug(err.Error())
+				continue
+			}
+
+			results = append(results, stats)
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int("github.graphql.found", len(repositories)-len(remaining)),
+		attribute.Int("github.graphql.remaining", len(remaining)),
+	)
+
+	return results, remaining, nil
+}
+
+// fetchGraphQLRepositories
+// One query for the whole batch, each repository aliased by its index: r0, r1...
+// Returns the repositories found by index in the batch
+func fetchGraphQLRepositories(ctx context.Context, batch []github.Repository) (map[int]github.GraphQLRepository, error) {
+	var query strings.Builder
+	variables := map[string]any{}
+
+	declarations := make([]string, 0, 2*len(batch))
+	for i := range batch {
+		declarations = append(declarations, fmt.Sprintf("$o%d: String!, $n%d: String!", i, i))
+	}
+
+	fmt.Fprintf(&query, "query(%s) {\n", strings.Join(declarations, ", "))
+	for i, repository := range batch {
+		fmt.Fprintf(&query, "  r%d: repository(owner: $o%d, name: $n%d) { ...stats }\n", i, i, i)
+
+		variables[fmt.Sprintf("o%d", i)] = repository.Owner.Login
+		variables[fmt.Sprintf("n%d", i)] = repository.Name
+	}
+	query.WriteString(`}
+
+fragment stats on Repository {
+  stargazerCount
+  createdAt
+  licenseInfo { key }
+  languages(first: 100) { edges { size node { name } } }
+  repositoryTopics(first: 20) { nodes { topic { name } } }
+}
+`)
+
+	httpRequest := HttpRequest{
+		Method: http.MethodPost,
+		Url:    githubGraphQLURL,
+		Body: map[string]any{
+			"query":     query.String(),
+			"variables": variables,
+		},
+	}
+
+	var response github.GraphQLResponse[map[string]*github.GraphQLRepository]
+
+	_, err := httpRequest.Do(ctx, &response)
+	if err != nil {
+		return nil, fmt.Errorf("graphql query failed: %w", err)
+	}
+
+	// errors without any data means the whole query failed (bad credentials, rate limit...)
+	// with data they are about single repositories, usually not found
+	if len(response.Errors) > 0 && response.Data == nil {
+		messages := make([]string, 0, len(response.Errors))
+		for _, graphQLError := range response.Errors {
+			messages = append(messages, graphQLError.Message)
+		}
+		return nil, errors.New("graphql query failed: " + strings.Join(messages, ", "))
+	}
+
+	found := make(map[int]github.GraphQLRepository, len(batch))
+	for i := range batch {
+		repository, ok := response.Data[fmt.Sprintf("r%d", i)]
+		if ok && repository != nil {
+			found[i] = *repository
+		}
+	}
+
+	return found, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+// useGraphQLStats
+// The stats are fetched with the graphql backend until the end of the test
+func useGraphQLStats(t *testing.T) {
+	t.Helper()
+
+	previous := statsBackend
+	statsBackend = statsBackendGraphQL
+	t.Cleanup(func() { statsBackend = previous })
+}
+
+// listedRepositories
+// The repositories of the fake as /repositories lists them
+func listedRepositories(server *githubfake.Server) []github.Repository {
+	var repositories []github.Repository
+	for _, fake := range server.Repositories() {
+		var repository github.Repository
+		repository.Id = uint(fake.ID)
+		repository.Name = fake.Name
+		repository.FullName = fake.FullName()
+		repository.Owner.Login = fake.Owner
+		repository.Url = server.URL + "/repos/" + fake.FullName()
+		repository.LanguagesUrl = repository.Url + "/languages"
+		repositories = append(repositories, repository)
+	}
+	return repositories
+}
+
+// statsByName
+// The stats by full name, to compare them whatever backend fetched them
+func statsByName(stats []Stats) map[string]Stats {
+	byName := make(map[string]Stats, len(stats))
+	for _, stat := range stats {
+		byName[stat.Owner+"/"+stat.Name] = stat
+	}
+	return byName
+}
+
+func TestEnrichStatsWithGraphQLBatches(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 1, Count: 150})
+	repositories := listedRepositories(server)
+
+	stats, remaining, err := enrichStatsWithGraphQL(testContext(), url.Values{}, repositories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("expected every repository to be found, %d remaining", len(remaining))
+	}
+
+	queries := server.GraphQLQueries()
+	if len(queries) != 2 || queries[0] != graphQLBatchSize || queries[1] != 50 {
+		t.Errorf("expected a query of 100 repositories and one of 50, got %v", queries)
+	}
+	if server.Requests() != 2 {
+		t.Errorf("expected 2 calls to github, got %d", server.Requests())
+	}
+
+	byName := statsByName(stats)
+	if len(byName) != len(repositories) {
+		t.Fatalf("expected the stats of %d repositories, got %d", len(repositories), len(byName))
+	}
+	for _, fake := range server.Repositories() {
+		stat := byName[fake.FullName()]
+		if stat.StarCount != fake.Stars || stat.License != fake.License || len(stat.Languages) != len(fake.Languages) {
+			t.Errorf("%s: got %+v, expected %d stars, license %q, languages %v", fake.FullName(), stat, fake.Stars, fake.License, fake.Languages)
+		}
+		for language, size := range fake.Languages {
+			if stat.Languages[language] != size {
+				t.Errorf("%s: %s is %d bytes, expected %d", fake.FullName(), language, stat.Languages[language], size)
+			}
+		}
+	}
+}
+
+func TestEnrichStatsGraphQLPartialErrors(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 2, Count: 10})
+	useGraphQLStats(t)
+	repositories := listedRepositories(server)
+
+	server.HideFromGraphQL(repositories[3].FullName)
+	server.HideFromGraphQL(repositories[7].FullName)
+
+	_, remaining, err := enrichStatsWithGraphQL(testContext(), url.Values{}, repositories)
+	if err != nil {
+		t.Fatalf("a repository not found must not fail the whole query: %v", err)
+	}
+	if len(remaining) != 2 || remaining[0].FullName != repositories[3].FullName || remaining[1].FullName != repositories[7].FullName {
+		t.Errorf("expected the 2 hidden repositories to remain, got %v", remaining)
+	}
+
+	// the repositories graphql did not resolve are fetched with REST: 2 calls each
+	before := server.Requests()
+	stats := enrichStats(testContext(), url.Values{}, repositories)
+	if calls := server.Requests() - before; calls != 1+2*2 {
+		t.Errorf("expected a graphql query and 4 REST calls, got %d calls", calls)
+	}
+	if len(stats) != len(repositories) {
+		t.Errorf("expected the stats of %d repositories, got %d", len(repositories), len(stats))
+	}
+}
+
+func TestEnrichStatsGraphQLFallback(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 3, Count: 10})
+	useGraphQLStats(t)
+	repositories := listedRepositories(server)
+
+	server.InjectError(githubfake.ErrorRule{Path: "/graphql", Status: http.StatusBadGateway})
+
+	stats := enrichStats(testContext(), url.Values{}, repositories)
+	if len(stats) != len(repositories) {
+		t.Fatalf("expected every repository fetched with REST, got %d stats", len(stats))
+	}
+	if calls := server.Requests(); calls != 1+2*len(repositories) {
+		t.Errorf("expected the failed graphql query and 2 REST calls per repository, got %d calls", calls)
+	}
+
+	byName := statsByName(stats)
+	for _, fake := range server.Repositories() {
+		if stat := byName[fake.FullName()]; stat.StarCount != fake.Stars || stat.License != fake.License {
+			t.Errorf("%s: got %+v, expected %d stars and license %q", fake.FullName(), stat, fake.Stars, fake.License)
+		}
+	}
+}
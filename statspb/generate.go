@@ -0,0 +1,5 @@
+// Package statspb
+// gRPC definition of the service, the code is generated from stats.proto
+package statspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative stats.proto
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+)
+
+// StoredRepository
+// What we know of a repository: the last github record we got, the last stats computed
+// and the counters of each complete record we got, oldest first
+type StoredRepository struct {
+	Repository github.Repository `json:"repository"`
+	Stats      *Stats            `json:"stats,omitempty"`
+	Snapshots  []Snapshot        `json:"-"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// Snapshot
+// Counters of a repository at some point in time
+type Snapshot struct {
+	At              time.Time `json:"at"`
+	StargazersCount int       `json:"stars_count"`
+	ForksCount      uint      `json:"forks_count"`
+	OpenIssuesCount uint      `json:"open_issues_count"`
+	Size            uint      `json:"size"`
+}
+
+// RepositoryStore
+// The repositories seen by the service, by full name
+// fed by the fetches made for /repos and /stats, and kept up to date by the github webhooks
+// past maxRepositories the least recently updated ones are evicted, the ones with a history are kept
+type RepositoryStore struct {
+	mu           sync.RWMutex
+	repositories map[string]*StoredRepository
+	// keys from the most to the least recently updated
+	recent   *list.List
+	elements map[string]*list.Element
+	// 0 for no limit
+	maxRepositories int
+	// snapshots older than this are dropped
+	retention time.Duration
+}
+
+func newRepositoryStore(retention time.Duration, maxRepositories int) *RepositoryStore {
+	return &RepositoryStore{
+		repositories:    map[string]*StoredRepository{},
+		recent:          list.New(),
+		elements:        map[string]*list.Element{},
+		maxRepositories: maxRepositories,
+		retention:       retention,
+	}
+}
+
+// repositories known by the service, set in initApp
+var repositoryStore = newRepositoryStore(30*24*time.Hour, 100000)
+
+// how often the snapshots past the retention are dropped
+const storePruneInterval = 10 * time.Minute
+
+// full names are case insensitive on github
+func repositoryKey(fullName string) string {
+	return strings.ToLower(fullName)
+}
+
+func fullName(repository github.Repository) string {
+	if repository.FullName != "" {
+		return repository.FullName
+	}
+	return repository.Owner.Login + "/" + repository.Name
+}
+
+// Ping
+// The store lives in memory, it's always reachable
+func (store *RepositoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Add
+// Keep the repositories we don't know yet
+// the list endpoints return partial records, they must not replace a complete one
+func (store *RepositoryStore) Add(repositories []github.Repository) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	for _, repository := range repositories {
+		key := repositoryKey(fullName(repository))
+		if _, ok := store.repositories[key]; ok {
+			continue
+		}
+		store.repositories[key] = &StoredRepository{Repository: repository, UpdatedAt: now}
+		store.touch(key)
+	}
+
+	store.evict()
+}
+
+// Put
+// Replace the record of the repository, the stats are kept
+func (store *RepositoryStore) Put(repository github.Repository) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key := repositoryKey(fullName(repository))
+
+	stored, ok := store.repositories[key]
+	if !ok {
+		stored = &StoredRepository{}
+		store.repositories[key] = stored
+	}
+
+	stored.Repository = repository
+	stored.UpdatedAt = time.Now()
+	store.touch(key)
+	store.evict()
+
+	if stored.Stats != nil {
+		stats := *stored.Stats
+		stats.StarCount = repository.StargazersCount
+		stats.Description = repository.Description
+		if repository.License.Key != "" {
+			stats.License = repository.License.Key
+		}
+		stored.Stats = &stats
+	}
+}
+
+// Update
+// Apply fn to the record of the repository if it's known
+func (store *RepositoryStore) Update(name string, fn func(stored *StoredRepository)) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key := repositoryKey(name)
+
+	stored, ok := store.repositories[key]
+	if !ok {
+		return false
+	}
+
+	fn(stored)
+	stored.UpdatedAt = time.Now()
+	store.touch(key)
+
+	return true
+}
+
+// Snapshot
+// Record the counters of the repository, the snapshot scheduler calls it for the tracked repositories
+// Returns false if the repository is not known
+func (store *RepositoryStore) Snapshot(name string, at time.Time) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stored, ok := store.repositories[repositoryKey(name)]
+	if !ok {
+		return false
+	}
+
+	stored.Snapshots = append(stored.Snapshots, Snapshot{
+		At:              at,
+		StargazersCount: stored.Repository.StargazersCount,
+		ForksCount:      stored.Repository.ForksCount,
+		OpenIssuesCount: stored.Repository.OpenIssuesCount,
+		Size:            stored.Repository.Size,
+	})
+	store.expire(stored, at)
+
+	return true
+}
+
+// Prune
+// Drop the snapshots past the retention
+// Returns how many snapshots were dropped
+func (store *RepositoryStore) Prune(now time.Time) int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	dropped := 0
+	for _, stored := range store.repositories {
+		dropped += store.expire(stored, now)
+	}
+
+	return dropped
+}
+
+// expire
+// Drop the snapshots of the repository past the retention, must be called with the lock held
+// Returns how many snapshots were dropped
+func (store *RepositoryStore) expire(stored *StoredRepository, now time.Time) int {
+	expired := 0
+	for expired < len(stored.Snapshots) && now.Sub(stored.Snapshots[expired].At) > store.retention {
+		expired++
+	}
+	if expired > 0 {
+		stored.Snapshots = append([]Snapshot(nil), stored.Snapshots[expired:]...)
+	}
+
+	return expired
+}
+
+// touch
+// Mark the repository as the most recently updated, must be called with the lock held
+func (store *RepositoryStore) touch(key string) {
+	if element, ok := store.elements[key]; ok {
+		store.recent.MoveToFront(element)
+		return
+	}
+	store.elements[key] = store.recent.PushFront(key)
+}
+
+// evict
+// Drop the least recently updated repositories past maxRepositories, must be called with the lock held
+// the repositories with snapshots are kept, their history can't be fetched again
+func (store *RepositoryStore) evict() {
+	if store.maxRepositories <= 0 {
+		return
+	}
+
+	// the repository just updated is never evicted
+	element := store.recent.Back()
+	for len(store.repositories) > store.maxRepositories && element != nil && element != store.recent.Front() {
+		previous := element.Prev()
+
+		key := element.Value.(string)
+		if len(store.repositories[key].Snapshots) == 0 {
+			store.remove(key)
+		}
+
+		element = previous
+	}
+}
+
+// remove must be called with the lock held
+func (store *RepositoryStore) remove(key string) {
+	delete(store.repositories, key)
+	if element, ok := store.elements[key]; ok {
+		store.recent.Remove(element)
+		delete(store.elements, key)
+	}
+}
+
+// startStorePruner
+// Drop the snapshots past the retention every interval until the context is done
+// the repositories which stop being refreshed don't keep their history forever
+func startStorePruner(ctx context.Context, store *RepositoryStore, interval time.Duration) {
+	log := logger.Get(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if dropped := store.Prune(now); dropped > 0 {
+				log.Debugf("pruned %d snapshots past the retention", dropped)
+			}
+		}
+	}
+}
+
+// PutStats
+// Cache the last stats computed for a repository
+func (store *RepositoryStore) PutStats(repository github.Repository, stats Stats) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key := repositoryKey(fullName(repository))
+
+	stored, ok := store.repositories[key]
+	if !ok {
+		stored = &StoredRepository{Repository: repository}
+		store.repositories[key] = stored
+	}
+
+	if stored.Repository.CreatedAt.IsZero() {
+		stored.Repository.CreatedAt = repository.CreatedAt
+	}
+
+	stored.Stats = &stats
+	stored.UpdatedAt = time.Now()
+	store.touch(key)
+	store.evict()
+}
+
+func (store *RepositoryStore) Delete(name string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.remove(repositoryKey(name))
+}
+
+func (store *RepositoryStore) Get(name string) (StoredRepository, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	stored, ok := store.repositories[repositoryKey(name)]
+	if !ok {
+		return StoredRepository{}, false
+	}
+
+	result := *stored
+	result.Snapshots = append([]Snapshot(nil), stored.Snapshots...)
+
+	return result, true
+}
+
+// Each
+// Call fn with a copy of every stored repository
+func (store *RepositoryStore) Each(fn func(stored StoredRepository)) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, stored := range store.repositories {
+		result := *stored
+		result.Snapshots = append([]Snapshot(nil), stored.Snapshots...)
+		fn(result)
+	}
+}
+
+// Purge
+// Forget the repositories and their stats, the ones with snapshots are kept: their history can't be fetched again
+// Returns how many repositories were dropped
+func (store *RepositoryStore) Purge() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	count := 0
+	for key, stored := range store.repositories {
+		if len(stored.Snapshots) > 0 {
+			stored.Stats = nil
+			continue
+		}
+		store.remove(key)
+		count++
+	}
+
+	return count
+}
+
+func (store *RepositoryStore) Len() int {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return len(store.repositories)
+}
+
+// cacheHandlerDelete
+// Purge the repositories stored, they are fetched again from github when needed, admin only
+func cacheHandlerDelete(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+	log := logger.Get(r.Context())
+
+	if !requireAPIKeyAdmin(w, r) {
+		return nil
+	}
+
+	count := repositoryStore.Purge()
+	log.Infof("purged %d repositories", count)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(map[string]int{"purged": count})
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
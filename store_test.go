@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+func newTestRepository(fullName string, stars int) github.Repository {
+	var repository github.Repository
+	repository.FullName = fullName
+	repository.StargazersCount = stars
+	return repository
+}
+
+func TestRepositoryStoreNoSnapshotOnUpdate(t *testing.T) {
+	store := newRepositoryStore(time.Hour, 0)
+
+	for i := 0; i < 10; i++ {
+		store.Put(newTestRepository("octo/cat", i))
+		store.Update("octo/cat", func(stored *StoredRepository) { stored.Repository.StargazersCount++ })
+	}
+
+	stored, _ := store.Get("octo/cat")
+	if len(stored.Snapshots) != 0 {
+		t.Errorf("only the scheduler records snapshots, got %d", len(stored.Snapshots))
+	}
+
+	if !store.Snapshot("Octo/Cat", time.Now()) {
+		t.Fatal("the repository was not found")
+	}
+	stored, _ = store.Get("octo/cat")
+	if len(stored.Snapshots) != 1 || stored.Snapshots[0].StargazersCount != 10 {
+		t.Errorf("expected a snapshot of the last counters, got %+v", stored.Snapshots)
+	}
+	if store.Snapshot("octo/dog", time.Now()) {
+		t.Error("a repository not stored got a snapshot")
+	}
+}
+
+func TestRepositoryStoreEviction(t *testing.T) {
+	store := newRepositoryStore(time.Hour, 3)
+
+	store.Put(newTestRepository("octo/tracked", 1))
+	store.Snapshot("octo/tracked", time.Now())
+	for i := 0; i < 5; i++ {
+		store.Put(newTestRepository(fmt.Sprintf("octo/repo%d", i), i))
+	}
+	// repo2 is updated again, it's the least recently updated ones which go
+	store.PutStats(newTestRepository("octo/repo2", 2), Stats{})
+	store.Add([]github.Repository{newTestRepository("octo/listed", 0)})
+
+	if store.Len() != 3 {
+		t.Fatalf("expected 3 repositories, got %d", store.Len())
+	}
+	for _, name := range []string{"octo/tracked", "octo/repo2", "octo/listed"} {
+		if _, ok := store.Get(name); !ok {
+			t.Errorf("%s was evicted", name)
+		}
+	}
+
+	store.Delete("octo/repo2")
+	store.Put(newTestRepository("octo/repo5", 5))
+	if _, ok := store.Get("octo/listed"); !ok || store.Len() != 3 {
+		t.Errorf("a repository was evicted while under the limit")
+	}
+}
+
+func TestRepositoryStorePrune(t *testing.T) {
+	store := newRepositoryStore(time.Hour, 0)
+	start := time.Now()
+
+	store.Put(newTestRepository("octo/cat", 1))
+	for i := 0; i < 4; i++ {
+		store.Snapshot("octo/cat", start.Add(time.Duration(i)*20*time.Minute))
+	}
+
+	// the repository is not refreshed anymore, its history still expires
+	if dropped := store.Prune(start.Add(90 * time.Minute)); dropped != 2 {
+		t.Errorf("expected the 2 snapshots older than an hour dropped, %d were", dropped)
+	}
+	if dropped := store.Prune(start.Add(10 * time.Hour)); dropped != 2 {
+		t.Errorf("expected the 2 remaining snapshots dropped, %d were", dropped)
+	}
+}
+
+func TestRefreshTrackedRepositoriesSnapshots(t *testing.T) {
+	server := newTestGithub(t, githubfake.Options{Seed: 4, Count: 20})
+	repositories := server.Repositories()
+
+	previous := trackedRepositories
+	trackedRepositories = newTrackedRepositories([]string{repositories[0].FullName(), repositories[1].FullName()})
+	t.Cleanup(func() { trackedRepositories = previous })
+
+	// a repository fetched for /stats has no history
+	enrichStats(testContext(), nil, listedRepositories(server)[2:4])
+
+	refreshTrackedRepositories(testContext())
+	refreshTrackedRepositories(testContext())
+
+	for i, repository := range repositories[:4] {
+		stored, ok := repositoryStore.Get(repository.FullName())
+		if !ok {
+			t.Fatalf("%s was not stored", repository.FullName())
+		}
+		expected := 0
+		if i < 2 {
+			expected = 2
+		}
+		if len(stored.Snapshots) != expected {
+			t.Errorf("%s: expected %d snapshots, got %d", repository.FullName(), expected, len(stored.Snapshots))
+		}
+	}
+}
+
+func TestRepositoryStorePurgeKeepsHistory(t *testing.T) {
+	store := newRepositoryStore(time.Hour, 0)
+
+	store.Put(newTestRepository("octo/tracked", 3))
+	store.PutStats(newTestRepository("octo/tracked", 3), Stats{StarCount: 3})
+	store.Snapshot("octo/tracked", time.Now())
+	for i := 0; i < 3; i++ {
+		store.Put(newTestRepository(fmt.Sprintf("octo/repo%d", i), i))
+	}
+
+	if purged := store.Purge(); purged != 3 {
+		t.Errorf("expected the 3 repositories without history purged, %d were", purged)
+	}
+	stored, ok := store.Get("octo/tracked")
+	if !ok || len(stored.Snapshots) != 1 || stored.Stats != nil {
+		t.Errorf("expected the tracked repository kept with its history and without its stats, got %+v", stored)
+	}
+	if store.Len() != 1 {
+		t.Errorf("expected a single repository left, got %d", store.Len())
+	}
+}
+
+func TestCacheHandlerDeleteAdmin(t *testing.T) {
+	newTestGithub(t, githubfake.Options{})
+	repositoryStore.Put(newTestRepository("octo/cat", 1))
+
+	for _, admin := range []bool{false, true} {
+		req := asAdmin(t, httptest.NewRequest(http.MethodDelete, "/cache", nil).WithContext(testContext()), admin)
+		res := httptest.NewRecorder()
+		_ = cacheHandlerDelete(res, req, nil)
+
+		expected := http.StatusForbidden
+		if admin {
+			expected = http.StatusOK
+		}
+		if res.Code != expected {
+			t.Errorf("admin %v: expected a %d, got %d %s", admin, expected, res.Code, res.Body.String())
+		}
+		if _, ok := repositoryStore.Get("octo/cat"); ok == admin {
+			t.Errorf("admin %v: octo/cat still stored: %v", admin, ok)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+)
+
+// a time series is cut to that many buckets at most
+const timeseriesMaxBuckets = 10000
+
+// content type of each format of /timeseries/created
+var timeseriesContentTypes = map[string][]string{
+	exportFormatJSON: {"application/json"},
+	exportFormatCSV:  {"text/csv"},
+}
+
+// TimeseriesQuery
+// Parameters of /timeseries/created
+type TimeseriesQuery struct {
+	Bucket  time.Duration
+	GroupBy string
+	From    time.Time
+	To      time.Time
+}
+
+// newTimeseriesQuery
+// bucket: size of a bucket (1m, 1h, 1d...), 1h by default
+// group_by: none (default), language or license
+// from/to: RFC3339 bounds, or window: the last period (24h by default)
+func newTimeseriesQuery(params url.Values, now time.Time) (TimeseriesQuery, error) {
+	query := TimeseriesQuery{
+		Bucket:  time.Hour,
+		GroupBy: params.Get("group_by"),
+		To:      now,
+	}
+
+	if bucket := params.Get("bucket"); bucket != "" {
+		duration, err := parsePeriod(bucket)
+		if err != nil {
+			return query, fmt.Errorf("invalid bucket `%s`", bucket)
+		}
+		query.Bucket = duration
+	}
+
+	switch query.GroupBy {
+	case "", "none":
+		query.GroupBy = "none"
+	case "language", "license":
+	default:
+		return query, fmt.Errorf("invalid group_by `%s`, expected none, language or license", query.GroupBy)
+	}
+
+	window := 24 * time.Hour
+	if windowParam := params.Get("window"); windowParam != "" {
+		duration, err := parsePeriod(windowParam)
+		if err != nil {
+			return query, err
+		}
+		window = duration
+	}
+
+	if to := params.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return query, fmt.Errorf("invalid to `%s`, expected RFC3339", to)
+		}
+		query.To = t
+	}
+
+	query.From = query.To.Add(-window)
+	if from := params.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return query, fmt.Errorf("invalid from `%s`, expected RFC3339", from)
+		}
+		query.From = t
+	}
+
+	if !query.From.Before(query.To) {
+		return query, fmt.Errorf("from must be before to")
+	}
+
+	if query.To.Sub(query.From)/query.Bucket > timeseriesMaxBuckets {
+		return query, fmt.Errorf("too many buckets, at most %d are allowed", timeseriesMaxBuckets)
+	}
+
+	return query, nil
+}
+
+type TimeseriesBucket struct {
+	Time   time.Time      `json:"time"`
+	Total  int            `json:"total"`
+	Counts map[string]int `json:"counts"`
+}
+
+// Timeseries
+// Repositories created per bucket, each bucket has a count for every group, 0 included
+type Timeseries struct {
+	Bucket  string             `json:"bucket"`
+	GroupBy string             `json:"group_by"`
+	Groups  []string           `json:"groups"`
+	Buckets []TimeseriesBucket `json:"buckets"`
+}
+
+// repositoryGroup
+// The group of a stored repository
+// language: its main language, the one github reports or the one with the most code in its stats
+// license: the key of its license
+func repositoryGroup(stored StoredRepository, groupBy string) string {
+	group := ""
+
+	switch groupBy {
+	case "language":
+		group = stored.Repository.Language
+		if group == "" && stored.Stats != nil {
+			max := 0
+			for language, size := range stored.Stats.Languages {
+				if size > max || (size == max && language < group) {
+					group, max = language, size
+				}
+			}
+		}
+	case "license":
+		group = stored.Repository.License.Key
+		if group == "" && stored.Stats != nil {
+			group = stored.Stats.License
+		}
+	default:
+		return "all"
+	}
+
+	if group == "" {
+		return "unknown"
+	}
+	return group
+}
+
+// createdTimeseries
+// Count the stored repositories created in each bucket of the query
+// repositories whose creation date is unknown (partial records) are left out
+func createdTimeseries(store *RepositoryStore, query TimeseriesQuery) Timeseries {
+	from := query.From.Truncate(query.Bucket)
+
+	counts := map[int]map[string]int{}
+	groups := map[string]bool{}
+
+	store.Each(func(stored StoredRepository) {
+		createdAt := stored.Repository.CreatedAt
+		if createdAt.IsZero() || createdAt.Before(from) || !createdAt.Before(query.To) {
+			return
+		}
+
+		index := int(createdAt.Sub(from) / query.Bucket)
+		group := repositoryGroup(stored, query.GroupBy)
+
+		if counts[index] == nil {
+			counts[index] = map[string]int{}
+		}
+		counts[index][group]++
+		groups[group] = true
+	})
+
+	timeseries := Timeseries{
+		Bucket:  query.Bucket.String(),
+		GroupBy: query.GroupBy,
+		Groups:  make([]string, 0, len(groups)),
+		Buckets: []TimeseriesBucket{},
+	}
+
+	for group := range groups {
+		timeseries.Groups = append(timeseries.Groups, group)
+	}
+	sort.Strings(timeseries.Groups)
+
+	for index, at := 0, from; at.Before(query.To); index, at = index+1, at.Add(query.Bucket) {
+		bucket := TimeseriesBucket{Time: at, Counts: map[string]int{}}
+
+		// gaps are filled with zeros
+		for _, group := range timeseries.Groups {
+			bucket.Counts[group] = counts[index][group]
+			bucket.Total += counts[index][group]
+		}
+
+		timeseries.Buckets = append(timeseries.Buckets, bucket)
+	}
+
+	return timeseries
+}
+
+// writeTimeseriesCSV
+// One line per bucket and group: time,group,count
+func writeTimeseriesCSV(w *csv.Writer, timeseries Timeseries) error {
+	err := w.Write([]string{"time", timeseries.GroupBy, "count"})
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range timeseries.Buckets {
+		for _, group := range timeseries.Groups {
+			err := w.Write([]string{bucket.Time.Format(time.RFC3339), group, strconv.Itoa(bucket.Counts[group])})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
+
+// timeseriesCreatedHandlerGet
+// Repositories created per bucket, from the repositories stored
+// format=csv for a CSV output, the Accept header is only read without format, as for /stats
+func timeseriesCreatedHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+	log := logger.Get(r.Context())
+
+	query, err := newTimeseriesQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = acceptedFormat(r.Header.Get("Accept"), timeseriesContentTypes, exportFormatJSON)
+	case exportFormatJSON, exportFormatCSV:
+	default:
+		writeJSONError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid format `%s`, expected json or csv", format))
+		return nil
+	}
+
+	timeseries := createdTimeseries(repositoryStore, query)
+
+	w.Header().Add("Vary", "Accept")
+	if format == exportFormatCSV {
+		w.Header().Add("Content-Type", "text/csv")
+		w.WriteHeader(http.StatusOK)
+
+		err = writeTimeseriesCSV(csv.NewWriter(w), timeseries)
+		if err != nil {
+			log.WithError(err).Error("Fail to encode CSV")
+		}
+
+		return nil
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(timeseries)
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
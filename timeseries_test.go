@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+var timeseriesStart = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+// newCreatedRepository
+// A repository created at the given time with its language and license, both may be empty
+func newCreatedRepository(fullName string, createdAt time.Time, language, license string) github.Repository {
+	repository := newTestRepository(fullName, 0)
+	repository.CreatedAt = createdAt
+	repository.Language = language
+	repository.License.Key = license
+	return repository
+}
+
+// newTimeseriesStore
+// Repositories created during the first 3 hours of timeseriesStart, and one without creation date
+func newTimeseriesStore() *RepositoryStore {
+	store := newRepositoryStore(time.Hour, 0)
+	store.Add([]github.Repository{
+		newCreatedRepository("octo/a", timeseriesStart, "Go", "mit"),
+		newCreatedRepository("octo/b", timeseriesStart.Add(59*time.Minute), "Go", ""),
+		newCreatedRepository("octo/c", timeseriesStart.Add(2*time.Hour+30*time.Minute), "", "apache-2.0"),
+		newCreatedRepository("octo/d", timeseriesStart.Add(2*time.Hour+45*time.Minute), "Rust", "mit"),
+		newCreatedRepository("octo/partial", time.Time{}, "Go", "mit"),
+	})
+
+	// the language of c is only known from its stats
+	stats := Stats{Languages: map[string]int{"C": 10, "Python": 100}}
+	store.PutStats(newCreatedRepository("octo/c", timeseriesStart.Add(2*time.Hour+30*time.Minute), "", "apache-2.0"), stats)
+
+	return store
+}
+
+// bucketCounts
+// The counts of the group in each bucket
+func bucketCounts(timeseries Timeseries, group string) []int {
+	counts := []int{}
+	for _, bucket := range timeseries.Buckets {
+		counts = append(counts, bucket.Counts[group])
+	}
+	return counts
+}
+
+func TestNewTimeseriesQuery(t *testing.T) {
+	now := timeseriesStart.Add(24 * time.Hour)
+
+	cases := []struct {
+		query    string
+		expected TimeseriesQuery
+		err      bool
+	}{
+		{query: "", expected: TimeseriesQuery{Bucket: time.Hour, GroupBy: "none", From: timeseriesStart, To: now}},
+		{query: "bucket=1d&window=7d&group_by=language", expected: TimeseriesQuery{Bucket: 24 * time.Hour, GroupBy: "language", From: now.Add(-7 * 24 * time.Hour), To: now}},
+		{query: "from=2024-03-01T01:00:00Z&to=2024-03-01T02:00:00Z&bucket=1m", expected: TimeseriesQuery{Bucket: time.Minute, GroupBy: "none", From: timeseriesStart.Add(time.Hour), To: timeseriesStart.Add(2 * time.Hour)}},
+		{query: "bucket=often", err: true},
+		{query: "group_by=owner", err: true},
+		{query: "window=never", err: true},
+		{query: "from=yesterday", err: true},
+		{query: "to=2024-03-02", err: true},
+		{query: "from=2024-03-01T01:00:00Z&to=2024-03-01T01:00:00Z", err: true},
+		{query: "window=30d&bucket=1m", err: true},
+	}
+	for _, c := range cases {
+		params, _ := url.ParseQuery(c.query)
+		query, err := newTimeseriesQuery(params, now)
+		if (err != nil) != c.err {
+			t.Errorf("%q: expected an error %v, got %v", c.query, c.err, err)
+			continue
+		}
+		if !c.err && !reflect.DeepEqual(query, c.expected) {
+			t.Errorf("%q: got %+v, expected %+v", c.query, query, c.expected)
+		}
+	}
+}
+
+func TestCreatedTimeseriesBuckets(t *testing.T) {
+	store := newTimeseriesStore()
+
+	// from is aligned on the bucket, to is excluded
+	query := TimeseriesQuery{Bucket: time.Hour, GroupBy: "none", From: timeseriesStart.Add(10 * time.Minute), To: timeseriesStart.Add(4 * time.Hour)}
+	timeseries := createdTimeseries(store, query)
+
+	if len(timeseries.Buckets) != 4 || !timeseries.Buckets[0].Time.Equal(timeseriesStart) || timeseries.Bucket != "1h0m0s" {
+		t.Fatalf("expected 4 buckets of an hour from %s, got %+v", timeseriesStart, timeseries)
+	}
+	if counts := bucketCounts(timeseries, "all"); !reflect.DeepEqual(counts, []int{2, 0, 2, 0}) {
+		t.Errorf("expected the gaps filled with zeros, got %v", counts)
+	}
+
+	// a repository created at to is in the next range
+	query.To = timeseriesStart.Add(2*time.Hour + 45*time.Minute)
+	if counts := bucketCounts(createdTimeseries(store, query), "all"); !reflect.DeepEqual(counts, []int{2, 0, 1}) {
+		t.Errorf("expected the repository created at to left out, got %v", counts)
+	}
+}
+
+func TestCreatedTimeseriesGroups(t *testing.T) {
+	store := newTimeseriesStore()
+	query := TimeseriesQuery{Bucket: time.Hour, From: timeseriesStart, To: timeseriesStart.Add(3 * time.Hour)}
+
+	cases := []struct {
+		groupBy string
+		groups  []string
+		counts  map[string][]int
+	}{
+		{
+			groupBy: "language",
+			groups:  []string{"Go", "Python", "Rust"},
+			counts:  map[string][]int{"Go": {2, 0, 0}, "Python": {0, 0, 1}, "Rust": {0, 0, 1}},
+		},
+		{
+			groupBy: "license",
+			groups:  []string{"apache-2.0", "mit", "unknown"},
+			counts:  map[string][]int{"apache-2.0": {0, 0, 1}, "mit": {1, 0, 1}, "unknown": {1, 0, 0}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.groupBy, func(t *testing.T) {
+			query.GroupBy = c.groupBy
+			timeseries := createdTimeseries(store, query)
+
+			if !reflect.DeepEqual(timeseries.Groups, c.groups) {
+				t.Fatalf("expected the groups %v, got %v", c.groups, timeseries.Groups)
+			}
+			for group, expected := range c.counts {
+				if counts := bucketCounts(timeseries, group); !reflect.DeepEqual(counts, expected) {
+					t.Errorf("%s: expected %v, got %v", group, expected, counts)
+				}
+			}
+			for _, bucket := range timeseries.Buckets {
+				total := 0
+				for _, count := range bucket.Counts {
+					total += count
+				}
+				if bucket.Total != total {
+					t.Errorf("bucket %s: total %d, counts %v", bucket.Time, bucket.Total, bucket.Counts)
+				}
+			}
+		})
+	}
+}
+
+func TestCreatedTimeseriesEmptyRange(t *testing.T) {
+	store := newTimeseriesStore()
+
+	// nothing was created that day, every bucket is there with no group
+	query := TimeseriesQuery{Bucket: 6 * time.Hour, GroupBy: "language", From: timeseriesStart.Add(24 * time.Hour), To: timeseriesStart.Add(48 * time.Hour)}
+	timeseries := createdTimeseries(store, query)
+
+	if len(timeseries.Groups) != 0 || len(timeseries.Buckets) != 4 {
+		t.Fatalf("expected 4 empty buckets, got %+v", timeseries)
+	}
+	for _, bucket := range timeseries.Buckets {
+		if bucket.Total != 0 || len(bucket.Counts) != 0 {
+			t.Errorf("bucket %s is not empty: %+v", bucket.Time, bucket)
+		}
+	}
+
+	// an empty store gives the same
+	if empty := createdTimeseries(newRepositoryStore(time.Hour, 0), query); !reflect.DeepEqual(empty, timeseries) {
+		t.Errorf("got %+v, expected %+v", empty, timeseries)
+	}
+}
+
+func TestTimeseriesCreatedCSV(t *testing.T) {
+	newTestGithub(t, githubfake.Options{})
+	repositoryStore = newTimeseriesStore()
+
+	req := httptest.NewRequest(http.MethodGet, "/timeseries/created?format=csv&group_by=license&from=2024-03-01T00:00:00Z&to=2024-03-01T02:00:00Z", nil).WithContext(testContext())
+	res := httptest.NewRecorder()
+	_ = timeseriesCreatedHandlerGet(res, req, nil)
+	if res.Code != http.StatusOK || !strings.HasPrefix(res.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected a CSV, got %d %s", res.Code, res.Header().Get("Content-Type"))
+	}
+
+	lines, err := csv.NewReader(res.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"time", "license", "count"},
+		{"2024-03-01T00:00:00Z", "mit", "1"},
+		{"2024-03-01T00:00:00Z", "unknown", "1"},
+		{"2024-03-01T01:00:00Z", "mit", "0"},
+		{"2024-03-01T01:00:00Z", "unknown", "0"},
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got %v, expected %v", lines, expected)
+	}
+}
+
+func TestTimeseriesCreatedFormat(t *testing.T) {
+	newTestGithub(t, githubfake.Options{})
+
+	cases := []struct {
+		query       string
+		accept      string
+		status      int
+		contentType string
+	}{
+		{query: "", accept: "", status: http.StatusOK, contentType: "application/json"},
+		{query: "", accept: "text/csv", status: http.StatusOK, contentType: "text/csv"},
+		{query: "", accept: "text/csv;q=0", status: http.StatusOK, contentType: "application/json"},
+		{query: "", accept: "text/csv;q=0.5, application/json", status: http.StatusOK, contentType: "application/json"},
+		{query: "format=json", accept: "text/csv", status: http.StatusOK, contentType: "application/json"},
+		{query: "format=csv", accept: "application/json", status: http.StatusOK, contentType: "text/csv"},
+		{query: "format=parquet", status: http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/timeseries/created?"+c.query, nil).WithContext(testContext())
+		req.Header.Set("Accept", c.accept)
+		res := httptest.NewRecorder()
+		_ = timeseriesCreatedHandlerGet(res, req, nil)
+
+		if res.Code != c.status {
+			t.Errorf("%q %q: expected a %d, got %d %s", c.query, c.accept, c.status, res.Code, res.Body.String())
+			continue
+		}
+		if c.status == http.StatusOK && res.Header().Get("Content-Type") != c.contentType {
+			t.Errorf("%q %q: expected %s, got %s", c.query, c.accept, c.contentType, res.Header().Get("Content-Type"))
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Scalingo/go-utils/logger"
+)
+
+const (
+	topicModeAny = "any"
+	topicModeAll = "all"
+)
+
+// queryTopics
+// Topics asked with `topic`, repeated or comma separated: ?topic=go,cli or ?topic=go&topic=cli
+func queryTopics(params url.Values) []string {
+	var topics []string
+	for _, param := range params["topic"] {
+		for _, topic := range strings.Split(param, ",") {
+			topic = strings.ToLower(strings.TrimSpace(topic))
+			if topic != "" {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	return topics
+}
+
+// validateTopicMode
+// `topic_mode` must be empty, any or all, the handlers refuse the query with a 400 otherwise
+func validateTopicMode(params url.Values) error {
+	switch mode := params.Get("topic_mode"); mode {
+	case "", topicModeAny, topicModeAll:
+		return nil
+	default:
+		return fmt.Errorf("invalid topic_mode `%s`, expected `%s` or `%s`", mode, topicModeAny, topicModeAll)
+	}
+}
+
+// matchTopics
+// With `topic_mode=any` (default) a repository needs one of the topics asked, with `topic_mode=all` it needs all of them
+// the mode is checked with validateTopicMode before
+func matchTopics(params url.Values, repositoryTopics []string) bool {
+	topics := queryTopics(params)
+	if len(topics) == 0 {
+		return true
+	}
+
+	has := make(map[string]bool, len(repositoryTopics))
+	for _, topic := range repositoryTopics {
+		has[strings.ToLower(topic)] = true
+	}
+
+	if params.Get("topic_mode") == topicModeAll {
+		for _, topic := range topics {
+			if !has[topic] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, topic := range topics {
+		if has[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+type TopicCount struct {
+	Topic string `json:"topic"`
+	Count int    `json:"count"`
+}
+
+type TopicPairCount struct {
+	Topics [2]string `json:"topics"`
+	Count  int       `json:"count"`
+}
+
+// TopicsStats
+// Topics of the repositories selected by a /stats query
+type TopicsStats struct {
+	Repositories int              `json:"repositories"`
+	Topics       []TopicCount     `json:"topics"`
+	Pairs        []TopicPairCount `json:"pairs"`
+}
+
+// aggregateTopics
+// Count the repositories per topic and per pair of topics found together on a repository
+// Both are sorted by count, the most used first, and cut to limit if positive
+func aggregateTopics(stats []Stats, limit int) TopicsStats {
+	counts := map[string]int{}
+	pairs := map[[2]string]int{}
+
+	for _, stat := range stats {
+		topics := append([]string(nil), stat.Topics...)
+		sort.Strings(topics)
+
+		for i, topic := range topics {
+			counts[topic]++
+
+			for _, other := range topics[i+1:] {
+				pairs[[2]string{topic, other}]++
+			}
+		}
+	}
+
+	result := TopicsStats{
+		Repositories: len(stats),
+		Topics:       make([]TopicCount, 0, len(counts)),
+		Pairs:        make([]TopicPairCount, 0, len(pairs)),
+	}
+
+	for topic, count := range counts {
+		result.Topics = append(result.Topics, TopicCount{Topic: topic, Count: count})
+	}
+	sort.Slice(result.Topics, func(i, j int) bool {
+		if result.Topics[i].Count != result.Topics[j].Count {
+			return result.Topics[i].Count > result.Topics[j].Count
+		}
+		return result.Topics[i].Topic < result.Topics[j].Topic
+	})
+
+	for pair, count := range pairs {
+		result.Pairs = append(result.Pairs, TopicPairCount{Topics: pair, Count: count})
+	}
+	sort.Slice(result.Pairs, func(i, j int) bool {
+		if result.Pairs[i].Count != result.Pairs[j].Count {
+			return result.Pairs[i].Count > result.Pairs[j].Count
+		}
+		return result.Pairs[i].Topics[0]+"+"+result.Pairs[i].Topics[1] < result.Pairs[j].Topics[0]+"+"+result.Pairs[j].Topics[1]
+	})
+
+	if limit > 0 {
+		if len(result.Topics) > limit {
+			result.Topics = result.Topics[:limit]
+		}
+		if len(result.Pairs) > limit {
+			result.Pairs = result.Pairs[:limit]
+		}
+	}
+
+	return result
+}
+
+// statsTopicsHandlerGet
+// Topic counts and co-occurrences over the repositories /stats would return with the same parameters
+func statsTopicsHandlerGet(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+	log := logger.Get(r.Context())
+
+	limit := 0
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid limit `%s`", limitParam))
+			return nil
+		}
+	}
+
+	params := r.URL.Query()
+	params.Del("limit")
+
+	err := validateTopicMode(params)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	stats, err := fetchStats(withCallerAuthorization(r), params)
+	if isRateLimited(err) {
+		writeGithubRateLimited(w, r, err)
+		return nil
+	}
+	if err != nil {
+		writeJSONError(w, r, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(aggregateTopics(stats, limit))
+	if err != nil {
+		log.WithError(err).Error("Fail to encode JSON")
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+)
+
+func TestMatchTopics(t *testing.T) {
+	repositoryTopics := []string{"Go", "cli"}
+
+	cases := []struct {
+		query string
+		match bool
+	}{
+		{"", true},
+		{"topic=go", true},
+		{"topic=go,web", true},
+		{"topic=go&topic=web&topic_mode=any", true},
+		{"topic=go,web&topic_mode=all", false},
+		{"topic=go,cli&topic_mode=all", true},
+		{"topic=web", false},
+	}
+	for _, c := range cases {
+		params, _ := url.ParseQuery(c.query)
+		if match := matchTopics(params, repositoryTopics); match != c.match {
+			t.Errorf("%q: expected %v, got %v", c.query, c.match, match)
+		}
+	}
+}
+
+func TestUnknownTopicMode(t *testing.T) {
+	if err := validateTopicMode(url.Values{"topic_mode": {"none"}}); err == nil {
+		t.Error("expected an unknown topic_mode to be refused")
+	}
+
+	// refused before any call to github
+	for path, handler := range map[string]func(http.ResponseWriter, *http.Request, map[string]string) error{
+		"/stats":        statsHandlerGet,
+		"/stats/topics": statsTopicsHandlerGet,
+		"/owners":       ownersHandlerGet,
+	} {
+		req := httptest.NewRequest(http.MethodGet, path+"?topic=go&topic_mode=every", nil).WithContext(testContext())
+		res := httptest.NewRecorder()
+		_ = handler(res, req, nil)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, res.Code)
+		}
+	}
+}
+
+func TestStatsTopicsRateLimited(t *testing.T) {
+	newTestGithub(t, githubfake.Options{Seed: 8, FirstID: 1, Count: 10, RateLimit: 1})
+
+	// the list takes the only call left, the next one is refused
+	for i, expected := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		req := httptest.NewRequest(http.MethodGet, "/stats/topics?since="+strconv.Itoa(1-i), nil).WithContext(testContext())
+		res := httptest.NewRecorder()
+		_ = statsTopicsHandlerGet(res, req, nil)
+		if res.Code != expected {
+			t.Fatalf("call %d: expected %d, got %d %s", i, expected, res.Code, res.Body.String())
+		}
+		if expected != http.StatusServiceUnavailable {
+			continue
+		}
+
+		// until the window of the fake is reset, an hour at most
+		retryAfter, err := strconv.Atoi(res.Header().Get("Retry-After"))
+		if err != nil || retryAfter < 1 || retryAfter > 3600 {
+			t.Errorf("expected a Retry-After until the reset, got %q", res.Header().Get("Retry-After"))
+		}
+	}
+}
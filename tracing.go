@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/Scalingo/go-handlers"
+	"github.com/gorilla/mux"
+	"github.com/urfave/negroni"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer used for every span of the service
+var tracer = otel.Tracer("github.com/Scalingo/sclng-backend-test-v1")
+
+// initTracing
+// Configure the global tracer provider based on the exporter set in the config
+// none: spans are not exported at all
+// stdout: spans are printed as JSON on stdout
+// otlp: spans are sent with OTLP over http, the endpoint is read from the
+// standard OTEL_EXPORTER_OTLP_* environment variables
+// Returns a function flushing the spans still buffered
+func initTracing(ctx context.Context, cfg *Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch cfg.TracingExporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter `%s`", cfg.TracingExporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s exporter failed: %w", cfg.TracingExporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.TracingServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("resource.Merge failed: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware
+// Start a server span for each request handled by the router
+// The W3C `traceparent` header is honoured so the span is attached to the caller's trace
+var tracingMiddleware = handlers.MiddlewareFunc(func(next handlers.HandlerFunc) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		name := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				name = template
+			}
+		}
+
+		ctx, span := tracer.Start(ctx, fmt.Sprintf("%s %s", r.Method, name),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(r.Method),
+				semconv.HTTPRoute(name),
+				attribute.String("http.target", r.URL.RequestURI()),
+			),
+		)
+		defer span.End()
+
+		rw := negroni.NewResponseWriter(w)
+
+		err := next(rw, r.WithContext(ctx), vars)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		span.SetAttributes(semconv.HTTPStatusCode(rw.Status()))
+		if rw.Status() >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rw.Status()))
+		}
+
+		return err
+	}
+})
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Scalingo/sclng-backend-test-v1/githubfake"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	testSpans     = tracetest.NewSpanRecorder()
+	testSpansOnce sync.Once
+)
+
+// recordSpans
+// The spans ended are kept in memory, `tracer` only follows the first provider set so it is set once for every test
+func recordSpans() *tracetest.SpanRecorder {
+	testSpansOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(testSpans)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return testSpans
+}
+
+// spansOfTrace
+// The spans ended in the trace, by name
+func spansOfTrace(recorder *tracetest.SpanRecorder, traceID trace.TraceID) map[string][]sdktrace.ReadOnlySpan {
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			spans[span.Name()] = append(spans[span.Name()], span)
+		}
+	}
+	return spans
+}
+
+func TestInitTracing(t *testing.T) {
+	// the provider set by initTracing must not be the first one, `tracer` would follow it
+	recordSpans()
+
+	for exporter, valid := range map[string]bool{"": true, "none": true, "stdout": true, "jaeger": false} {
+		shutdown, err := initTracing(testContext(), &Config{TracingExporter: exporter, TracingServiceName: "test"})
+		if (err == nil) != valid {
+			t.Errorf("exporter %q: expected valid %v, got %v", exporter, valid, err)
+			continue
+		}
+		if err == nil {
+			if err := shutdown(testContext()); err != nil {
+				t.Errorf("exporter %q: shutdown failed: %v", exporter, err)
+			}
+		}
+	}
+}
+
+func TestTracingMiddlewareSpans(t *testing.T) {
+	recorder := recordSpans()
+	newTestGithub(t, githubfake.Options{Seed: 1, Count: 5, FirstID: 1})
+
+	// the caller's trace is continued
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	parentID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+
+	req := httptest.NewRequest(http.MethodGet, "/stats?since=0", nil).WithContext(testContext())
+	req.Header.Set("traceparent", "00-"+traceID.String()+"-"+parentID.String()+"-01")
+	res := httptest.NewRecorder()
+	_ = tracingMiddleware(statsHandlerGet)(res, req, nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected a 200, got %d: %s", res.Code, res.Body.String())
+	}
+
+	spans := spansOfTrace(recorder, traceID)
+
+	requests := spans["GET /stats"]
+	if len(requests) != 1 {
+		t.Fatalf("expected a request span in the caller's trace, got %v", spans)
+	}
+	request := requests[0]
+	if request.SpanKind() != trace.SpanKindServer || request.Parent().SpanID() != parentID {
+		t.Errorf("expected a server span child of the caller, got kind %v and parent %v", request.SpanKind(), request.Parent().SpanID())
+	}
+
+	workers := spans["WorkerStatsTask"]
+	if len(workers) != 5 {
+		t.Fatalf("expected a worker span per repository, got %d", len(workers))
+	}
+	for _, worker := range workers {
+		if worker.Parent().SpanID() != request.SpanContext().SpanID() {
+			t.Errorf("worker span %v is not a child of the request span", worker.SpanContext().SpanID())
+		}
+	}
+
+	// the github calls of a worker are its children
+	workerIDs := map[trace.SpanID]bool{}
+	for _, worker := range workers {
+		workerIDs[worker.SpanContext().SpanID()] = true
+	}
+	fromWorkers := 0
+	for _, call := range spans["HTTP GET"] {
+		if workerIDs[call.Parent().SpanID()] {
+			fromWorkers++
+		}
+	}
+	if fromWorkers != 2*len(workers) {
+		t.Errorf("expected 2 github calls under each worker span, got %d", fromWorkers)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/go-handlers"
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/Scalingo/sclng-backend-test-v1/github"
+)
+
+// github doesn't send payloads bigger than 25MB
+const webhookMaxPayloadSize = 25 << 20
+
+// WebhookDelivery
+// A webhook received from github
+type WebhookDelivery struct {
+	Id         string    `json:"id"`
+	Event      string    `json:"event"`
+	Action     string    `json:"action,omitempty"`
+	Repository string    `json:"repository,omitempty"`
+	Handled    bool      `json:"handled"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// WebhookDeliveries
+// The last deliveries received, used to ignore the ones github sends again
+// a delivery is claimed before it's handled so 2 copies received at the same time are not both handled
+type WebhookDeliveries struct {
+	mu         sync.Mutex
+	size       int
+	deliveries []WebhookDelivery
+	// ids claimed, the ones being handled and the ones recorded
+	claimed map[string]bool
+}
+
+func newWebhookDeliveries(size int) *WebhookDeliveries {
+	return &WebhookDeliveries{size: size, claimed: map[string]bool{}}
+}
+
+// Claim
+// Returns false if the delivery was already received or is being handled
+// the claim is kept with Record once handled, or given up with Release
+func (deliveries *WebhookDeliveries) Claim(id string) bool {
+	deliveries.mu.Lock()
+	defer deliveries.mu.Unlock()
+
+	if deliveries.claimed[id] {
+		return false
+	}
+
+	deliveries.claimed[id] = true
+
+	return true
+}
+
+// Release
+// Give up the claim of a delivery which failed to be handled, github sending it again handles it
+func (deliveries *WebhookDeliveries) Release(id string) {
+	deliveries.mu.Lock()
+	defer deliveries.mu.Unlock()
+
+	delete(deliveries.claimed, id)
+}
+
+// Record
+// Keep a delivery handled, its id must have been claimed
+func (deliveries *WebhookDeliveries) Record(delivery WebhookDelivery) {
+	deliveries.mu.Lock()
+	defer deliveries.mu.Unlock()
+
+	deliveries.claimed[delivery.Id] = true
+	deliveries.deliveries = append(deliveries.deliveries, delivery)
+
+	if overflow := len(deliveries.deliveries) - deliveries.size; overflow > 0 {
+		for _, delivery := range deliveries.deliveries[:overflow] {
+			delete(deliveries.claimed, delivery.Id)
+		}
+		deliveries.deliveries = append([]WebhookDelivery(nil), deliveries.deliveries[overflow:]...)
+	}
+}
+
+// List
+// Most recent first
+func (deliveries *WebhookDeliveries) List() []WebhookDelivery {
+	deliveries.mu.Lock()
+	defer deliveries.mu.Unlock()
+
+	results := make([]WebhookDelivery, 0, len(deliveries.deliveries))
+	for i := len(deliveries.deliveries) - 1; i >= 0; i-- {
+		results = append(results, deliveries.deliveries[i])
+	}
+
+	return results
+}
+
+// verifyWebhookSignature
+// github signs the payload with HMAC-SHA256 and the secret of the webhook
+// X-Hub-Signature-256: sha256=<hex>
+func verifyWebhookSignature(secret string, payload []byte, signature string) bool {
+	hexSignature, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+
+	expected, err := hex.DecodeString(hexSignature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// webhooksHandlerPost
+// Receive the github webhooks
+// repository, star, push and public events update the stored repositories and their cached stats
+// any other event is recorded and acknowledged
+func webhooksHandlerPost(secret string, deliveries *WebhookDeliveries) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+		log := logger.Get(r.Context())
+
+		payload, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxPayloadSize))
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, "fail to read the payload")
+			return nil
+		}
+
+		if !verifyWebhookSignature(secret, payload, r.Header.Get("X-Hub-Signature-256")) {
+			writeJSONError(w, r, http.StatusUnauthorized, "invalid signature")
+			return nil
+		}
+
+		delivery := WebhookDelivery{
+			Id:         r.Header.Get("X-GitHub-Delivery"),
+			Event:      r.Header.Get("X-GitHub-Event"),
+			ReceivedAt: time.Now(),
+		}
+
+		log = log.WithField("github_delivery", delivery.Id).WithField("github_event", delivery.Event)
+
+		if delivery.Id == "" || delivery.Event == "" {
+			writeJSONError(w, r, http.StatusBadRequest, "missing X-GitHub-Delivery or X-GitHub-Event header")
+			return nil
+		}
+
+		status := "processed"
+
+		if !deliveries.Claim(delivery.Id) {
+			// github redelivered it, the store is already or is being updated
+			status = "duplicate"
+		} else {
+			delivery.Action, delivery.Repository, delivery.Handled, err = handleWebhookEvent(delivery.Event, payload)
+			if err != nil {
+				deliveries.Release(delivery.Id)
+				writeJSONError(w, r, http.StatusBadRequest, err.Error())
+				return nil
+			}
+
+			deliveries.Record(delivery)
+
+			if !delivery.Handled {
+				status = "ignored"
+				log.Infof("unknown github event `%s` recorded", delivery.Event)
+			}
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+
+		err = json.NewEncoder(w).Encode(map[string]string{"status": status})
+		if err != nil {
+			log.WithError(err).Error("Fail to encode JSON")
+		}
+
+		return nil
+	}
+}
+
+// handleWebhookEvent
+// Apply the event to the store
+// Returns the action and repository of the event and whether the event type is handled
+func handleWebhookEvent(event string, payload []byte) (string, string, bool, error) {
+	switch event {
+	case "repository", "star", "public":
+		var webhookEvent github.WebhookEvent
+		if err := json.Unmarshal(payload, &webhookEvent); err != nil {
+			return "", "", false, fmt.Errorf("invalid %s payload: %w", event, err)
+		}
+
+		name := fullName(webhookEvent.Repository)
+
+		if event == "repository" && webhookEvent.Action == "deleted" {
+			repositoryStore.Delete(name)
+		} else {
+			// the payload has the whole repository, stars count included
+			repositoryStore.Put(webhookEvent.Repository)
+		}
+
+		return webhookEvent.Action, name, true, nil
+	case "push":
+		var pushEvent github.PushWebhookEvent
+		if err := json.Unmarshal(payload, &pushEvent); err != nil {
+			return "", "", false, fmt.Errorf("invalid push payload: %w", err)
+		}
+
+		// only the repositories we know are updated, a push doesn't carry the whole record
+		repositoryStore.Update(pushEvent.Repository.FullName, func(stored *StoredRepository) {
+			stored.Repository.PushedAt = time.Unix(pushEvent.Repository.PushedAt, 0)
+			stored.Repository.Size = pushEvent.Repository.Size
+			stored.Repository.StargazersCount = pushEvent.Repository.StargazersCount
+			if stored.Stats != nil {
+				stats := *stored.Stats
+				stats.StarCount = pushEvent.Repository.StargazersCount
+				stored.Stats = &stats
+			}
+		})
+
+		return "", pushEvent.Repository.FullName, true, nil
+	default:
+		return "", "", false, nil
+	}
+}
+
+// webhookDeliveriesHandlerGet
+// The last webhooks received, handled or not
+func webhookDeliveriesHandlerGet(deliveries *WebhookDeliveries) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+		log := logger.Get(r.Context())
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err := json.NewEncoder(w).Encode(deliveries.List())
+		if err != nil {
+			log.WithError(err).Error("Fail to encode JSON")
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Scalingo/go-handlers"
+)
+
+func postWebhook(handler handlers.HandlerFunc, secret, id, event, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/github", strings.NewReader(payload))
+	req = req.WithContext(testContext())
+	req.Header.Set("X-GitHub-Delivery", id)
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	res := httptest.NewRecorder()
+	_ = handler(res, req, nil)
+	if res.Code != http.StatusAccepted {
+		return res.Result().Status
+	}
+
+	var body map[string]string
+	_ = json.NewDecoder(res.Body).Decode(&body)
+	return body["status"]
+}
+
+func TestWebhookDeliveryClaimedOnce(t *testing.T) {
+	deliveries := newWebhookDeliveries(10)
+	handler := webhooksHandlerPost("secret", deliveries)
+
+	var wg sync.WaitGroup
+	statuses := make([]string, 20)
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i] = postWebhook(handler, "secret", "delivery-1", "ping", `{}`)
+		}(i)
+	}
+	wg.Wait()
+
+	ignored := 0
+	for _, status := range statuses {
+		switch status {
+		case "ignored":
+			ignored++
+		case "duplicate":
+		default:
+			t.Errorf("unexpected status %q", status)
+		}
+	}
+	if ignored != 1 {
+		t.Errorf("expected the delivery handled once, handled %d times", ignored)
+	}
+	if list := deliveries.List(); len(list) != 1 {
+		t.Errorf("expected a single delivery recorded, got %d", len(list))
+	}
+}
+
+func TestWebhookDeliveryReleasedOnFailure(t *testing.T) {
+	deliveries := newWebhookDeliveries(10)
+	handler := webhooksHandlerPost("secret", deliveries)
+
+	if status := postWebhook(handler, "secret", "delivery-2", "star", `{"repository": `); status != "400 Bad Request" {
+		t.Fatalf("expected the invalid payload refused, got %q", status)
+	}
+	if len(deliveries.List()) != 0 {
+		t.Errorf("the failed delivery was recorded")
+	}
+
+	// github sends it again, it must be handled this time
+	if status := postWebhook(handler, "secret", "delivery-2", "ping", `{}`); status != "ignored" {
+		t.Errorf("expected the delivery sent again to be handled, got %q", status)
+	}
+}